@@ -0,0 +1,34 @@
+// /anomalies.go
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"simple-cost-calculator/internal/anomaly"
+)
+
+// detector is nil when anomaly detection is disabled
+var detector *anomaly.Detector
+
+// handleAnomalies lists the cost anomalies detected, filtered by ?since, ?tenant and ?namespace
+func handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenant, ok := tenantScope(w, r, query.Get("tenant"))
+	if !ok {
+		return
+	}
+	var since time.Time
+	if sinceQuery := query.Get("since"); sinceQuery != "" {
+		t, err := parseTimeParam(sinceQuery)
+		if err != nil {
+			slog.Warn("API request invalid parameters", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+			http.Error(w, fmt.Sprintf("Invalid 'since' time: %v", err), http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	writeJSON(w, http.StatusOK, detector.Report(since, tenant, query.Get("namespace")))
+}
@@ -0,0 +1,65 @@
+// /auth.go
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"net/http"
+
+	"simple-cost-calculator/internal/auth"
+	"simple-cost-calculator/internal/metrics"
+)
+
+// authenticator is nil when authentication is disabled, every request is then unrestricted
+var authenticator *auth.Authenticator
+
+// principal returns the caller of a request, the zero principal (reading nothing) if the middleware did not run
+func principal(r *http.Request) auth.Principal {
+	if authenticator == nil {
+		return auth.Unrestricted
+	}
+	p, _ := auth.FromContext(r.Context())
+	return p
+}
+
+// tenantScope returns the tenant a request is restricted to: the requested one (every tenant if empty) for
+// principals reading every tenant, their own tenant otherwise. Tenant principals asking for another tenant
+// get 403 and ok is false.
+func tenantScope(w http.ResponseWriter, r *http.Request, requested string) (tenant string, ok bool) {
+	p := principal(r)
+	if p.AllTenants() {
+		return requested, true
+	}
+	if p.Tenant == "" || (requested != "" && requested != p.Tenant) {
+		forbid(w, r, p)
+		return "", false
+	}
+	return p.Tenant, true
+}
+
+// visibleTenants drops the tenants the caller of a request may not read
+func visibleTenants[V any](r *http.Request, tenants map[string]V) {
+	p := principal(r)
+	if p.AllTenants() {
+		return
+	}
+	maps.DeleteFunc(tenants, func(tenant string, _ V) bool { return !p.CanRead(tenant) })
+}
+
+// allTenantsOnly restricts a handler to principals reading every tenant
+func allTenantsOnly(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := principal(r); !p.AllTenants() {
+			forbid(w, r, p)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// forbid rejects a request the principal is not allowed to make
+func forbid(w http.ResponseWriter, r *http.Request, p auth.Principal) {
+	metrics.AuthFailures.WithLabelValues("forbidden").Inc()
+	slog.Warn("API request forbidden", "path", r.URL.Path, "method", r.Method, "principal", p.Name, "role", p.Role, "tenant", p.Tenant)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
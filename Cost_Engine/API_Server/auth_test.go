@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simple-cost-calculator/internal/auth"
+	"simple-cost-calculator/internal/types"
+)
+
+func TestTenantScope(t *testing.T) {
+	authenticator = &auth.Authenticator{}
+	defer func() { authenticator = nil }()
+
+	tenantKey := auth.Principal{Name: "user1-key", Role: types.AuthRoleTenant, Tenant: "user1"}
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		requested  string
+		want       string
+		wantStatus int
+		wantGroups []string
+	}{
+		{name: "admin reads every tenant", principal: &auth.Principal{Role: types.AuthRoleAdmin}, want: "", wantGroups: []string{"user1", "user2"}},
+		{name: "service picks a tenant", principal: &auth.Principal{Role: types.AuthRoleService}, requested: "user2", want: "user2", wantGroups: []string{"user1", "user2"}},
+		{name: "tenant reads its own", principal: &tenantKey, want: "user1", wantGroups: []string{"user1"}},
+		{name: "tenant asks for another", principal: &tenantKey, requested: "user2", wantStatus: http.StatusForbidden, wantGroups: []string{"user1"}},
+		{name: "no principal", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/costs/pods", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.NewContext(r.Context(), *tt.principal))
+			}
+			w := httptest.NewRecorder()
+			got, ok := tenantScope(w, r, tt.requested)
+			if ok != (tt.wantStatus == 0) || (!ok && w.Code != tt.wantStatus) {
+				t.Fatalf("tenantScope() ok = %v, status %d, want status %d", ok, w.Code, tt.wantStatus)
+			}
+			if got != tt.want {
+				t.Errorf("tenantScope() = %q, want %q", got, tt.want)
+			}
+
+			// /metrics carries every tenant's costs
+			w = httptest.NewRecorder()
+			allTenantsOnly(http.NotFoundHandler())(w, r)
+			if metricsAllowed := w.Code != http.StatusForbidden; metricsAllowed != (len(tt.wantGroups) == 2) {
+				t.Errorf("/metrics status = %d, want it readable only when every tenant is", w.Code)
+			}
+
+			// The /getcost groups of RearrangeCosts
+			groups := map[string]types.GroupedCostSummary{"user1": {}, "user2": {}}
+			visibleTenants(r, groups)
+			if len(groups) != len(tt.wantGroups) {
+				t.Fatalf("visible groups = %v, want %v", groups, tt.wantGroups)
+			}
+			for _, group := range tt.wantGroups {
+				if _, ok := groups[group]; !ok {
+					t.Errorf("group %s is not visible, want %v", group, tt.wantGroups)
+				}
+			}
+		})
+	}
+}
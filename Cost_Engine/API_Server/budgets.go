@@ -0,0 +1,126 @@
+// /budgets.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"simple-cost-calculator/internal/budget"
+	"simple-cost-calculator/internal/types"
+)
+
+// budgets is nil when the budget subsystem is disabled
+var budgets *budget.Manager
+
+// handleBudgets lists the budgets of the tenants the caller reads with their status (GET) or creates one (POST).
+// Only admins change budgets.
+func handleBudgets(w http.ResponseWriter, r *http.Request) {
+	p := principal(r)
+	if r.Method != http.MethodGet && !p.CanManage() {
+		forbid(w, r, p)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list := slices.DeleteFunc(budgets.List(), func(status types.BudgetStatus) bool { return !p.CanRead(status.Tenant) })
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var b types.Budget
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			http.Error(w, "Invalid budget JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := budgets.Create(b); err != nil {
+			writeBudgetError(w, err)
+			return
+		}
+		slog.Info("Budget created via API", "budget", b.Name, "tenant", b.Tenant)
+		writeBudget(w, http.StatusCreated, b.Name)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBudget reads (GET), creates or replaces (PUT) or deletes (DELETE) the budget named in the path
+func handleBudget(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	p := principal(r)
+	if r.Method != http.MethodGet && !p.CanManage() {
+		forbid(w, r, p)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		// Budgets of other tenants do not exist for tenant callers
+		if status, err := budgets.Get(name); err == nil && !p.CanRead(status.Tenant) {
+			writeBudgetError(w, budget.ErrNotFound)
+			return
+		}
+		writeBudget(w, http.StatusOK, name)
+	case http.MethodPut:
+		var b types.Budget
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			http.Error(w, "Invalid budget JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.Name = name
+		created, err := budgets.Put(b)
+		if err != nil {
+			writeBudgetError(w, err)
+			return
+		}
+		slog.Info("Budget saved via API", "budget", name, "tenant", b.Tenant, "created", created)
+		code := http.StatusOK
+		if created {
+			code = http.StatusCreated
+		}
+		writeBudget(w, code, name)
+	case http.MethodDelete:
+		if err := budgets.Delete(name); err != nil {
+			writeBudgetError(w, err)
+			return
+		}
+		slog.Info("Budget deleted via API", "budget", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeBudget(w http.ResponseWriter, code int, name string) {
+	status, err := budgets.Get(name)
+	if err != nil {
+		writeBudgetError(w, err)
+		return
+	}
+	writeJSON(w, code, status)
+}
+
+func writeBudgetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, budget.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, budget.ErrExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, budget.ErrInvalid):
+		slog.Warn("Budget change rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		// The budget file could not be written
+		slog.Error("Error saving budget change", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding JSON response", "error", err)
+	}
+}
@@ -0,0 +1,217 @@
+// /export.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// exportFormat is the response encoding negotiated for a cost request
+type exportFormat string
+
+const (
+	formatJSON   exportFormat = "json"
+	formatCSV    exportFormat = "csv"
+	formatNDJSON exportFormat = "ndjson"
+)
+
+// flushEvery bounds how many rows are buffered before the response is flushed to the client
+const flushEvery = 500
+
+// costRow is one flat export row. Namespace level rows leave Pod and Container empty,
+// tiered pricing discounts appear as "discount:<resource>" namespace rows.
+type costRow struct {
+	Tenant       string    `json:"tenant"`
+	Namespace    string    `json:"namespace"`
+	Pod          string    `json:"pod"`
+	Container    string    `json:"container"`
+	WindowStart  time.Time `json:"windowStart"`
+	WindowEnd    time.Time `json:"windowEnd"`
+	CPUCoreHours float64   `json:"cpuCoreHours"`
+	RAMGiBHours  float64   `json:"ramGiBHours"`
+	CPUCost      float64   `json:"cpuCost"`
+	RAMCost      float64   `json:"ramCost"`
+	Total        float64   `json:"total"`
+	// Cluster of pod rows when clusters are named, namespace rows sum all clusters
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// costRowColumns is the CSV header, the column order is part of the API
+var costRowColumns = []string{
+	"tenant", "namespace", "pod", "container", "window_start", "window_end",
+	"cpu_core_hours", "ram_gib_hours", "cpu_cost", "ram_cost", "total", "cluster",
+}
+
+func (row costRow) csvRecord() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{
+		row.Tenant, row.Namespace, row.Pod, row.Container,
+		row.WindowStart.Format(time.RFC3339), row.WindowEnd.Format(time.RFC3339),
+		f(row.CPUCoreHours), f(row.RAMGiBHours), f(row.CPUCost), f(row.RAMCost), f(row.Total), row.Cluster,
+	}
+}
+
+// parseExportFormat reads ?format=json|csv|ndjson, falling back to the Accept header: the known media type
+// with the highest q-value, the first listed on ties
+func parseExportFormat(r *http.Request) (exportFormat, error) {
+	if formatQuery := r.URL.Query().Get("format"); formatQuery != "" {
+		switch format := exportFormat(strings.ToLower(formatQuery)); format {
+		case formatJSON, formatCSV, formatNDJSON:
+			return format, nil
+		default:
+			return "", fmt.Errorf("Invalid 'format' (json, csv, ndjson)")
+		}
+	}
+	mediaFormats := map[string]exportFormat{"text/csv": formatCSV, "application/x-ndjson": formatNDJSON, "application/json": formatJSON}
+	best, bestQ := formatJSON, 0.0
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		format, ok := mediaFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		// q=0 marks a media type as not acceptable
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, nil
+}
+
+// writeCostRows streams rows as CSV or NDJSON, flushing periodically so large windows are not buffered
+func writeCostRows(w http.ResponseWriter, format exportFormat, rows iter.Seq[costRow]) {
+	flusher, _ := w.(http.Flusher)
+	flush := func(n int) {
+		if flusher != nil && n%flushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="costs.csv"`)
+		w.WriteHeader(http.StatusOK)
+		cw := csv.NewWriter(w)
+		cw.Write(costRowColumns)
+		n := 0
+		for row := range rows {
+			cw.Write(row.csvRecord())
+			if n++; n%flushEvery == 0 {
+				cw.Flush()
+				flush(n)
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			slog.Error("Error writing CSV response", "error", err)
+		}
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		n := 0
+		for row := range rows {
+			if err := enc.Encode(row); err != nil {
+				slog.Error("Error writing NDJSON response", "error", err)
+				return
+			}
+			n++
+			flush(n)
+		}
+	}
+}
+
+// podCostRows flattens pod (or container) cost rows
+func podCostRows(podCosts []types.PodCost) iter.Seq[costRow] {
+	return func(yield func(costRow) bool) {
+		for _, pc := range podCosts {
+			row := costRow{
+				Cluster:      pc.Cluster,
+				Tenant:       pc.Tenant,
+				Namespace:    pc.Namespace,
+				Pod:          pc.Pod,
+				Container:    pc.Container,
+				WindowStart:  pc.Window.Start,
+				WindowEnd:    pc.Window.End,
+				CPUCoreHours: pc.CPUBilledCoreHours,
+				RAMGiBHours:  pc.RAMBilledGiBHours,
+				CPUCost:      pc.CPUCost,
+				RAMCost:      pc.RAMCost,
+				Total:        pc.TotalCost,
+			}
+			if !yield(row) {
+				return
+			}
+		}
+	}
+}
+
+// namespaceCostRows flattens aggregated tenant costs into one row per namespace and discount line, ordered by tenant
+func namespaceCostRows(tenants map[string]*types.TenantCost) iter.Seq[costRow] {
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(yield func(costRow) bool) {
+		for _, name := range names {
+			tc := tenants[name]
+			for _, ns := range tc.Namespaces {
+				row := costRow{
+					Tenant:       name,
+					Namespace:    ns.Name,
+					WindowStart:  tc.Window.Start,
+					WindowEnd:    tc.Window.End,
+					CPUCoreHours: ns.Resources.CPU.Quantity,
+					RAMGiBHours:  ns.Resources.RAM.Quantity,
+					CPUCost:      ns.Resources.CPU.Cost,
+					RAMCost:      ns.Resources.RAM.Cost,
+					Total:        ns.Total,
+				}
+				if !yield(row) {
+					return
+				}
+			}
+			// Discount lines carry negated amounts, so the total column sums to what the tenant is charged
+			for _, line := range tc.Discounts {
+				row := costRow{
+					Tenant:      name,
+					Namespace:   "discount:" + line.Resource,
+					WindowStart: tc.Window.Start,
+					WindowEnd:   tc.Window.End,
+					Total:       -line.Discount,
+				}
+				if line.Resource == "cpu" {
+					row.CPUCost = -line.Discount
+				} else {
+					row.RAMCost = -line.Discount
+				}
+				if !yield(row) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		accept  string
+		want    exportFormat
+		wantErr bool
+	}{
+		{name: "default", want: formatJSON},
+		{name: "query csv", query: "format=csv", want: formatCSV},
+		{name: "query wins over accept", query: "format=ndjson", accept: "text/csv", want: formatNDJSON},
+		{name: "accept csv", accept: "text/csv", want: formatCSV},
+		{name: "accept list", accept: "application/x-ndjson;q=0.9, text/csv", want: formatCSV},
+		{name: "accept q-values", accept: "text/csv;q=0.5, application/x-ndjson;q=0.8, application/json;q=0.1", want: formatNDJSON},
+		{name: "accept ties", accept: "application/x-ndjson, text/csv", want: formatNDJSON},
+		{name: "not acceptable", accept: "text/csv;q=0", want: formatJSON},
+		{name: "browser accept", accept: "text/html,application/xhtml+xml,*/*;q=0.8", want: formatJSON},
+		{name: "invalid", query: "format=xlsx", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v2/costs?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			got, err := parseExportFormat(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("format = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCostRowsCSV(t *testing.T) {
+	window := types.Window{Start: time.Unix(0, 0).UTC(), End: time.Unix(3600, 0).UTC()}
+	podCosts := []types.PodCost{
+		{Tenant: "user1", Namespace: "ns1-user1", Pod: "web", Window: window, CPUBilledCoreHours: 0.5, RAMBilledGiBHours: 2, CPUCost: 1, RAMCost: 0.25, TotalCost: 1.25},
+		{Cluster: "eu", Tenant: "user1", Namespace: "ns1-user1", Pod: "web", Window: window, CPUCost: 2, TotalCost: 2},
+	}
+
+	rec := httptest.NewRecorder()
+	writeCostRows(rec, formatCSV, podCostRows(podCosts))
+
+	want := "tenant,namespace,pod,container,window_start,window_end,cpu_core_hours,ram_gib_hours,cpu_cost,ram_cost,total,cluster\n" +
+		"user1,ns1-user1,web,,1970-01-01T00:00:00Z,1970-01-01T01:00:00Z,0.5,2,1,0.25,1.25,\n" +
+		"user1,ns1-user1,web,,1970-01-01T00:00:00Z,1970-01-01T01:00:00Z,0,0,2,0,2,eu\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("CSV body =\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
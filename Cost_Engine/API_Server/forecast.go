@@ -0,0 +1,92 @@
+// /forecast.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"simple-cost-calculator/internal/forecast"
+	"simple-cost-calculator/internal/types"
+)
+
+// Forecasts fit two weeks of hourly costs by default, enough for the day-of-week model
+const (
+	defaultForecastLookback = 14 * 24 * time.Hour
+	defaultForecastStep     = time.Hour
+)
+
+// handleForecast returns the projected month-end spend of every tenant, or of ?tenant only.
+// Optional parameters are model (auto, linear, dayofweek), lookback and step.
+func handleForecast(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	tenant, ok := tenantScope(w, r, r.URL.Query().Get("tenant"))
+	if !ok {
+		return
+	}
+	opts, err := parseForecastOptions(r)
+	if err != nil {
+		slog.Warn("API request invalid parameters", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.Info("API forecast request received", "model", opts.Model, "lookback", opts.Lookback, "step", opts.Step)
+
+	result, err := forecast.Forecast(ctx, calc, time.Now(), opts)
+	if err != nil {
+		slog.Error("Error calculating forecast via API", "error", err)
+		http.Error(w, "Internal Server Error: Failed to calculate forecast.", http.StatusInternalServerError)
+		return
+	}
+	if tenant != "" {
+		filtered := make(map[string]*types.TenantForecast)
+		if tf, ok := result.Tenants[tenant]; ok {
+			filtered[tenant] = tf
+		}
+		result.Tenants = filtered
+	}
+
+	slog.Info("Forecast calculated successfully via API", "tenants", len(result.Tenants))
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseForecastOptions reads model/lookback/step from the query string
+func parseForecastOptions(r *http.Request) (forecast.Options, error) {
+	query := r.URL.Query()
+	opts := forecast.Options{Step: defaultForecastStep, Lookback: defaultForecastLookback}
+
+	model, err := forecast.ParseModel(query.Get("model"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Model = model
+
+	if stepQuery := query.Get("step"); stepQuery != "" {
+		step, err := time.ParseDuration(stepQuery)
+		if err != nil || step <= 0 {
+			return opts, fmt.Errorf("Invalid 'step' duration format: %v", err)
+		}
+		opts.Step = step
+	}
+	if lookbackQuery := query.Get("lookback"); lookbackQuery != "" {
+		lookback, err := time.ParseDuration(lookbackQuery)
+		if err != nil || lookback < opts.Step {
+			return opts, fmt.Errorf("Invalid 'lookback' duration, it must be at least one step: %v", err)
+		}
+		opts.Lookback = lookback
+	}
+	return opts, nil
+}
+
+// forecastTenants returns the month-end forecast of every tenant with the default options, for budgets
+func forecastTenants(ctx context.Context, now time.Time) (map[string]*types.TenantForecast, error) {
+	result, err := forecast.Forecast(ctx, calc, now, forecast.Options{Model: forecast.ModelAuto, Step: defaultForecastStep, Lookback: defaultForecastLookback})
+	if err != nil {
+		return nil, err
+	}
+	return result.Tenants, nil
+}
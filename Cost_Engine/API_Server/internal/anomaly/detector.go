@@ -0,0 +1,292 @@
+// internal/anomaly/detector.go
+
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/metrics"
+	"simple-cost-calculator/internal/types"
+	"simple-cost-calculator/internal/webhook"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Detected anomalies are kept in memory for the API for this long
+const retention = 7 * 24 * time.Hour
+
+// Options define how step costs are scored
+type Options struct {
+	Method types.AnomalyMethod
+	// Threshold score above which a step is anomalous, the method default if 0
+	Threshold float64
+	// Baseline is the rolling window each step is compared with
+	Baseline time.Duration
+	// MinPoints is how many baseline steps a series needs before it is scored
+	MinPoints int
+}
+
+// seriesKey identifies a tenant (namespace empty) or namespace cost series
+type seriesKey struct {
+	tenant, namespace string
+}
+
+// Detector periodically calculates the per-step cost of every tenant and namespace and flags
+// the steps that spike above their rolling baseline
+type Detector struct {
+	mu        sync.RWMutex
+	anomalies []types.CostAnomaly // oldest first
+
+	calc     *calculator.CostCalculator
+	interval time.Duration
+	step     time.Duration
+	opts     Options
+	webhooks []types.WebhookConfig
+	client   *webhook.Client
+
+	// Only touched by refresh
+	lastEnd  time.Time
+	baseline map[seriesKey][]types.CostPoint
+
+	score    *prometheus.GaugeVec
+	detected *prometheus.CounterVec
+}
+
+// NewDetector creates a detector refreshing every interval and registers its metrics with reg
+func NewDetector(calc *calculator.CostCalculator, interval, step time.Duration, opts Options, webhooks []types.WebhookConfig, reg prometheus.Registerer) (*Detector, error) {
+	switch opts.Method {
+	case types.AnomalyZScore, types.AnomalyMAD:
+	default:
+		return nil, fmt.Errorf("invalid anomaly method '%s' (zscore, mad)", opts.Method)
+	}
+	if opts.Threshold == 0 {
+		opts.Threshold = DefaultThreshold(opts.Method)
+	}
+	if opts.Threshold < 0 {
+		return nil, fmt.Errorf("anomaly threshold must be > 0")
+	}
+	if opts.Baseline < 2*step {
+		return nil, fmt.Errorf("anomaly baseline (%s) must cover at least two steps (%s)", opts.Baseline, step)
+	}
+	for _, hook := range webhooks {
+		if hook.Format != types.WebhookGeneric && hook.Format != types.WebhookAlertmanager {
+			return nil, fmt.Errorf("invalid webhook format '%s' (generic, alertmanager)", hook.Format)
+		}
+	}
+	if opts.MinPoints < 2 {
+		opts.MinPoints = 2
+	}
+
+	labels := []string{"tenant", "namespace"}
+	d := &Detector{
+		calc:     calc,
+		interval: interval,
+		step:     step,
+		opts:     opts,
+		webhooks: webhooks,
+		client:   webhook.NewClient(),
+		baseline: make(map[seriesKey][]types.CostPoint),
+		score: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "cost_anomaly_score",
+			Help:      "Anomaly score of the last step cost of each tenant (namespace empty) and namespace against its rolling baseline.",
+		}, labels),
+		detected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Name:      "cost_anomalies_total",
+			Help:      "Steps whose cost spiked above the anomaly threshold, per tenant (namespace empty) and namespace.",
+		}, labels),
+	}
+	reg.MustRegister(d.score, d.detected)
+	return d, nil
+}
+
+// Run refreshes the detector until ctx is cancelled
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.refresh(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Anomaly detector stopped")
+			return
+		}
+	}
+}
+
+// Report returns the anomalies detected at or after since, of tenant and namespace when not empty
+func (d *Detector) Report(since time.Time, tenant, namespace string) types.AnomalyReport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	report := types.AnomalyReport{
+		Method:    d.opts.Method,
+		Threshold: d.opts.Threshold,
+		Baseline:  d.opts.Baseline.String(),
+		Step:      d.step.String(),
+		Anomalies: []types.CostAnomaly{},
+	}
+	for _, a := range d.anomalies {
+		if a.Timestamp.Before(since) || tenant != "" && a.Tenant != tenant || namespace != "" && a.Namespace != namespace {
+			continue
+		}
+		report.Anomalies = append(report.Anomalies, a)
+	}
+	return report
+}
+
+// refresh scores the steps since the previous refresh. The first refresh only fills the baseline.
+// The newest step is left out as its samples may not be scraped yet.
+func (d *Detector) refresh(ctx context.Context, now time.Time) {
+	end := now.Truncate(d.step).Add(-d.step)
+	start := d.lastEnd
+	if start.IsZero() {
+		start = end.Add(-d.opts.Baseline).Truncate(d.step)
+	}
+	if end.Sub(start) < d.step {
+		return
+	}
+
+	series, err := d.calc.CalculateCostTimeSeries(ctx, start, end, d.step, calculator.CalcOptions{})
+	if err != nil {
+		slog.Error("Error refreshing anomaly detector", "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339), "error", err)
+		return
+	}
+	currency := d.calc.Pricing().Currency
+	score := !d.lastEnd.IsZero()
+
+	var found []types.CostAnomaly
+	for _, s := range series.Tenants {
+		found = append(found, d.observe(seriesKey{tenant: s.Tenant}, s.Points, score, currency)...)
+	}
+	for _, s := range series.Namespaces {
+		found = append(found, d.observe(seriesKey{tenant: s.Tenant, namespace: s.Namespace}, s.Points, score, currency)...)
+	}
+	// Forget series without cost in the whole baseline (namespace deleted)
+	for key, points := range d.baseline {
+		if len(points) == 0 || !points[len(points)-1].Timestamp.After(end.Add(-d.opts.Baseline)) {
+			delete(d.baseline, key)
+			d.score.DeleteLabelValues(key.tenant, key.namespace)
+		}
+	}
+	d.lastEnd = end
+	d.record(found, now)
+
+	if len(found) > 0 {
+		slog.Warn("Cost anomalies detected", "count", len(found))
+		d.notify(ctx, found, now)
+	}
+	slog.Debug("Anomaly detector refreshed", "series", len(d.baseline), "anomalies", len(found), "end", end.Format(time.RFC3339))
+}
+
+// observe scores the new points of a series against the baseline preceding each of them, then
+// adds them to the baseline
+func (d *Detector) observe(key seriesKey, points []types.CostPoint, score bool, currency string) []types.CostAnomaly {
+	if key.tenant == types.IdleGroupKey {
+		return nil
+	}
+	var found []types.CostAnomaly
+	for _, p := range points {
+		baseline := d.baseline[key]
+		if score && len(baseline) >= d.opts.MinPoints {
+			costs := make([]float64, len(baseline))
+			for i, b := range baseline {
+				costs[i] = b.TotalCost
+			}
+			center, deviation, s := scoreCost(d.opts.Method, costs, p.TotalCost)
+			d.score.WithLabelValues(key.tenant, key.namespace).Set(s)
+			if s >= d.opts.Threshold {
+				found = append(found, types.CostAnomaly{
+					Tenant:    key.tenant,
+					Namespace: key.namespace,
+					Timestamp: p.Timestamp,
+					Cost:      p.TotalCost,
+					Baseline:  center,
+					Deviation: deviation,
+					Score:     s,
+					Method:    d.opts.Method,
+					Currency:  currency,
+				})
+				d.detected.WithLabelValues(key.tenant, key.namespace).Inc()
+			}
+		}
+
+		// Keep the steps of the baseline window preceding the next step
+		cutoff := p.Timestamp.Add(d.step - d.opts.Baseline)
+		i := 0
+		for i < len(baseline) && baseline[i].Timestamp.Before(cutoff) {
+			i++
+		}
+		d.baseline[key] = append(baseline[i:], p)
+	}
+	return found
+}
+
+// record adds detected anomalies and drops those past retention
+func (d *Detector) record(found []types.CostAnomaly, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.anomalies = append(d.anomalies, found...)
+	cutoff := now.Add(-retention)
+	i := 0
+	for i < len(d.anomalies) && d.anomalies[i].Timestamp.Before(cutoff) {
+		i++
+	}
+	d.anomalies = d.anomalies[i:]
+}
+
+// notify posts each anomaly to generic webhooks, and all of them as alerts lasting one
+// refresh interval to Alertmanager webhooks
+func (d *Detector) notify(ctx context.Context, found []types.CostAnomaly, now time.Time) {
+	for _, hook := range d.webhooks {
+		var payloads []any
+		switch hook.Format {
+		case types.WebhookAlertmanager:
+			alerts := make([]webhook.AlertmanagerAlert, 0, len(found))
+			for _, a := range found {
+				alerts = append(alerts, toAlertmanager(a, now.Add(d.interval)))
+			}
+			payloads = append(payloads, alerts)
+		default:
+			for _, a := range found {
+				payloads = append(payloads, a)
+			}
+		}
+		for _, payload := range payloads {
+			if err := d.client.Post(ctx, hook.URL, payload); err != nil {
+				slog.Error("Error sending anomaly alert", "url", hook.URL, "error", err)
+			}
+		}
+	}
+}
+
+func toAlertmanager(a types.CostAnomaly, endsAt time.Time) webhook.AlertmanagerAlert {
+	scope := "tenant " + a.Tenant
+	if a.Namespace != "" {
+		scope = "namespace " + a.Namespace + " of tenant " + a.Tenant
+	}
+	return webhook.AlertmanagerAlert{
+		Labels: map[string]string{
+			"alertname": "TenantCostAnomaly",
+			"tenant":    a.Tenant,
+			"namespace": a.Namespace,
+			"method":    string(a.Method),
+			"severity":  "warning",
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Cost of %s spiked", scope),
+			"description": fmt.Sprintf("Step ending %s cost %.4f %s against a baseline of %.4f (score %s).",
+				a.Timestamp.Format(time.RFC3339), a.Cost, a.Currency, a.Baseline, strconv.FormatFloat(a.Score, 'f', 1, 64)),
+		},
+		StartsAt: a.Timestamp,
+		EndsAt:   endsAt,
+	}
+}
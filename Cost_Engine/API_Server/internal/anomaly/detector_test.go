@@ -0,0 +1,69 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScoreCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     types.AnomalyMethod
+		baseline   []float64
+		cost       float64
+		wantCenter float64
+		wantScore  float64
+	}{
+		{name: "zscore", method: types.AnomalyZScore, baseline: []float64{1, 2, 3}, cost: 4, wantCenter: 2, wantScore: 2},
+		{name: "mad ignores a past spike", method: types.AnomalyMAD, baseline: []float64{1, 1, 2, 2, 100}, cost: 2, wantCenter: 2, wantScore: 0},
+		{name: "flat baseline uses the relative floor", method: types.AnomalyMAD, baseline: []float64{2, 2, 2}, cost: 3, wantCenter: 2, wantScore: 5},
+		{name: "drop scores negative", method: types.AnomalyZScore, baseline: []float64{1, 2, 3}, cost: 0, wantCenter: 2, wantScore: -2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			center, _, s := scoreCost(tt.method, tt.baseline, tt.cost)
+			if math.Abs(center-tt.wantCenter) > 1e-9 || math.Abs(s-tt.wantScore) > 1e-9 {
+				t.Errorf("center %v score %v, want %v and %v", center, s, tt.wantCenter, tt.wantScore)
+			}
+		})
+	}
+}
+
+func TestDetectorObserve(t *testing.T) {
+	d, err := NewDetector(nil, time.Minute, time.Minute, Options{Method: types.AnomalyMAD, Baseline: 10 * time.Minute, MinPoints: 5}, nil, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := seriesKey{tenant: "user1", namespace: "ns1-user1"}
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	point := func(i int, cost float64) types.CostPoint {
+		return types.CostPoint{Timestamp: start.Add(time.Duration(i) * time.Minute), TotalCost: cost}
+	}
+
+	// Baseline fill, never scored
+	var fill []types.CostPoint
+	for i := 0; i < 10; i++ {
+		fill = append(fill, point(i, 1+0.01*float64(i%3)))
+	}
+	if found := d.observe(key, fill, false, "USD"); len(found) != 0 {
+		t.Fatalf("baseline fill flagged %v", found)
+	}
+
+	found := d.observe(key, []types.CostPoint{point(10, 1.01), point(11, 5), point(12, 1)}, true, "USD")
+	if len(found) != 1 || !found[0].Timestamp.Equal(start.Add(11*time.Minute)) || found[0].Namespace != "ns1-user1" {
+		t.Fatalf("found %+v, want the step at minute 11 only", found)
+	}
+	if got := len(d.baseline[key]); got != 10 {
+		t.Errorf("baseline keeps %d points, want 10", got)
+	}
+
+	// Idle capacity is not a tenant
+	if found := d.observe(seriesKey{tenant: types.IdleGroupKey}, []types.CostPoint{point(13, 100)}, true, "USD"); found != nil {
+		t.Errorf("idle series flagged %v", found)
+	}
+}
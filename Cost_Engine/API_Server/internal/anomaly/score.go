@@ -0,0 +1,75 @@
+// internal/anomaly/score.go
+
+package anomaly
+
+import (
+	"math"
+	"slices"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// madScale makes the median absolute deviation comparable to a standard deviation for normal data
+const madScale = 1.4826
+
+// Deviation floors, so a perfectly flat baseline does not turn any change into an anomaly
+const (
+	minRelativeDeviation = 0.1
+	minAbsoluteDeviation = 1e-6
+)
+
+// DefaultThreshold returns the score above which a step is anomalous for a method
+func DefaultThreshold(method types.AnomalyMethod) float64 {
+	if method == types.AnomalyMAD {
+		return 3.5
+	}
+	return 3
+}
+
+// scoreCost compares cost with the baseline costs, returning the baseline centre, its deviation and
+// how many deviations cost lies above it
+func scoreCost(method types.AnomalyMethod, baseline []float64, cost float64) (center, deviation, s float64) {
+	if method == types.AnomalyMAD {
+		center = median(baseline)
+		deviations := make([]float64, len(baseline))
+		for i, v := range baseline {
+			deviations[i] = math.Abs(v - center)
+		}
+		deviation = madScale * median(deviations)
+	} else {
+		center, deviation = meanStdDev(baseline)
+	}
+	deviation = math.Max(deviation, math.Max(minRelativeDeviation*math.Abs(center), minAbsoluteDeviation))
+	return center, deviation, (cost - center) / deviation
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func meanStdDev(values []float64) (mean, stdDev float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var sumSq float64
+	for _, v := range values {
+		sumSq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sumSq / float64(len(values)-1))
+}
@@ -0,0 +1,144 @@
+// internal/auth/auth.go
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"simple-cost-calculator/internal/metrics"
+	"simple-cost-calculator/internal/types"
+)
+
+var (
+	// ErrNoCredentials is returned when a request carries neither an API key nor a bearer token
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned for unknown API keys and tokens that fail verification
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Name of the API key, or subject of the JWT
+	Name   string
+	Role   types.AuthRole
+	Tenant string
+}
+
+// AllTenants reports whether the principal reads every tenant
+func (p Principal) AllTenants() bool {
+	return p.Role == types.AuthRoleAdmin || p.Role == types.AuthRoleService
+}
+
+// CanRead reports whether the principal may read the costs of a tenant
+func (p Principal) CanRead(tenant string) bool {
+	return p.AllTenants() || (p.Role == types.AuthRoleTenant && p.Tenant != "" && p.Tenant == tenant)
+}
+
+// CanManage reports whether the principal may change budgets
+func (p Principal) CanManage() bool {
+	return p.Role == types.AuthRoleAdmin
+}
+
+// Unrestricted is the principal of every request when authentication is disabled
+var Unrestricted = Principal{Name: "anonymous", Role: types.AuthRoleAdmin}
+
+type principalKey struct{}
+
+// NewContext returns a context carrying the principal
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored by the middleware, if any
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// apiKey is a static key, kept as its digest so keys are compared in constant time
+type apiKey struct {
+	digest    [sha256.Size]byte
+	principal Principal
+}
+
+// Authenticator verifies the API keys and bearer JWTs of requests
+type Authenticator struct {
+	keys []apiKey
+	jwt  *jwtVerifier // nil when JWTs are not accepted
+}
+
+// NewAuthenticator creates an authenticator from a validated auth configuration, loading its JWKS file
+func NewAuthenticator(conf *types.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{}
+	for _, key := range conf.APIKeys {
+		a.keys = append(a.keys, apiKey{
+			digest:    sha256.Sum256([]byte(key.Key)),
+			principal: Principal{Name: key.Name, Role: key.Role, Tenant: key.Tenant},
+		})
+	}
+	if conf.JWT != nil {
+		verifier, err := newJWTVerifier(*conf.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = verifier
+	}
+	return a, nil
+}
+
+// Authenticate returns the principal of a request's X-API-Key header or bearer token, a bearer token being
+// tried as an API key before it is verified as a JWT
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if strings.EqualFold(scheme, "Bearer") {
+			token = strings.TrimSpace(credentials)
+		}
+	}
+	if token == "" {
+		return Principal{}, ErrNoCredentials
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(digest[:], key.digest[:]) == 1 {
+			return key.principal, nil
+		}
+	}
+	if a.jwt != nil && strings.Count(token, ".") == 2 {
+		p, err := a.jwt.verify(token)
+		if err != nil {
+			return Principal{}, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+		}
+		return p, nil
+	}
+	return Principal{}, ErrInvalidCredentials
+}
+
+// Middleware rejects requests without valid credentials with 401 and passes the principal of the others
+// to next in their context
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.Authenticate(r)
+		if err != nil {
+			reason := "invalid"
+			if errors.Is(err, ErrNoCredentials) {
+				reason = "missing"
+			}
+			metrics.AuthFailures.WithLabelValues(reason).Inc()
+			slog.Warn("API request rejected", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cost-api"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+	})
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// signToken returns a compact JWT of claims signed with an Ed25519 or P-256 key
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	case *ecdsa.PrivateKey:
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticate(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": b64(edKey.Public().(ed25519.PublicKey))},
+		{"kty": "EC", "crv": "P-256", "kid": "ec", "alg": "ES256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAuthenticator(&types.AuthConfig{
+		APIKeys: []types.APIKey{
+			{Name: "payment-engine", Key: "service-secret", Role: types.AuthRoleService},
+			{Name: "user1-key", Key: "user1-secret", Role: types.AuthRoleTenant, Tenant: "user1"},
+		},
+		JWT: &types.JWTConfig{JWKSFile: jwksFile, Issuer: "https://idp.example.com", Audience: "cost-api", TenantClaim: "tenant", RoleClaim: "role"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "https://idp.example.com", "aud": []string{"cost-api"}, "exp": now.Add(time.Hour).Unix(), "tenant": "user2"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		want    Principal
+		wantErr error
+	}{
+		{name: "service API key", header: "Authorization", value: "Bearer service-secret", want: Principal{Name: "payment-engine", Role: types.AuthRoleService}},
+		{name: "tenant API key header", header: "X-API-Key", value: "user1-secret", want: Principal{Name: "user1-key", Role: types.AuthRoleTenant, Tenant: "user1"}},
+		{name: "unknown API key", header: "X-API-Key", value: "guess", wantErr: ErrInvalidCredentials},
+		{name: "no credentials", wantErr: ErrNoCredentials},
+		{name: "tenant JWT", header: "Authorization", value: "Bearer " + signToken(t, "EdDSA", "ed", edKey, claims(nil)), want: Principal{Name: "alice", Role: types.AuthRoleTenant, Tenant: "user2"}},
+		{name: "admin JWT", header: "Authorization", value: "Bearer " + signToken(t, "ES256", "ec", ecKey, claims(map[string]any{"role": "admin"})), want: Principal{Name: "alice", Role: types.AuthRoleAdmin}},
+		{name: "expired JWT", header: "Authorization", value: "Bearer " + signToken(t, "EdDSA", "ed", edKey, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})), wantErr: ErrInvalidCredentials},
+		{name: "JWT for another audience", header: "Authorization", value: "Bearer " + signToken(t, "EdDSA", "ed", edKey, claims(map[string]any{"aud": "other"})), wantErr: ErrInvalidCredentials},
+		{name: "JWT without tenant", header: "Authorization", value: "Bearer " + signToken(t, "EdDSA", "ed", edKey, claims(map[string]any{"tenant": nil})), wantErr: ErrInvalidCredentials},
+		{name: "JWT signed with another key", header: "Authorization", value: "Bearer " + signToken(t, "EdDSA", "ed", otherKey, claims(nil)), wantErr: ErrInvalidCredentials},
+		{name: "JWT with an algorithm the key is not for", header: "Authorization", value: "Bearer " + signToken(t, "EdDSA", "ec", edKey, claims(nil)), wantErr: ErrInvalidCredentials},
+		{name: "unsigned JWT", header: "Authorization", value: "Bearer " + signToken(t, "none", "ed", nil, claims(map[string]any{"role": "admin"})), wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/getcost", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			got, err := a.Authenticate(r)
+			if !errors.Is(err, tt.wantErr) || (err != nil && tt.wantErr == nil) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Authenticate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalCanRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal Principal
+		tenant    string
+		want      bool
+	}{
+		{name: "admin", principal: Principal{Role: types.AuthRoleAdmin}, tenant: "user1", want: true},
+		{name: "service", principal: Principal{Role: types.AuthRoleService}, tenant: "user1", want: true},
+		{name: "own tenant", principal: Principal{Role: types.AuthRoleTenant, Tenant: "user1"}, tenant: "user1", want: true},
+		{name: "other tenant", principal: Principal{Role: types.AuthRoleTenant, Tenant: "user1"}, tenant: "user2", want: false},
+		{name: "zero principal", principal: Principal{}, tenant: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.CanRead(tt.tenant); got != tt.want {
+				t.Errorf("CanRead(%s) = %v, want %v", tt.tenant, got, tt.want)
+			}
+		})
+	}
+}
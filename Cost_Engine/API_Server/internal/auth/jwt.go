@@ -0,0 +1,370 @@
+// internal/auth/jwt.go
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256, PS256 and ES256
+	_ "crypto/sha512" // SHA-384 and SHA-512 for the other RSA and ECDSA algorithms
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// clockSkew is tolerated between the token issuer and this server when checking exp and nbf
+const clockSkew = time.Minute
+
+// ecdsaCurveBits is the curve size of each ECDSA algorithm
+var ecdsaCurveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+// publicKey is a verification key of the JWKS file
+type publicKey struct {
+	// alg restricts the key to one algorithm if set
+	alg string
+	key crypto.PublicKey
+}
+
+// jwtVerifier checks the signature and claims of bearer JWTs against the keys of a local JWKS file
+type jwtVerifier struct {
+	conf types.JWTConfig
+	now  func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]publicKey // by kid, "" for a key without kid
+	modTime time.Time
+}
+
+func newJWTVerifier(conf types.JWTConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{conf: conf, now: time.Now}
+	if err := v.load(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// load reads the JWKS file, callers other than newJWTVerifier hold mu
+func (v *jwtVerifier) load() error {
+	info, err := os.Stat(v.conf.JWKSFile)
+	if err != nil {
+		return fmt.Errorf("error reading JWKS file '%s': %w", v.conf.JWKSFile, err)
+	}
+	data, err := os.ReadFile(v.conf.JWKSFile)
+	if err != nil {
+		return fmt.Errorf("error reading JWKS file '%s': %w", v.conf.JWKSFile, err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("error parsing JWKS file '%s': %w", v.conf.JWKSFile, err)
+	}
+	v.keys, v.modTime = keys, info.ModTime()
+	slog.Info("JWKS loaded", "path", v.conf.JWKSFile, "keys", len(keys))
+	return nil
+}
+
+// key returns the key a token header names, re-reading the JWKS file when the key is unknown and the file
+// changed, so rotated keys are picked up without a restart
+func (v *jwtVerifier) key(kid string) (publicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	if info, err := os.Stat(v.conf.JWKSFile); err == nil && !info.ModTime().Equal(v.modTime) {
+		if err := v.load(); err != nil {
+			slog.Warn("JWKS reload failed, keeping current keys", "error", err)
+		} else if key, ok := v.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return publicKey{}, fmt.Errorf("unknown signing key '%s'", kid)
+}
+
+// lookup finds a key by kid, a token without kid matching the only key of the set
+func (v *jwtVerifier) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// verify checks a compact JWT and returns the principal of its claims
+func (v *jwtVerifier) verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("malformed token header: %w", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return Principal{}, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return Principal{}, fmt.Errorf("key '%s' is for %s, token is signed with %s", header.Kid, key.alg, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("malformed token signature: %w", err)
+	}
+	if err := verifySignature(header.Alg, key.key, parts[0]+"."+parts[1], signature); err != nil {
+		return Principal{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return Principal{}, err
+	}
+
+	p := Principal{Role: types.AuthRoleTenant}
+	p.Name, _ = claims["sub"].(string)
+	if role, ok := claims[v.conf.RoleClaim].(string); ok && role != "" {
+		p.Role = types.AuthRole(role)
+	}
+	p.Tenant, _ = claims[v.conf.TenantClaim].(string)
+	switch p.Role {
+	case types.AuthRoleTenant:
+		if p.Tenant == "" {
+			return Principal{}, fmt.Errorf("token has no '%s' claim", v.conf.TenantClaim)
+		}
+	case types.AuthRoleAdmin, types.AuthRoleService:
+		p.Tenant = ""
+	default:
+		return Principal{}, fmt.Errorf("invalid role '%s'", p.Role)
+	}
+	return p, nil
+}
+
+// checkClaims checks the registered claims: exp is required, nbf, iss and aud are checked when present
+// or configured
+func (v *jwtVerifier) checkClaims(claims map[string]any) error {
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-clockSkew)) {
+		return fmt.Errorf("token not valid yet")
+	}
+	if v.conf.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.conf.Issuer {
+			return fmt.Errorf("token issuer '%s' is not '%s'", iss, v.conf.Issuer)
+		}
+	}
+	if v.conf.Audience != "" && !hasAudience(claims["aud"], v.conf.Audience) {
+		return fmt.Errorf("token audience is not '%s'", v.conf.Audience)
+	}
+	return nil
+}
+
+// hasAudience reports whether an aud claim, a string or an array of strings, contains audience
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature checks the signature of a JWS signing input with the key and algorithm given,
+// only asymmetric algorithms are accepted
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		if !ed25519.Verify(edKey, []byte(signed), signature) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported token algorithm '%s'", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if key.Curve.Params().BitSize != ecdsaCurveBits[alg] {
+			return fmt.Errorf("key does not match algorithm %s", alg)
+		}
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("key does not match algorithm %s", alg)
+	}
+	return nil
+}
+
+// parseJWKS reads the signature keys of a JWK set: RSA, EC (P-256, P-384, P-521) and OKP (Ed25519)
+func parseJWKS(data []byte) (map[string]publicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]publicKey)
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k.N, k.E)
+		case "EC":
+			key, err = ecKey(k.Crv, k.X, k.Y)
+		case "OKP":
+			key, err = okpKey(k.Crv, k.X)
+		default:
+			err = fmt.Errorf("unsupported key type '%s'", k.Kty)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d ('%s'): %w", i, k.Kid, err)
+		}
+		if _, ok := keys[k.Kid]; ok {
+			return nil, fmt.Errorf("duplicate kid '%s'", k.Kid)
+		}
+		keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signature keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("invalid e: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(eBytes)
+	if len(nBytes) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("RSA keys need at least 2048 bits and a valid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: int(exponent.Int64())}, nil
+}
+
+func ecKey(crv, x, y string) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var ecdhCurve ecdh.Curve
+	switch crv {
+	case "P-256":
+		curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve '%s'", crv)
+	}
+	xBytes, errX := base64.RawURLEncoding.DecodeString(x)
+	yBytes, errY := base64.RawURLEncoding.DecodeString(y)
+	size := (curve.Params().BitSize + 7) / 8
+	if errX != nil || errY != nil || len(xBytes) != size || len(yBytes) != size {
+		return nil, fmt.Errorf("invalid %s coordinates", crv)
+	}
+	// Reject points off the curve
+	point := append(append([]byte{4}, xBytes...), yBytes...)
+	if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid %s point: %w", crv, err)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(xBytes), Y: new(big.Int).SetBytes(yBytes)}, nil
+}
+
+func okpKey(crv, x string) (ed25519.PublicKey, error) {
+	if crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve '%s'", crv)
+	}
+	xBytes, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(xBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key")
+	}
+	return ed25519.PublicKey(xBytes), nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
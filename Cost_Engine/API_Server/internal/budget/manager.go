@@ -0,0 +1,368 @@
+// internal/budget/manager.go
+
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"simple-cost-calculator/internal/config"
+	"simple-cost-calculator/internal/metrics"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrNotFound is returned for operations on a budget that does not exist
+var ErrNotFound = errors.New("budget not found")
+
+// ErrExists is returned when creating a budget whose name is taken
+var ErrExists = errors.New("budget already exists")
+
+// ErrInvalid is returned for budgets that fail validation
+var ErrInvalid = errors.New("invalid budget")
+
+// TenantCostFunc calculates the charged cost of every tenant over a window
+type TenantCostFunc func(ctx context.Context, start, end time.Time) (map[string]*types.TenantCost, error)
+
+// ForecastFunc projects the spend of every tenant to the end of the current month
+type ForecastFunc func(ctx context.Context, now time.Time) (map[string]*types.TenantForecast, error)
+
+// firedState tracks the thresholds alerted for a budget within its current period
+type firedState struct {
+	periodStart time.Time
+	thresholds  map[float64]time.Time // threshold -> when it was first crossed
+	forecast    time.Time             // when the forecast first exceeded the amount, zero if it does not
+}
+
+// Manager holds the budgets, evaluates them periodically and alerts when thresholds are crossed.
+// Budgets changed through the API are written back to the budget file.
+type Manager struct {
+	mu     sync.RWMutex
+	path   string
+	conf   *types.BudgetConfig
+	status map[string]types.BudgetStatus
+	fired  map[string]*firedState
+
+	costs    TenantCostFunc
+	forecast ForecastFunc
+	step     time.Duration
+	interval time.Duration
+	notifier *notifier
+	trigger  chan struct{}
+
+	spentRatio *prometheus.GaugeVec
+}
+
+// NewManager creates a manager for the budgets loaded from path, evaluated every interval.
+// Monthly budgets report their month-end forecast when forecast is not nil.
+func NewManager(path string, conf *types.BudgetConfig, costs TenantCostFunc, forecast ForecastFunc, step, interval time.Duration, reg prometheus.Registerer) *Manager {
+	m := &Manager{
+		path:     path,
+		conf:     conf,
+		status:   make(map[string]types.BudgetStatus),
+		fired:    make(map[string]*firedState),
+		costs:    costs,
+		forecast: forecast,
+		step:     step,
+		interval: interval,
+		notifier: newNotifier(interval),
+		trigger:  make(chan struct{}, 1),
+		spentRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "budget_spent_ratio",
+			Help:      "Share of each budget spent in its current period (1 = fully spent).",
+		}, []string{"budget", "tenant"}),
+	}
+	reg.MustRegister(m.spentRatio)
+	return m
+}
+
+// Run evaluates the budgets every interval, and right after a budget changes, until ctx is cancelled
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.Evaluate(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-m.trigger:
+		case <-ctx.Done():
+			slog.Info("Budget evaluator stopped")
+			return
+		}
+	}
+}
+
+// List returns every budget with its last evaluation, ordered by name
+func (m *Manager) List() []types.BudgetStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := make([]types.BudgetStatus, 0, len(m.conf.Budgets))
+	for _, b := range m.conf.Budgets {
+		list = append(list, m.statusOf(b))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns a budget with its last evaluation
+func (m *Manager) Get(name string) (types.BudgetStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if i := m.index(name); i >= 0 {
+		return m.statusOf(m.conf.Budgets[i]), nil
+	}
+	return types.BudgetStatus{}, ErrNotFound
+}
+
+// Create adds a budget, failing with ErrExists if the name is taken
+func (m *Manager) Create(budget types.Budget) error {
+	return m.update(budget.Name, func(budgets []types.Budget, i int) ([]types.Budget, error) {
+		if i >= 0 {
+			return nil, ErrExists
+		}
+		if err := config.ValidateBudget(&budget); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return append(budgets, budget), nil
+	})
+}
+
+// Put creates or replaces a budget, created reports whether it is new
+func (m *Manager) Put(budget types.Budget) (created bool, err error) {
+	err = m.update(budget.Name, func(budgets []types.Budget, i int) ([]types.Budget, error) {
+		if err := config.ValidateBudget(&budget); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		if i < 0 {
+			created = true
+			return append(budgets, budget), nil
+		}
+		budgets[i] = budget
+		return budgets, nil
+	})
+	return created, err
+}
+
+// Delete removes a budget
+func (m *Manager) Delete(name string) error {
+	return m.update(name, func(budgets []types.Budget, i int) ([]types.Budget, error) {
+		if i < 0 {
+			return nil, ErrNotFound
+		}
+		return slices.Delete(budgets, i, i+1), nil
+	})
+}
+
+// update applies change to a copy of the budgets, saves the file and only then swaps the copy in
+func (m *Manager) update(name string, change func(budgets []types.Budget, i int) ([]types.Budget, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	budgets, err := change(slices.Clone(m.conf.Budgets), m.index(name))
+	if err != nil {
+		return err
+	}
+	next := *m.conf
+	next.Budgets = budgets
+	if err := config.SaveBudgetConfig(m.path, &next); err != nil {
+		return err
+	}
+	m.conf = &next
+	delete(m.status, name)
+	m.spentRatio.DeletePartialMatch(prometheus.Labels{"budget": name})
+
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// index returns the position of the named budget or -1, m.mu must be held
+func (m *Manager) index(name string) int {
+	return slices.IndexFunc(m.conf.Budgets, func(b types.Budget) bool { return b.Name == name })
+}
+
+// statusOf returns the last evaluation of a budget, or the bare budget if not evaluated yet, m.mu must be held
+func (m *Manager) statusOf(b types.Budget) types.BudgetStatus {
+	status, ok := m.status[b.Name]
+	if !ok {
+		status = types.BudgetStatus{Crossed: []float64{}}
+	}
+	status.Budget = b
+	if len(status.Thresholds) == 0 {
+		status.Thresholds = m.conf.DefaultThresholds
+	}
+	return status
+}
+
+// Evaluate calculates the spend of every budget and alerts on thresholds crossed or cleared since the last run
+func (m *Manager) Evaluate(ctx context.Context, now time.Time) {
+	m.mu.RLock()
+	budgets := slices.Clone(m.conf.Budgets)
+	defaults := m.conf.DefaultThresholds
+	webhooks := m.conf.Webhooks
+	m.mu.RUnlock()
+
+	end := now.Truncate(m.step)
+	// Budgets sharing a window (all monthly ones) share one calculation
+	costsByWindow := make(map[types.Window]map[string]*types.TenantCost)
+	errByWindow := make(map[types.Window]error)
+
+	forecasts := m.forecasts(ctx, budgets, now)
+
+	var transitions, active []alert
+	statuses := make(map[string]types.BudgetStatus, len(budgets))
+	for _, b := range budgets {
+		window := budgetWindow(b, end, m.step)
+		status := types.BudgetStatus{Budget: b, EvaluatedWindow: window, EvaluatedAt: now, Crossed: []float64{}}
+		thresholds := b.Thresholds
+		if len(thresholds) == 0 {
+			thresholds = defaults
+		}
+		status.Thresholds = thresholds
+
+		if window.End.Sub(window.Start) >= m.step {
+			tenants, computed := costsByWindow[window]
+			err := errByWindow[window]
+			if !computed && err == nil {
+				tenants, err = m.costs(ctx, window.Start, window.End)
+				costsByWindow[window], errByWindow[window] = tenants, err
+			}
+			if err != nil {
+				slog.Error("Error evaluating budget", "budget", b.Name, "error", err)
+				status.Error = err.Error()
+				statuses[b.Name] = status
+				continue
+			}
+			if tc, ok := tenants[b.Tenant]; ok {
+				status.Spent = tc.Total
+				status.Currency = tc.Currency
+			}
+		}
+		status.Percent = status.Spent / b.Amount * 100
+		for _, threshold := range thresholds {
+			if status.Percent >= threshold {
+				status.Crossed = append(status.Crossed, threshold)
+			}
+		}
+		if tf, ok := forecasts[b.Tenant]; ok && b.Period == types.BudgetMonthly {
+			// Spend so far is charged cost, the remaining steps are projected at list price
+			expected := status.Spent + tf.Remaining
+			percent := expected / b.Amount * 100
+			status.Forecast, status.ForecastPercent = &expected, &percent
+		}
+		statuses[b.Name] = status
+		m.spentRatio.WithLabelValues(b.Name, b.Tenant).Set(status.Percent / 100)
+
+		t, a := m.track(status, now)
+		transitions = append(transitions, t...)
+		active = append(active, a...)
+	}
+
+	m.mu.Lock()
+	for name, status := range statuses {
+		if m.index(name) >= 0 {
+			m.status[name] = status
+		}
+	}
+	// Forget alert state of deleted budgets
+	for name := range m.fired {
+		if _, ok := statuses[name]; !ok {
+			delete(m.fired, name)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(transitions) > 0 || len(active) > 0 {
+		m.notifier.send(ctx, webhooks, transitions, active, now)
+	}
+	slog.Debug("Budgets evaluated", "budgets", len(budgets), "transitions", len(transitions), "active_alerts", len(active))
+}
+
+// forecasts returns the month-end forecast of every tenant if a monthly budget needs it
+func (m *Manager) forecasts(ctx context.Context, budgets []types.Budget, now time.Time) map[string]*types.TenantForecast {
+	if m.forecast == nil || !slices.ContainsFunc(budgets, func(b types.Budget) bool { return b.Period == types.BudgetMonthly }) {
+		return nil
+	}
+	forecasts, err := m.forecast(ctx, now)
+	if err != nil {
+		// Budgets are still evaluated on their spend
+		slog.Error("Error forecasting budgets", "error", err)
+		return nil
+	}
+	return forecasts
+}
+
+// track updates the alert state of a budget and returns the threshold transitions and the alerts still firing
+func (m *Manager) track(status types.BudgetStatus, now time.Time) (transitions, active []alert) {
+	state, ok := m.fired[status.Name]
+	if !ok || !state.periodStart.Equal(status.EvaluatedWindow.Start) && status.Period == types.BudgetMonthly {
+		// A new month starts with no alerts, resolve those of the previous one
+		if ok {
+			for threshold, since := range state.thresholds {
+				transitions = append(transitions, newAlert(status, threshold, since, alertResolved))
+			}
+			if !state.forecast.IsZero() {
+				transitions = append(transitions, newForecastAlert(status, state.forecast, alertResolved))
+			}
+		}
+		state = &firedState{periodStart: status.EvaluatedWindow.Start, thresholds: make(map[float64]time.Time)}
+		m.fired[status.Name] = state
+	}
+	state.periodStart = status.EvaluatedWindow.Start
+
+	for _, threshold := range status.Crossed {
+		since, fired := state.thresholds[threshold]
+		if !fired {
+			since = now
+			state.thresholds[threshold] = now
+			transitions = append(transitions, newAlert(status, threshold, since, alertFiring))
+		}
+		active = append(active, newAlert(status, threshold, since, alertFiring))
+	}
+	// Spend dropped below a threshold (rolling window moved on, or the amount was raised)
+	for threshold, since := range state.thresholds {
+		if !slices.Contains(status.Crossed, threshold) {
+			delete(state.thresholds, threshold)
+			transitions = append(transitions, newAlert(status, threshold, since, alertResolved))
+		}
+	}
+
+	// Without a forecast (e.g. Prometheus failed) a firing forecast alert is kept until it can be re-evaluated
+	if status.ForecastAlert && status.ForecastPercent != nil {
+		switch exceeded := *status.ForecastPercent >= 100; {
+		case exceeded && state.forecast.IsZero():
+			state.forecast = now
+			transitions = append(transitions, newForecastAlert(status, now, alertFiring))
+		case !exceeded && !state.forecast.IsZero():
+			transitions = append(transitions, newForecastAlert(status, state.forecast, alertResolved))
+			state.forecast = time.Time{}
+		}
+	} else if !status.ForecastAlert && !state.forecast.IsZero() {
+		transitions = append(transitions, newForecastAlert(status, state.forecast, alertResolved))
+		state.forecast = time.Time{}
+	}
+	if !state.forecast.IsZero() {
+		active = append(active, newForecastAlert(status, state.forecast, alertFiring))
+	}
+	return transitions, active
+}
+
+// budgetWindow returns the window a budget is evaluated over, ending at end
+func budgetWindow(b types.Budget, end time.Time, step time.Duration) types.Window {
+	if b.Period == types.BudgetRolling {
+		window, _ := time.ParseDuration(b.Window)
+		return types.Window{Start: end.Add(-window).Truncate(step), End: end}
+	}
+	return types.Window{Start: types.TierPeriodMonth.Start(end).Truncate(step), End: end}
+}
@@ -0,0 +1,190 @@
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestManagerThresholdAlerts(t *testing.T) {
+	var mu sync.Mutex
+	var received []alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, a)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	spent := 0.0
+	costs := func(ctx context.Context, start, end time.Time) (map[string]*types.TenantCost, error) {
+		return map[string]*types.TenantCost{"user1": {Total: spent, Currency: "USD"}}, nil
+	}
+	conf := &types.BudgetConfig{
+		DefaultThresholds: []float64{50, 100},
+		Webhooks:          []types.WebhookConfig{{URL: server.URL, Format: types.WebhookGeneric}},
+		Budgets:           []types.Budget{{Name: "b1", Tenant: "user1", Amount: 10, Period: types.BudgetRolling, Window: "24h"}},
+	}
+	m := NewManager(filepath.Join(t.TempDir(), "budgets.yaml"), conf, costs, nil, time.Minute, time.Minute, prometheus.NewRegistry())
+
+	steps := []struct {
+		spent      float64
+		wantEvents []string // status:threshold of the webhooks sent by this evaluation
+		wantCross  int
+	}{
+		{spent: 4, wantCross: 0},
+		{spent: 6, wantEvents: []string{"firing:50"}, wantCross: 1},
+		{spent: 7, wantCross: 1},
+		{spent: 12, wantEvents: []string{"firing:100"}, wantCross: 2},
+		{spent: 3, wantEvents: []string{"resolved:50", "resolved:100"}, wantCross: 0},
+	}
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i, step := range steps {
+		spent = step.spent
+		mu.Lock()
+		received = nil
+		mu.Unlock()
+
+		m.Evaluate(context.Background(), now.Add(time.Duration(i)*time.Minute))
+
+		status, err := m.Get("b1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(status.Crossed) != step.wantCross || status.Spent != step.spent {
+			t.Errorf("step %d: spent %v crossed %v, want spent %v and %d crossed", i, status.Spent, status.Crossed, step.spent, step.wantCross)
+		}
+		// The status must be PUT back as is, keeping the rolling window
+		var roundTrip types.Budget
+		if data, _ := json.Marshal(status); json.Unmarshal(data, &roundTrip) != nil || roundTrip.Window != "24h" {
+			t.Errorf("step %d: status JSON %s lost the budget window", i, data)
+		}
+		mu.Lock()
+		got := map[string]bool{}
+		for _, a := range received {
+			got[string(a.Status)+":"+formatThreshold(a.Threshold)] = true
+		}
+		mu.Unlock()
+		if len(got) != len(step.wantEvents) {
+			t.Errorf("step %d: webhooks %v, want %v", i, got, step.wantEvents)
+		}
+		for _, want := range step.wantEvents {
+			if !got[want] {
+				t.Errorf("step %d: missing webhook %s, got %v", i, want, got)
+			}
+		}
+	}
+}
+
+func TestManagerForecastAlert(t *testing.T) {
+	var mu sync.Mutex
+	var received []alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, a)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	costs := func(ctx context.Context, start, end time.Time) (map[string]*types.TenantCost, error) {
+		return map[string]*types.TenantCost{"user1": {Total: 2, Currency: "USD"}}, nil
+	}
+	remaining := 0.0
+	forecast := func(ctx context.Context, now time.Time) (map[string]*types.TenantForecast, error) {
+		return map[string]*types.TenantForecast{"user1": {Remaining: remaining}}, nil
+	}
+	conf := &types.BudgetConfig{
+		DefaultThresholds: []float64{100},
+		Webhooks:          []types.WebhookConfig{{URL: server.URL, Format: types.WebhookGeneric}},
+		Budgets:           []types.Budget{{Name: "b1", Tenant: "user1", Amount: 10, Period: types.BudgetMonthly, ForecastAlert: true}},
+	}
+	m := NewManager(filepath.Join(t.TempDir(), "budgets.yaml"), conf, costs, forecast, time.Minute, time.Minute, prometheus.NewRegistry())
+
+	steps := []struct {
+		remaining  float64
+		wantStatus alertStatus // of the forecast webhook sent, empty for none
+	}{
+		{remaining: 5},
+		{remaining: 9, wantStatus: alertFiring},
+		{remaining: 10},
+		{remaining: 1, wantStatus: alertResolved},
+	}
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i, step := range steps {
+		remaining = step.remaining
+		mu.Lock()
+		received = nil
+		mu.Unlock()
+
+		m.Evaluate(context.Background(), now.Add(time.Duration(i)*time.Minute))
+
+		status, err := m.Get("b1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Forecast == nil || *status.Forecast != 2+step.remaining {
+			t.Errorf("step %d: forecast %v, want %v", i, status.Forecast, 2+step.remaining)
+		}
+		mu.Lock()
+		var got alertStatus
+		for _, a := range received {
+			if a.Kind == alertForecast {
+				got = a.Status
+			}
+		}
+		mu.Unlock()
+		if got != step.wantStatus {
+			t.Errorf("step %d: forecast webhook %q, want %q", i, got, step.wantStatus)
+		}
+	}
+}
+
+func TestManagerCRUD(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budgets.yaml")
+	costs := func(ctx context.Context, start, end time.Time) (map[string]*types.TenantCost, error) { return nil, nil }
+	m := NewManager(path, &types.BudgetConfig{DefaultThresholds: []float64{100}}, costs, nil, time.Minute, time.Minute, prometheus.NewRegistry())
+
+	b := types.Budget{Name: "b1", Tenant: "user1", Amount: 5}
+	if err := m.Create(b); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := m.Create(b); err != ErrExists {
+		t.Errorf("second Create = %v, want ErrExists", err)
+	}
+	if err := m.Create(types.Budget{Name: "bad", Tenant: "user1"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Create without amount = %v, want ErrInvalid", err)
+	}
+	status, err := m.Get("b1")
+	if err != nil || status.Period != types.BudgetMonthly || status.Thresholds[0] != 100 {
+		t.Errorf("Get = %+v, %v; want monthly budget with default thresholds", status, err)
+	}
+	if err := m.Delete("b1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := m.Get("b1"); err != ErrNotFound {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func formatThreshold(v float64) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,161 @@
+// internal/budget/notifier.go
+
+package budget
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+	"simple-cost-calculator/internal/webhook"
+)
+
+type alertStatus string
+
+const (
+	alertFiring   alertStatus = "firing"
+	alertResolved alertStatus = "resolved"
+)
+
+type alertKind string
+
+const (
+	// alertThreshold is a share of the budget spent
+	alertThreshold alertKind = "threshold"
+	// alertForecast is the month-end forecast exceeding the budget
+	alertForecast alertKind = "forecast"
+)
+
+// alert is a budget threshold crossed or forecast exceeded (firing), or no longer (resolved)
+type alert struct {
+	Kind      alertKind    `json:"kind"`
+	Status    alertStatus  `json:"status"`
+	Budget    string       `json:"budget"`
+	Tenant    string       `json:"tenant"`
+	Period    string       `json:"period"`
+	Threshold float64      `json:"threshold"`
+	Amount    float64      `json:"amount"`
+	Spent     float64      `json:"spent"`
+	Percent   float64      `json:"percent"`
+	Forecast  *float64     `json:"forecast,omitempty"`
+	Currency  string       `json:"currency"`
+	Window    types.Window `json:"window"`
+	Since     time.Time    `json:"since"`
+}
+
+func newAlert(status types.BudgetStatus, threshold float64, since time.Time, s alertStatus) alert {
+	return alert{
+		Kind:      alertThreshold,
+		Status:    s,
+		Budget:    status.Name,
+		Tenant:    status.Tenant,
+		Period:    string(status.Period),
+		Threshold: threshold,
+		Amount:    status.Amount,
+		Spent:     status.Spent,
+		Percent:   status.Percent,
+		Forecast:  status.Forecast,
+		Currency:  status.Currency,
+		Window:    status.EvaluatedWindow,
+		Since:     since,
+	}
+}
+
+func newForecastAlert(status types.BudgetStatus, since time.Time, s alertStatus) alert {
+	a := newAlert(status, 100, since, s)
+	a.Kind = alertForecast
+	return a
+}
+
+// notifier delivers budget alerts to the configured webhooks
+type notifier struct {
+	client *webhook.Client
+	// Firing alerts are re-sent to Alertmanager every evaluation and expire after ttl if not renewed
+	ttl time.Duration
+}
+
+func newNotifier(interval time.Duration) *notifier {
+	return &notifier{client: webhook.NewClient(), ttl: 3 * interval}
+}
+
+// send posts threshold transitions to generic webhooks, and the firing alerts plus the
+// transitions to resolved to Alertmanager webhooks
+func (n *notifier) send(ctx context.Context, webhooks []types.WebhookConfig, transitions, active []alert, now time.Time) {
+	for _, hook := range webhooks {
+		switch hook.Format {
+		case types.WebhookAlertmanager:
+			var payload []webhook.AlertmanagerAlert
+			for _, a := range active {
+				payload = append(payload, toAlertmanager(a, now.Add(n.ttl)))
+			}
+			for _, a := range transitions {
+				if a.Status == alertResolved {
+					payload = append(payload, toAlertmanager(a, now))
+				}
+			}
+			if len(payload) > 0 {
+				n.post(ctx, hook.URL, payload)
+			}
+		default:
+			for _, a := range transitions {
+				n.post(ctx, hook.URL, a)
+			}
+		}
+	}
+}
+
+func (n *notifier) post(ctx context.Context, url string, payload any) {
+	if err := n.client.Post(ctx, url, payload); err != nil {
+		slog.Error("Error sending budget alert", "url", url, "error", err)
+		return
+	}
+	slog.Debug("Budget alert sent", "url", url)
+}
+
+func toAlertmanager(a alert, endsAt time.Time) webhook.AlertmanagerAlert {
+	if a.Kind == alertForecast {
+		var expected float64
+		if a.Forecast != nil {
+			expected = *a.Forecast
+		}
+		return webhook.AlertmanagerAlert{
+			Labels: map[string]string{
+				"alertname": "TenantBudgetForecast",
+				"budget":    a.Budget,
+				"tenant":    a.Tenant,
+				"severity":  "warning",
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("Tenant %s is forecast to exceed budget %s this month", a.Tenant, a.Budget),
+				"description": fmt.Sprintf("Forecast %.2f %s of %.2f at month end, %.2f spent since %s.",
+					expected, a.Currency, a.Amount, a.Spent, a.Window.Start.Format(time.RFC3339)),
+			},
+			StartsAt: a.Since,
+			EndsAt:   endsAt,
+		}
+	}
+
+	severity := "warning"
+	if a.Threshold >= 100 {
+		severity = "critical"
+	}
+	return webhook.AlertmanagerAlert{
+		Labels: map[string]string{
+			"alertname": "TenantBudgetThreshold",
+			"budget":    a.Budget,
+			"tenant":    a.Tenant,
+			"threshold": strconv.FormatFloat(a.Threshold, 'f', -1, 64),
+			"severity":  severity,
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Tenant %s reached %.0f%% of budget %s", a.Tenant, a.Threshold, a.Budget),
+			"description": fmt.Sprintf("Spent %.2f %s of %.2f (%.1f%%) between %s and %s.",
+				a.Spent, a.Currency, a.Amount, a.Percent, a.Window.Start.Format(time.RFC3339), a.Window.End.Format(time.RFC3339)),
+		},
+		StartsAt: a.Since,
+		EndsAt:   endsAt,
+	}
+}
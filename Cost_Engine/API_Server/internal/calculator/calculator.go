@@ -4,123 +4,564 @@ package calculator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/metrics"
 	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
 	"simple-cost-calculator/internal/types"
 
-	prometheusAPI "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 )
 
 type CostCalculator struct {
-	promAPI     prometheusAPI.API
-	pricingConf *types.PricingConfig
+	clusters []*cluster
+	// accounting is the default usage accounting
+	accounting types.UsageAccounting
+	// pricingConf is swapped on reload, each calculation reads it once and prices everything with that snapshot
+	pricingConf atomic.Pointer[types.PricingConfig]
+	grouper     *grouping.Grouper
 }
 
-func NewCostCalculator(api prometheusAPI.API, pricing *types.PricingConfig) *CostCalculator {
-	return &CostCalculator{
-		promAPI:     api,
-		pricingConf: pricing,
+// Cluster is a named metrics source the calculator queries
+type Cluster struct {
+	// Name tags the costs of the cluster, may be empty with a single cluster
+	Name   string
+	Source source.MetricsSource
+	// Pricing of the cluster nodes, the calculator pricing if nil
+	Pricing *types.PricingConfig
+}
+
+// cluster holds a Cluster with its reloadable pricing
+type cluster struct {
+	name    string
+	source  source.MetricsSource
+	pricing atomic.Pointer[types.PricingConfig]
+}
+
+// CalcOptions holds per-request overrides of the pricing configuration
+type CalcOptions struct {
+	// BillingMode overrides the configured billing mode when set
+	BillingMode types.BillingMode
+	// ByContainer calculates one row per container instead of per pod
+	ByContainer bool
+	// Accounting overrides the default usage accounting when set
+	Accounting types.UsageAccounting
+	// Pricing is the snapshot to price with, the current pricing if nil. Requests calculating several ranges
+	// pass the snapshot returned for the first one, so a reload never mixes two configurations.
+	Pricing *types.PricingConfig
+}
+
+// Pricing returns the pricing configuration used by the calculator
+func (cc *CostCalculator) Pricing() *types.PricingConfig {
+	return cc.pricingConf.Load()
+}
+
+// SetPricing atomically replaces the pricing configuration, calculations in flight keep the previous one
+func (cc *CostCalculator) SetPricing(pricing *types.PricingConfig) {
+	cc.pricingConf.Store(pricing)
+}
+
+// SetClusterPricing atomically replaces the node pricing of a cluster
+func (cc *CostCalculator) SetClusterPricing(name string, pricing *types.PricingConfig) {
+	for _, c := range cc.clusters {
+		if c.name == name {
+			c.pricing.Store(pricing)
+		}
 	}
 }
 
-// Main function to calculate costs for all pods in the given time range
-func (cc *CostCalculator) CalculatePodCosts(ctx context.Context, start, end time.Time, step time.Duration) ([]types.PodCost, error) {
-	if cc.pricingConf == nil {
-		return nil, fmt.Errorf("pricing configuration is not loaded")
+// SetUsageAccounting changes the default usage accounting, it must be called before the first calculation
+func (cc *CostCalculator) SetUsageAccounting(accounting types.UsageAccounting) {
+	cc.accounting = accounting
+}
+
+// Accounting returns the default usage accounting
+func (cc *CostCalculator) Accounting() types.UsageAccounting {
+	return cc.accounting
+}
+
+// PricingVersion identifies a pricing snapshot with the cluster overrides in effect: the global version
+// followed by every cluster override
+func (cc *CostCalculator) PricingVersion(pricing *types.PricingConfig) string {
+	var versions []string
+	if pricing != nil {
+		versions = append(versions, pricing.Version)
+	}
+	for _, c := range cc.clusters {
+		if pricing := c.pricing.Load(); pricing != nil {
+			versions = append(versions, c.name+"="+pricing.Version)
+		}
 	}
+	return strings.Join(versions, ",")
+}
 
-	queryRange := prometheusAPI.Range{Start: start, End: end, Step: step}
-	window := types.Window{Start: start, End: end}
+// PricingVersionDuring is PricingVersion restricted to the schedule entries in effect from start to end, it is
+// the version reported on the rows of that range
+func (cc *CostCalculator) PricingVersionDuring(pricing *types.PricingConfig, start, end time.Time) string {
+	var versions []string
+	if pricing != nil {
+		versions = append(versions, pricingVersionDuring(pricing, start, end))
+	}
+	for _, c := range cc.clusters {
+		if pricing := c.pricing.Load(); pricing != nil {
+			versions = append(versions, c.name+"="+pricingVersionDuring(pricing, start, end))
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+// Grouper returns the tenant grouping rules used by the calculator
+func (cc *CostCalculator) Grouper() *grouping.Grouper {
+	return cc.grouper
+}
 
-	// --- 1. Query Prometheus for CPU and RAM usage ---
-	slog.Info("Querying Prometheus (CPU, RAM, KSM)...")
+func NewCostCalculator(src source.MetricsSource, pricing *types.PricingConfig, grouper *grouping.Grouper) *CostCalculator {
+	return NewClusterCalculator([]Cluster{{Source: src}}, pricing, grouper)
+}
+
+// NewClusterCalculator creates a calculator querying every cluster, tenants are grouped across clusters
+func NewClusterCalculator(clusters []Cluster, pricing *types.PricingConfig, grouper *grouping.Grouper) *CostCalculator {
+	cc := &CostCalculator{grouper: grouper, accounting: types.UsageAccountingRate}
+	for _, c := range clusters {
+		named := &cluster{name: c.Name, source: c.Source}
+		named.pricing.Store(c.Pricing)
+		cc.clusters = append(cc.clusters, named)
+	}
+	cc.pricingConf.Store(pricing)
+	return cc
+}
+
+// Main function to calculate costs for all pods in the given time range. The pricing snapshot the pods were
+// priced with is returned, to aggregate them with the same idle policy, currency and tiers.
+// A *PartialResultError comes with the costs of the sub-ranges that could be queried.
+func (cc *CostCalculator) CalculatePodCosts(ctx context.Context, start, end time.Time, step time.Duration, opts CalcOptions) ([]types.PodCost, *types.PricingConfig, error) {
+	pricing := opts.Pricing
+	if pricing == nil {
+		pricing = cc.Pricing()
+	}
+	details, err := cc.calculate(ctx, pricing, start, end, step, opts)
+	var partial *PartialResultError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, nil, err
+	}
+	results := make([]types.PodCost, 0, len(details))
+	for _, detail := range details {
+		results = append(results, detail.cost)
+	}
+	return results, pricing, err
+}
+
+// podCostDetail holds the cost of a pod over the window and per step
+type podCostDetail struct {
+	cost  types.PodCost
+	steps stepCosts
+}
+
+// stepCost holds the cost accumulated during the step ending at a sample timestamp
+type stepCost struct {
+	cpu, ram float64
+}
+
+// stepCosts maps sample timestamp -> cost of the step ending at that timestamp
+type stepCosts map[model.Time]*stepCost
+
+func (s stepCosts) at(ts model.Time) *stepCost {
+	c, ok := s[ts]
+	if !ok {
+		c = &stepCost{}
+		s[ts] = c
+	}
+	return c
+}
+
+// calculate prices every pod (and idle node capacity) per step over the given time range, querying
+// all clusters concurrently. When query shards failed the costs are returned with a *PartialResultError.
+func (cc *CostCalculator) calculate(ctx context.Context, pricing *types.PricingConfig, start, end time.Time, step time.Duration, opts CalcOptions) ([]podCostDetail, error) {
+	timer := prometheus.NewTimer(metrics.CalculationDuration)
+	defer timer.ObserveDuration()
+
+	if pricing == nil {
+		metrics.CalculationErrors.Inc()
+		return nil, fmt.Errorf("pricing configuration is not loaded")
+	}
+
+	results := make([][]podCostDetail, len(cc.clusters))
+	missing := make([][]types.Window, len(cc.clusters))
+	errs := make([]error, len(cc.clusters))
 	var wg sync.WaitGroup
-	var cpuResult, ramResult model.Value
-	var cpuErr, ramErr error
+	for i, c := range cc.clusters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			own := c.pricing.Load()
+			clusterPricing := withClusterPrices(pricing, own)
+			if own == nil {
+				own = pricing
+			}
+			details, clusterMissing, err := cc.calculateSteps(ctx, c.source, clusterPricing, start, end, step, opts)
+			if err != nil {
+				if c.name != "" {
+					err = fmt.Errorf("cluster %s: %w", c.name, err)
+				}
+				errs[i] = err
+				return
+			}
+			// Record which cluster and prices produced every row
+			version := pricingVersionDuring(own, start, end)
+			for j := range details {
+				details[j].cost.Cluster = c.name
+				details[j].cost.PricingVersion = version
+			}
+			results[i], missing[i] = details, clusterMissing
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		metrics.CalculationErrors.Inc()
+		return nil, err
+	}
+	var details []podCostDetail
+	for _, r := range results {
+		details = append(details, r...)
+	}
+	if partial := mergeMissing(missing...); len(partial) > 0 {
+		slog.Warn("Costs calculated with missing sub-ranges", "missing", len(partial))
+		return details, &PartialResultError{Missing: partial}
+	}
+	return details, nil
+}
+
+// calculateSteps prices the pods of one cluster, also returning the windows the source could not answer for
+func (cc *CostCalculator) calculateSteps(ctx context.Context, src source.MetricsSource, pricing *types.PricingConfig, start, end time.Time, step time.Duration, opts CalcOptions) ([]podCostDetail, []types.Window, error) {
+	if end.Sub(start) < step {
+		return nil, nil, fmt.Errorf("time range %s is shorter than step %s", end.Sub(start), step)
+	}
+
+	billingMode := pricing.BillingMode
+	if opts.BillingMode != "" {
+		billingMode = opts.BillingMode
+	}
+	accounting := cc.accounting
+	if opts.Accounting != "" {
+		accounting = opts.Accounting
+	}
 
-	wg.Add(2)
+	// Each sample covers the step ending at its timestamp, so evaluating from start+step to end
+	// covers exactly [start, end] and contiguous windows never count a step twice
+	query := source.Query{Start: start, End: end, Step: step, ByContainer: opts.ByContainer, Accounting: accounting}
+	window := types.Window{Start: start, End: end}
 
-	//CPU Query
+	// --- 1. Read CPU and RAM usage and requests, KSM and node-exporter metadata ---
+	slog.Info("Querying metrics (CPU, RAM, requests, KSM, node-exporter)...", "billing_mode", billingMode, "accounting", accounting)
+	var (
+		wg                     sync.WaitGroup
+		cpuUsage, ramUsage     source.Series
+		cpuRequest, ramRequest source.Series
+		nodes                  source.NodeInfo
+		usageErr, requestErr   error
+		nodesErr               error
+	)
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
-		cpuUsageQuery := fmt.Sprintf(prom.CPUUsageRateQueryTemplate, step.String())
-		cpuResult, cpuErr = prom.QueryRange(ctx, cc.promAPI, cpuUsageQuery, queryRange)
+		cpuUsage, ramUsage, usageErr = src.Usage(ctx, query)
 	}()
-
-	//RAM Query
 	go func() {
 		defer wg.Done()
-		ramUsageQuery := fmt.Sprintf(prom.RAMUsageAvgBytesQueryTemplate, step.String())
-		ramResult, ramErr = prom.QueryRange(ctx, cc.promAPI, ramUsageQuery, queryRange)
+		cpuRequest, ramRequest, requestErr = src.Requests(ctx, query)
+	}()
+	go func() {
+		defer wg.Done()
+		nodes, nodesErr = src.Nodes(ctx, query)
 	}()
-
 	wg.Wait()
 
-	if cpuErr != nil {
-		return nil, fmt.Errorf("error querying CPU usage: %w", cpuErr)
+	if usageErr != nil {
+		return nil, nil, usageErr
 	}
-	if ramErr != nil {
-		return nil, fmt.Errorf("error querying RAM usage: %w", ramErr)
+	// Requests are required to bill on them, otherwise they are only reported
+	if requestErr != nil {
+		if billingMode != types.BillingModeUsage {
+			return nil, nil, fmt.Errorf("billing mode %s: %w", billingMode, requestErr)
+		}
+		slog.Warn("Error querying resource requests, requests will not be reported", "error", requestErr)
 	}
-	slog.Info("Prometheus queries completed.")
+	// Windows missing from billed series leave the costs incomplete, other series only refine prices
+	missing := append(cpuUsage.Missing, ramUsage.Missing...)
+	if billingMode != types.BillingModeUsage {
+		missing = append(missing, cpuRequest.Missing...)
+		missing = append(missing, ramRequest.Missing...)
+	}
+	// Node information only refines pricing, missing KSM/node-exporter data falls back to default prices
+	if nodesErr != nil {
+		slog.Warn("Error querying node information, pods and nodes without it use default pricing and idle cost may be unavailable", "error", nodesErr)
+	}
+	slog.Info("Metrics queries completed.")
+
+	podCPUCoreSecondsSteps, podRAMByteSecondsSteps := cpuUsage.Steps, ramUsage.Steps
+	podCPURequestSteps, podRAMRequestSteps := cpuRequest.Steps, ramRequest.Steps
+	podNodes, nodeLabels := nodes.PodNodes, nodes.Labels
+	capacitySeries := nodeCapacitySeries{cpuCores: nodes.CPUCores, ramBytes: nodes.RAMBytes}
+	nodeCapacities := capacitySeries.latest()
+	slog.Info("Parsing completed.", "pods_with_node", len(podNodes), "nodes_with_labels", len(nodeLabels), "nodes_with_capacity", len(nodeCapacities))
+
+	// Rates are resolved once per node and price period, pods without a known node use the default rates
+	capacityTypes := nodeCapacityTypes(pricing, nodeLabels)
+	rates := newNodeRateLookup(pricing, step, nodeLabels, nodeCapacities, capacityTypes)
 
-	// --- 2. Parse Prometheus results ---
-	slog.Info("Parsing Prometheus results...")
-	podCPUCoreSecondsMap := prom.ParseCPUUsage(cpuResult, step)
-	podRAMByteSecondsMap := prom.ParseRAMUsage(ramResult, step)
-	slog.Info("Parsing completed.")
+	// Billed amounts per node and step, needed to derive idle capacity. Pods without a known node are kept
+	// under the empty node name.
+	billedCPUCoreSeconds := nodeStepUsage{}
+	billedRAMByteSeconds := nodeStepUsage{}
 
-	results := []types.PodCost{}
+	results := []podCostDetail{}
 
 	allPodKeys := map[string]bool{}
-	for key := range podCPUCoreSecondsMap {
+	for key := range podCPUCoreSecondsSteps {
 		allPodKeys[key] = true
 	}
-	for key := range podRAMByteSecondsMap {
+	for key := range podRAMByteSecondsSteps {
 		allPodKeys[key] = true
 	}
+	// Pods with requests but no usage samples are only charged when billing on requests
+	if billingMode != types.BillingModeUsage {
+		for key := range podCPURequestSteps {
+			allPodKeys[key] = true
+		}
+		for key := range podRAMRequestSteps {
+			allPodKeys[key] = true
+		}
+	}
 
-	slog.Info("Calculating costs", "unique_pods_found", len(allPodKeys))
+	namespaceMeta := cc.queryNamespaceMetadata(ctx, src, end)
 
-	cpuPricePerHour := cc.pricingConf.DefaultCPUPricePerHour
-	ramPricePerGiBHour := cc.pricingConf.DefaultRAMPricePerGBHour
-	slog.Debug("Using default pricing", "cpu_per_core_hour", cpuPricePerHour, "ram_per_gib_hour", ramPricePerGiBHour)
+	slog.Info("Calculating costs", "unique_pods_found", len(allPodKeys))
 
 	for podKey := range allPodKeys {
-		parts := strings.SplitN(podKey, "/", 2)
-		if len(parts) != 2 {
+		namespace, podName, container, ok := prom.SplitKey(podKey)
+		if !ok {
 			slog.Warn("Skipping invalid pod key", "key", podKey)
 			continue
 		}
-		namespace, podName := parts[0], parts[1]
 
-		totalCPUCoreSeconds := podCPUCoreSecondsMap[podKey]
-		totalRAMByteSeconds := podRAMByteSecondsMap[podKey]
 		costEntry := types.PodCost{
-			Namespace:    namespace,
-			Pod:          podName,
-			Window:       window,
-			CPUCoreHours: totalCPUCoreSeconds / types.HoursToSeconds,
-			RAMGiBHours:  totalRAMByteSeconds / types.GiB / types.HoursToSeconds,
+			Tenant:      cc.grouper.Tenant(namespace, namespaceMeta[namespace]),
+			Namespace:   namespace,
+			Pod:         podName,
+			Container:   container,
+			Window:      window,
+			BillingMode: billingMode,
 			//Errors:       []string{},
 		}
 
-		costEntry.CPUCost = totalCPUCoreSeconds * (cpuPricePerHour / types.HoursToSeconds)
+		// Each step is priced at the rate of the node the pod ran on at that step
+		nodeAt := newPodNodeLookup(podNodes[prom.GetPodKey(namespace, podName)])
+		nodesSeen := map[string]bool{}
+		steps := stepCosts{}
 
-		costEntry.RAMCost = totalRAMByteSeconds * (ramPricePerGiBHour / types.GiB / types.HoursToSeconds)
+		cpu := billSteps(billingMode, podCPUCoreSecondsSteps[podKey], podCPURequestSteps[podKey], func(ts model.Time, billedCoreSeconds float64) float64 {
+			node := nodeAt(ts)
+			cpuPricePerHour, _ := rates(node, ts)
+			if node != "" {
+				nodesSeen[node] = true
+			}
+			billedCPUCoreSeconds.add(node, ts, billedCoreSeconds)
+			cost := billedCoreSeconds * (cpuPricePerHour / types.HoursToSeconds)
+			steps.at(ts).cpu += cost
+			return cost
+		})
+		ram := billSteps(billingMode, podRAMByteSecondsSteps[podKey], podRAMRequestSteps[podKey], func(ts model.Time, billedByteSeconds float64) float64 {
+			node := nodeAt(ts)
+			_, ramPricePerGiBHour := rates(node, ts)
+			if node != "" {
+				nodesSeen[node] = true
+			}
+			billedRAMByteSeconds.add(node, ts, billedByteSeconds)
+			cost := billedByteSeconds * (ramPricePerGiBHour / types.GiB / types.HoursToSeconds)
+			steps.at(ts).ram += cost
+			return cost
+		})
+
+		costEntry.CPUCoreHours = cpu.usage / types.HoursToSeconds
+		costEntry.CPURequestCoreHours = cpu.request / types.HoursToSeconds
+		costEntry.CPUBilledCoreHours = cpu.billed / types.HoursToSeconds
+		costEntry.CPUCost = cpu.cost
+		costEntry.RAMGiBHours = ram.usage / types.GiB / types.HoursToSeconds
+		costEntry.RAMRequestGiBHours = ram.request / types.GiB / types.HoursToSeconds
+		costEntry.RAMBilledGiBHours = ram.billed / types.GiB / types.HoursToSeconds
+		costEntry.RAMCost = ram.cost
+		podCapacityTypes := map[string]bool{}
+		for node := range nodesSeen {
+			costEntry.Nodes = append(costEntry.Nodes, node)
+			if ct := capacityTypes[node]; ct != "" {
+				podCapacityTypes[ct] = true
+			}
+		}
+		sort.Strings(costEntry.Nodes)
+		costEntry.CapacityType = joinSorted(podCapacityTypes)
 
 		//TotalCost
 		costEntry.TotalCost = costEntry.CPUCost + costEntry.RAMCost
 
-		results = append(results, costEntry)
+		results = append(results, podCostDetail{cost: costEntry, steps: steps})
 	}
 	slog.Info("Calculation finished.", "pods_processed", len(results))
 
-	return results, nil
+	// --- 3. Idle node capacity ---
+	if pricing.IdleCostPolicy != types.IdleCostNone {
+		idleCosts := calculateIdleCosts(window, step, capacitySeries, billedCPUCoreSeconds, billedRAMByteSeconds, rates, capacityTypes)
+		slog.Info("Idle capacity calculated.", "nodes", len(idleCosts), "policy", pricing.IdleCostPolicy)
+		results = append(results, idleCosts...)
+	}
+
+	return results, mergeMissing(missing), nil
+}
+
+// PartialResultError is returned with the costs when some shards of the range queries failed:
+// the costs are complete except over the Missing windows
+type PartialResultError struct {
+	Missing []types.Window
+}
+
+func (e *PartialResultError) Error() string {
+	return fmt.Sprintf("costs are missing for %d sub-range(s), first %s - %s", len(e.Missing),
+		e.Missing[0].Start.Format(time.RFC3339), e.Missing[0].End.Format(time.RFC3339))
+}
+
+// mergeMissing sorts windows and merges overlapping or adjacent ones
+func mergeMissing(lists ...[]types.Window) []types.Window {
+	var all []types.Window
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Start.Before(all[j].Start) })
+	var merged []types.Window
+	for _, w := range all {
+		if n := len(merged); n > 0 && !w.Start.After(merged[n-1].End) {
+			if w.End.After(merged[n-1].End) {
+				merged[n-1].End = w.End
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+	return merged
+}
+
+// queryNamespaceMetadata fetches the namespace labels and annotations the grouping rules need, at the window end.
+// Failures are logged and leave the metadata empty so the remaining rules still apply.
+func (cc *CostCalculator) queryNamespaceMetadata(ctx context.Context, src source.MetricsSource, at time.Time) map[string]types.NamespaceMetadata {
+	needLabels, needAnnotations := cc.grouper.NeedsNamespaceMetadata()
+	if !needLabels && !needAnnotations {
+		return map[string]types.NamespaceMetadata{}
+	}
+	namespaceMeta, err := src.NamespaceMetadata(ctx, at, needLabels, needAnnotations)
+	if err != nil {
+		slog.Warn("Error querying namespace metadata, grouping rules on it are skipped", "error", err)
+	}
+	return namespaceMeta
+}
+
+// resourceTotals holds the totals of one resource of a pod over the window
+type resourceTotals struct {
+	usage, request, billed, cost float64
+}
+
+// billSteps walks the steps where a pod had usage or requests, determines the billed amount of
+// each step according to the billing mode and prices it with priceStep
+func billSteps(mode types.BillingMode, usageSteps, requestSteps map[model.Time]float64, priceStep func(model.Time, float64) float64) resourceTotals {
+	var totals resourceTotals
+	steps := make(map[model.Time]bool, len(usageSteps))
+	for ts := range usageSteps {
+		steps[ts] = true
+	}
+	for ts := range requestSteps {
+		steps[ts] = true
+	}
+	for ts := range steps {
+		usage, request := usageSteps[ts], requestSteps[ts]
+		billed := billedAmount(mode, usage, request)
+		totals.usage += usage
+		totals.request += request
+		totals.billed += billed
+		if billed > 0 {
+			totals.cost += priceStep(ts, billed)
+		}
+	}
+	return totals
+}
+
+// billedAmount returns the amount charged for a step according to the billing mode
+func billedAmount(mode types.BillingMode, usage, request float64) float64 {
+	switch mode {
+	case types.BillingModeRequest:
+		return request
+	case types.BillingModeMax:
+		return max(usage, request)
+	default:
+		return usage
+	}
+}
+
+// newPodNodeLookup returns a function resolving the node a pod ran on at a step timestamp.
+// Steps without kube_pod_info samples (scrape gaps) use the nearest earlier sample, steps before the first
+// sample the first one.
+func newPodNodeLookup(nodesByTime map[model.Time]string) func(model.Time) string {
+	times := slices.Sorted(maps.Keys(nodesByTime))
+	return func(ts model.Time) string {
+		if len(times) == 0 {
+			return ""
+		}
+		// Index of the first sample after ts
+		i := sort.Search(len(times), func(i int) bool { return times[i] > ts })
+		if i == 0 {
+			return nodesByTime[times[0]]
+		}
+		return nodesByTime[times[i-1]]
+	}
+}
+
+// joinSorted joins the set members in order with commas
+func joinSorted(set map[string]bool) string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+// newNodeRateLookup returns a memoized function resolving the CPU and RAM rates of a node for the step
+// ending at ts. Steps are priced with the schedule entry in effect when they start, so a window spanning a
+// price change is split at the change.
+func newNodeRateLookup(pricingConf *types.PricingConfig, step time.Duration, nodeLabels map[string]map[string]string, capacities map[string]types.NodeCapacity, capacityTypes map[string]string) func(string, model.Time) (float64, float64) {
+	type rateKey struct {
+		node  string
+		entry int
+	}
+	type nodeRates struct{ cpu, ram float64 }
+	cache := make(map[rateKey]nodeRates)
+	return func(node string, ts model.Time) (float64, float64) {
+		entry, prices := pricesAt(pricingConf, ts.Time().Add(-step))
+		key := rateKey{node: node, entry: entry}
+		if r, ok := cache[key]; ok {
+			return r.cpu, r.ram
+		}
+		cpu, ram := getRatesForNode(&prices, nodeLabels[node], capacities[node], capacityTypes[node])
+		cache[key] = nodeRates{cpu: cpu, ram: ram}
+		return cpu, ram
+	}
 }
@@ -0,0 +1,192 @@
+package calculator
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCalculatePodCostsReplay(t *testing.T) {
+	// testdata/replay holds 10 minutes recorded at 1m: web (0.5 core, 1 GiB, requests 1 core and 2 GiB) the
+	// whole window and batch (1 core, 1 GiB, requests 1 core and 1 GiB) the first 5 minutes, both on node-a
+	// (4 cores, 8 GiB). kube_node_labels is not recorded, so default prices apply.
+	replay, err := source.NewReplay("testdata/replay", prom.StandaloneCAdvisor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricing := &types.PricingConfig{
+		Prices:         types.Prices{DefaultCPUPricePerHour: 6, DefaultRAMPricePerGBHour: 3},
+		IdleCostPolicy: types.IdleCostSeparate,
+		BillingMode:    types.BillingModeUsage,
+	}
+	calc := NewCostCalculator(replay, pricing, grouper)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+
+	tests := []struct {
+		name     string
+		step     time.Duration
+		mode     types.BillingMode
+		want     map[string]float64
+		wantIdle float64
+	}{
+		// web: 1/12 core-hour * 6 + 1/6 GiB-hour * 3, batch: 1/12 core-hour * 6 + 1/12 GiB-hour * 3
+		{name: "usage", step: time.Minute, mode: types.BillingModeUsage, want: map[string]float64{"web": 1, "batch": 0.75}, wantIdle: 6.25},
+		{name: "usage at coarser step", step: 5 * time.Minute, mode: types.BillingModeUsage, want: map[string]float64{"web": 1, "batch": 0.75}, wantIdle: 6.25},
+		// web: 1/6 core-hour * 6 + 1/3 GiB-hour * 3
+		{name: "request", step: time.Minute, mode: types.BillingModeRequest, want: map[string]float64{"web": 2, "batch": 0.75}, wantIdle: 5.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podCosts, snapshot, err := calc.CalculatePodCosts(context.Background(), start, end, tt.step, CalcOptions{BillingMode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot != pricing {
+				t.Errorf("pricing snapshot = %p, want the calculator pricing %p", snapshot, pricing)
+			}
+			var idle float64
+			got := map[string]float64{}
+			for _, pc := range podCosts {
+				if pc.Idle {
+					idle += pc.TotalCost
+					continue
+				}
+				got[pc.Pod] = pc.TotalCost
+				if want := "user1"; pc.Pod == "web" && pc.Tenant != want {
+					t.Errorf("web tenant = %s, want %s", pc.Tenant, want)
+				}
+				if len(pc.Nodes) != 1 || pc.Nodes[0] != "node-a" {
+					t.Errorf("%s nodes = %v, want [node-a]", pc.Pod, pc.Nodes)
+				}
+			}
+			for pod, want := range tt.want {
+				if math.Abs(got[pod]-want) > 1e-9 {
+					t.Errorf("%s cost = %v, want %v", pod, got[pod], want)
+				}
+			}
+			if math.Abs(idle-tt.wantIdle) > 1e-9 {
+				t.Errorf("idle cost = %v, want %v", idle, tt.wantIdle)
+			}
+		})
+	}
+}
+
+// stubSource answers every query with fixed usage and nodes
+type stubSource struct {
+	cpu   prom.PodStepSeries
+	nodes source.NodeInfo
+}
+
+func (s stubSource) Usage(context.Context, source.Query) (cpu, ram source.Series, err error) {
+	return source.Series{Steps: s.cpu}, source.Series{Steps: prom.PodStepSeries{}}, nil
+}
+
+func (stubSource) Requests(context.Context, source.Query) (cpu, ram source.Series, err error) {
+	return source.Series{Steps: prom.PodStepSeries{}}, source.Series{Steps: prom.PodStepSeries{}}, nil
+}
+
+func (s stubSource) Nodes(context.Context, source.Query) (source.NodeInfo, error) {
+	return s.nodes, nil
+}
+
+func (stubSource) NamespaceMetadata(context.Context, time.Time, bool, bool) (map[string]types.NamespaceMetadata, error) {
+	return nil, nil
+}
+
+func TestCalculatePodCostsPerNode(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) model.Time {
+		return model.TimeFromUnix(start.Add(time.Duration(minutes) * time.Minute).Unix())
+	}
+	instanceType := prom.KSMLabelName("node.kubernetes.io/instance-type")
+	pricing := &types.PricingConfig{
+		Prices: types.Prices{DefaultCPUPricePerHour: 30, CPUPriceByInstanceType: map[string]float64{"small": 60, "large": 120}},
+	}
+
+	tests := []struct {
+		name      string
+		podNodes  map[model.Time]string
+		want      float64
+		wantNodes []string
+	}{
+		// One core each minute: 1 per minute on node-a, 2 on node-b
+		{name: "single node", podNodes: map[model.Time]string{at(1): "node-a", at(2): "node-a", at(3): "node-a", at(4): "node-a"}, want: 4, wantNodes: []string{"node-a"}},
+		{name: "move between nodes", podNodes: map[model.Time]string{at(1): "node-a", at(2): "node-a", at(3): "node-b", at(4): "node-b"}, want: 6, wantNodes: []string{"node-a", "node-b"}},
+		// The step at 3m has no sample and stays on node-a, the step at 1m takes the first sample
+		{name: "scrape gaps", podNodes: map[model.Time]string{at(2): "node-a", at(4): "node-b"}, want: 5, wantNodes: []string{"node-a", "node-b"}},
+		{name: "unknown node", want: 2, wantNodes: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := stubSource{
+				cpu: prom.PodStepSeries{"ns1-user1/web": {at(1): 60, at(2): 60, at(3): 60, at(4): 60}},
+				nodes: source.NodeInfo{
+					PodNodes: map[string]map[model.Time]string{"ns1-user1/web": tt.podNodes},
+					Labels:   map[string]map[string]string{"node-a": {instanceType: "small"}, "node-b": {instanceType: "large"}},
+				},
+			}
+			grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			podCosts, _, err := NewCostCalculator(src, pricing, grouper).CalculatePodCosts(context.Background(), start, start.Add(4*time.Minute), time.Minute, CalcOptions{BillingMode: types.BillingModeUsage})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(podCosts) != 1 {
+				t.Fatalf("pod costs = %+v, want web only", podCosts)
+			}
+			if got := podCosts[0]; math.Abs(got.TotalCost-tt.want) > 1e-9 || !slices.Equal(got.Nodes, tt.wantNodes) {
+				t.Errorf("web cost = %v on %v, want %v on %v", got.TotalCost, got.Nodes, tt.want, tt.wantNodes)
+			}
+		})
+	}
+}
+
+func TestBillStepsMax(t *testing.T) {
+	first, second := model.TimeFromUnix(1735689660), model.TimeFromUnix(1735689720)
+	// Usage above the request in the first step, below it in the second
+	usage := map[model.Time]float64{first: 90, second: 30}
+	requests := map[model.Time]float64{first: 60, second: 60}
+	// Steps are priced at 1 per unit, 2 in the second step
+	price := func(ts model.Time, amount float64) float64 {
+		if ts == second {
+			return 2 * amount
+		}
+		return amount
+	}
+
+	tests := []struct {
+		mode                 types.BillingMode
+		wantBilled, wantCost float64
+	}{
+		{mode: types.BillingModeUsage, wantBilled: 120, wantCost: 90 + 60},
+		{mode: types.BillingModeRequest, wantBilled: 120, wantCost: 60 + 120},
+		{mode: types.BillingModeMax, wantBilled: 150, wantCost: 90 + 120},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			totals := billSteps(tt.mode, usage, requests, price)
+			if totals.usage != 120 || totals.request != 120 {
+				t.Errorf("usage, request = %v, %v, want 120, 120", totals.usage, totals.request)
+			}
+			if totals.billed != tt.wantBilled || totals.cost != tt.wantCost {
+				t.Errorf("billed, cost = %v, %v, want %v, %v", totals.billed, totals.cost, tt.wantBilled, tt.wantCost)
+			}
+		})
+	}
+}
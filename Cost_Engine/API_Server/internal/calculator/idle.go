@@ -0,0 +1,130 @@
+// internal/calculator/idle.go
+
+package calculator
+
+import (
+	"maps"
+	"math"
+	"time"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+// nodeStepUsage maps node -> step timestamp -> amount used by pods during the step
+type nodeStepUsage map[string]map[model.Time]float64
+
+func (u nodeStepUsage) add(node string, ts model.Time, amount float64) {
+	if _, exists := u[node]; !exists {
+		u[node] = make(map[model.Time]float64)
+	}
+	u[node][ts] += amount
+}
+
+// spreadUnattributed returns the usage with the usage of pods without a known node (the empty node name)
+// spread over the nodes reporting capacity at each step, proportionally to their capacity
+func (u nodeStepUsage) spreadUnattributed(capacity map[string]map[model.Time]float64) nodeStepUsage {
+	unattributed := u[""]
+	if len(unattributed) == 0 {
+		return u
+	}
+	totals := make(map[model.Time]float64)
+	for _, series := range capacity {
+		for ts, amount := range series {
+			totals[ts] += amount
+		}
+	}
+	spread := make(nodeStepUsage, len(u))
+	for node, series := range u {
+		if node != "" {
+			spread[node] = maps.Clone(series)
+		}
+	}
+	for node, series := range capacity {
+		for ts, amount := range series {
+			if used := unattributed[ts]; used > 0 && totals[ts] > 0 {
+				spread.add(node, ts, used*amount/totals[ts])
+			}
+		}
+	}
+	return spread
+}
+
+// nodeCapacitySeries holds node-exporter capacity per node and step
+type nodeCapacitySeries struct {
+	cpuCores map[string]map[model.Time]float64
+	ramBytes map[string]map[model.Time]float64
+}
+
+// latest returns the most recent capacity of each node
+func (s nodeCapacitySeries) latest() map[string]types.NodeCapacity {
+	capacities := make(map[string]types.NodeCapacity)
+	for node, series := range s.cpuCores {
+		c := capacities[node]
+		c.CPUCores = prom.LatestValue(series)
+		capacities[node] = c
+	}
+	for node, series := range s.ramBytes {
+		c := capacities[node]
+		c.RAMBytes = prom.LatestValue(series)
+		capacities[node] = c
+	}
+	return capacities
+}
+
+// calculateIdleCosts prices, per node and step, the capacity not billed to any pod.
+// Only steps where node-exporter reported the node are counted, billing above capacity yields no idle cost.
+// Usage of pods without a known node is taken from the capacity of every node reporting at the step.
+func calculateIdleCosts(window types.Window, step time.Duration, capacity nodeCapacitySeries, usedCPU, usedRAM nodeStepUsage, rates func(string, model.Time) (float64, float64), capacityTypes map[string]string) []podCostDetail {
+	stepSeconds := step.Seconds()
+	usedCPU = usedCPU.spreadUnattributed(capacity.cpuCores)
+	usedRAM = usedRAM.spreadUnattributed(capacity.ramBytes)
+	nodes := map[string]bool{}
+	for node := range capacity.cpuCores {
+		nodes[node] = true
+	}
+	for node := range capacity.ramBytes {
+		nodes[node] = true
+	}
+
+	idleCosts := []podCostDetail{}
+	for node := range nodes {
+		steps := stepCosts{}
+		var idleCPUCoreSeconds, idleRAMByteSeconds, idleCPUCost, idleRAMCost float64
+		for ts, cores := range capacity.cpuCores[node] {
+			cpuPricePerHour, _ := rates(node, ts)
+			idle := math.Max(cores*stepSeconds-usedCPU[node][ts], 0)
+			cost := idle * (cpuPricePerHour / types.HoursToSeconds)
+			idleCPUCoreSeconds += idle
+			idleCPUCost += cost
+			steps.at(ts).cpu += cost
+		}
+		for ts, bytes := range capacity.ramBytes[node] {
+			_, ramPricePerGiBHour := rates(node, ts)
+			idle := math.Max(bytes*stepSeconds-usedRAM[node][ts], 0)
+			cost := idle * (ramPricePerGiBHour / types.GiB / types.HoursToSeconds)
+			idleRAMByteSeconds += idle
+			idleRAMCost += cost
+			steps.at(ts).ram += cost
+		}
+
+		idleEntry := types.PodCost{
+			Tenant:       types.IdleGroupKey,
+			Namespace:    types.IdleGroupKey,
+			Pod:          node,
+			Nodes:        []string{node},
+			CapacityType: capacityTypes[node],
+			Window:       window,
+			CPUCoreHours: idleCPUCoreSeconds / types.HoursToSeconds,
+			CPUCost:      idleCPUCost,
+			RAMGiBHours:  idleRAMByteSeconds / types.GiB / types.HoursToSeconds,
+			RAMCost:      idleRAMCost,
+			Idle:         true,
+		}
+		idleEntry.TotalCost = idleEntry.CPUCost + idleEntry.RAMCost
+		idleCosts = append(idleCosts, podCostDetail{cost: idleEntry, steps: steps})
+	}
+	return idleCosts
+}
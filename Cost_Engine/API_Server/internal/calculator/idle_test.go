@@ -0,0 +1,97 @@
+package calculator
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCalculateIdleCosts(t *testing.T) {
+	ts := model.TimeFromUnix(1735689660)
+	capacity := nodeCapacitySeries{cpuCores: map[string]map[model.Time]float64{
+		"node-a": {ts: 2},
+		"node-b": {ts: 6},
+	}}
+	// One CPU core-second costs 1
+	rates := func(string, model.Time) (float64, float64) { return types.HoursToSeconds, 0 }
+
+	tests := []struct {
+		name string
+		used nodeStepUsage
+		want map[string]float64
+	}{
+		{name: "pods on known nodes", used: nodeStepUsage{"node-a": {ts: 60}}, want: map[string]float64{"node-a": 60, "node-b": 360}},
+		// 120 core-seconds of a pod without a node are taken 1:3 from the capacity of node-a and node-b
+		{name: "pods without a known node", used: nodeStepUsage{"node-a": {ts: 60}, "": {ts: 120}}, want: map[string]float64{"node-a": 30, "node-b": 270}},
+		{name: "billing above capacity", used: nodeStepUsage{"node-a": {ts: 60}, "": {ts: 600}}, want: map[string]float64{"node-a": 0, "node-b": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idleCosts := calculateIdleCosts(types.Window{}, time.Minute, capacity, tt.used, nodeStepUsage{}, rates, nil)
+			if len(idleCosts) != len(tt.want) {
+				t.Fatalf("idle entries = %d, want %d", len(idleCosts), len(tt.want))
+			}
+			for _, idle := range idleCosts {
+				if want := tt.want[idle.cost.Pod]; math.Abs(idle.cost.TotalCost-want) > 1e-9 {
+					t.Errorf("%s idle cost = %v, want %v", idle.cost.Pod, idle.cost.TotalCost, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdleCostPolicies(t *testing.T) {
+	// On the replay (see TestCalculatePodCostsReplay) web of user1 costs 1, batch of user2 0.75 and the idle
+	// capacity of node-a 6.25
+	replay, err := source.NewReplay("testdata/replay", prom.StandaloneCAdvisor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		policy types.IdleCostPolicy
+		want   map[string]float64
+	}{
+		{policy: types.IdleCostNone, want: map[string]float64{"user1": 1, "user2": 0.75}},
+		{policy: types.IdleCostSeparate, want: map[string]float64{"user1": 1, "user2": 0.75, types.IdleGroupKey: 6.25}},
+		// Idle cost is shared 1:0.75 by usage cost
+		{policy: types.IdleCostDistribute, want: map[string]float64{"user1": 1 + 6.25/1.75, "user2": 0.75 + 6.25*0.75/1.75}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			pricing := &types.PricingConfig{
+				Prices:         types.Prices{DefaultCPUPricePerHour: 6, DefaultRAMPricePerGBHour: 3},
+				IdleCostPolicy: tt.policy,
+				BillingMode:    types.BillingModeUsage,
+			}
+			calc := NewCostCalculator(replay, pricing, grouper)
+			podCosts, _, err := calc.CalculatePodCosts(context.Background(), start, start.Add(10*time.Minute), time.Minute, CalcOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tenants := AggregateTenantCosts(podCosts, tt.policy, "USD")
+			if len(tenants) != len(tt.want) {
+				t.Fatalf("tenants = %v, want %v", tenants, tt.want)
+			}
+			for tenant, want := range tt.want {
+				if got := tenants[tenant]; got == nil || math.Abs(got.Total-want) > 1e-9 {
+					t.Errorf("%s = %+v, want total %v", tenant, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,60 +1,210 @@
-// // internal/calculator/pricing.go
+// internal/calculator/pricing.go
 
 package calculator
 
-// // Các key label phổ biến cho instance type
-// var instanceTypeLabelKeys = []string{
-// 	"node.kubernetes.io/instance-type", // KSM > v1.6
-// 	"beta.kubernetes.io/instance-type", // Label cũ hơn
-// 	// Thêm các label key bạn sử dụng ở đây nếu khác
-// 	"custom-node-type",
-// }
-
-// // getCPUPriceForNode xác định giá CPU dựa trên labels của node và config
-// // Trả về giá mỗi core mỗi giờ
-// func getCPUPriceForNode(pricingConf *types.PricingConfig, nodeLabels map[string]string) float64 {
-// 	if pricingConf == nil {
-// 		slog.Error("Error: Pricing config is nil in getCPUPriceForNode")
-// 		return 0.0 // Hoặc một giá trị mặc định an toàn
-// 	}
-
-// 	if nodeLabels != nil {
-// 		for _, key := range instanceTypeLabelKeys {
-// 			if instanceType, ok := nodeLabels[key]; ok {
-// 				if price, exists := pricingConf.CPUPriceByInstanceType[instanceType]; exists {
-// 					slog.Debug("Debug: Found CPU price for instance type", "Label key", key, "Instance type", instanceType, "Price per hour", price)
-// 					return price
-// 				}
-// 			}
-// 		}
-// 	}
-
-// 	// Không tìm thấy giá cụ thể, sử dụng giá mặc định
-// 	slog.Debug("Debug: Using default CPU price.", "CPU Price", pricingConf.DefaultCPUPricePerHour)
-// 	return pricingConf.DefaultCPUPricePerHour
-// }
-
-// // getRAMPriceForNode xác định giá RAM dựa trên labels của node và config
-// // Trả về giá mỗi GiB mỗi giờ ($/GiB-hour)
-// func getRAMPriceForNode(pricingConf *types.PricingConfig, nodeLabels map[string]string) float64 {
-// 	if pricingConf == nil {
-// 		slog.Error("Error: Pricing config is nil in getRAMPriceForNode")
-// 		return 0.0
-// 	}
-
-// 	if nodeLabels != nil {
-// 		for _, key := range instanceTypeLabelKeys { // Dùng chung key label với CPU
-// 			if instanceType, ok := nodeLabels[key]; ok {
-// 				// Tìm trong map giá RAM theo instance type
-// 				if price, exists := pricingConf.RAMPriceByInstanceType[instanceType]; exists {
-// 					slog.Debug("Debug: Found RAM price by instance type", "Label key", key, "Instance type", instanceType, "Price per hour", price)
-// 					return price
-// 				}
-// 			}
-// 		}
-// 	}
-
-// 	// Không tìm thấy giá cụ thể, sử dụng giá mặc định
-// 	slog.Debug("Debug: Using default RAM price", "RAM Price", pricingConf.DefaultRAMPricePerGBHour)
-// 	return pricingConf.DefaultRAMPricePerGBHour
-// }
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"simple-cost-calculator/internal/config"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+)
+
+// Common label keys for instance type, as exposed by kube_node_labels
+var instanceTypeLabelKeys = []string{
+	prom.KSMLabelName("node.kubernetes.io/instance-type"), // KSM > v1.6
+	prom.KSMLabelName("beta.kubernetes.io/instance-type"), // Older label
+	// Add the label keys you use here if different
+	prom.KSMLabelName("custom-node-type"),
+}
+
+// Common node labels carrying the capacity type (spot or on-demand)
+var defaultCapacityTypeLabels = []string{
+	"karpenter.sh/capacity-type",            // spot, on-demand
+	"eks.amazonaws.com/capacityType",        // SPOT, ON_DEMAND
+	"cloud.google.com/gke-spot",             // true
+	"cloud.google.com/gke-preemptible",      // true
+	"kubernetes.azure.com/scalesetpriority", // spot
+}
+
+// Capacity types reported for nodes, other label values are reported as normalized
+const (
+	CapacityTypeSpot     = "spot"
+	CapacityTypeOnDemand = "on-demand"
+)
+
+// nodeCapacityTypes resolves the capacity type of every labelled node from the configured (or built-in) labels,
+// nodes without any of them are left out
+func nodeCapacityTypes(pricingConf *types.PricingConfig, nodeLabels map[string]map[string]string) map[string]string {
+	labels := pricingConf.CapacityTypeLabels
+	if len(labels) == 0 {
+		labels = defaultCapacityTypeLabels
+	}
+	keys := make([]string, len(labels))
+	for i, label := range labels {
+		keys[i] = prom.KSMLabelName(label)
+	}
+
+	capacityTypes := make(map[string]string)
+	for node, nodeLabel := range nodeLabels {
+		for _, key := range keys {
+			if value := nodeLabel[key]; value != "" {
+				capacityTypes[node] = normalizeCapacityType(value)
+				break
+			}
+		}
+	}
+	return capacityTypes
+}
+
+// normalizeCapacityType maps provider specific label values onto spot and on-demand
+func normalizeCapacityType(value string) string {
+	value = strings.ReplaceAll(strings.ToLower(value), "_", "-")
+	switch value {
+	case "true", "spot", "preemptible":
+		return CapacityTypeSpot
+	case "false", "on-demand", "regular":
+		return CapacityTypeOnDemand
+	}
+	return value
+}
+
+// getInstanceType returns the instance type of a node from its labels, or "" if unknown
+func getInstanceType(nodeLabels map[string]string) string {
+	for _, key := range instanceTypeLabelKeys {
+		if instanceType, ok := nodeLabels[key]; ok && instanceType != "" {
+			return instanceType
+		}
+	}
+	return ""
+}
+
+// getCPUPriceForNode determines the CPU price based on node labels and config
+// Returns price per core per hour
+func getCPUPriceForNode(prices *types.Prices, nodeLabels map[string]string) float64 {
+	if prices == nil {
+		slog.Error("Prices are nil in getCPUPriceForNode")
+		return 0.0
+	}
+
+	if instanceType := getInstanceType(nodeLabels); instanceType != "" {
+		if price, exists := prices.CPUPriceByInstanceType[instanceType]; exists {
+			slog.Debug("Found CPU price for instance type", "instance_type", instanceType, "price_per_hour", price)
+			return price
+		}
+	}
+
+	// No specific price found, use default price
+	return prices.DefaultCPUPricePerHour
+}
+
+// getRAMPriceForNode determines the RAM price based on node labels and config
+// Returns price per GiB per hour ($/GiB-hour)
+func getRAMPriceForNode(prices *types.Prices, nodeLabels map[string]string) float64 {
+	if prices == nil {
+		slog.Error("Prices are nil in getRAMPriceForNode")
+		return 0.0
+	}
+
+	if instanceType := getInstanceType(nodeLabels); instanceType != "" {
+		if price, exists := prices.RAMPriceByInstanceType[instanceType]; exists {
+			slog.Debug("Found RAM price for instance type", "instance_type", instanceType, "price_per_hour", price)
+			return price
+		}
+	}
+
+	// No specific price found, use default price
+	return prices.DefaultRAMPricePerGBHour
+}
+
+// getRatesForNode determines the CPU ($/core-hour) and RAM ($/GiB-hour) rates of a node.
+// A whole-node price for the instance type is split using the node capacity and the configured
+// CPU:RAM cost ratio, otherwise the per-resource prices apply. Nodes of a priced capacity type
+// use its node price for the instance type, or the rates above scaled by its multiplier.
+func getRatesForNode(prices *types.Prices, nodeLabels map[string]string, capacity types.NodeCapacity, capacityType string) (float64, float64) {
+	if prices == nil {
+		slog.Error("Prices are nil in getRatesForNode")
+		return 0.0, 0.0
+	}
+
+	instanceType := getInstanceType(nodeLabels)
+	ctPricing, hasCapacityType := prices.CapacityTypes[capacityType]
+	if hasCapacityType && instanceType != "" {
+		if nodePrice, exists := ctPricing.NodePriceByInstanceType[instanceType]; exists {
+			if cpuRate, ramRate, ok := splitNodePrice(nodePrice, capacity, prices.CPUToRAMCostRatio); ok {
+				slog.Debug("Split capacity type node price", "capacity_type", capacityType, "instance_type", instanceType, "node_price_per_hour", nodePrice)
+				return cpuRate, ramRate
+			}
+		}
+	}
+
+	cpuRate, ramRate := getOnDemandRatesForNode(prices, nodeLabels, capacity, instanceType)
+	if hasCapacityType && ctPricing.PriceMultiplier > 0 {
+		cpuRate *= ctPricing.PriceMultiplier
+		ramRate *= ctPricing.PriceMultiplier
+	}
+	return cpuRate, ramRate
+}
+
+// getOnDemandRatesForNode determines the node rates ignoring its capacity type
+func getOnDemandRatesForNode(prices *types.Prices, nodeLabels map[string]string, capacity types.NodeCapacity, instanceType string) (float64, float64) {
+	if instanceType != "" {
+		if nodePrice, exists := prices.NodePriceByInstanceType[instanceType]; exists {
+			if cpuRate, ramRate, ok := splitNodePrice(nodePrice, capacity, prices.CPUToRAMCostRatio); ok {
+				slog.Debug("Split node price for instance type", "instance_type", instanceType, "node_price_per_hour", nodePrice, "cpu_per_core_hour", cpuRate, "ram_per_gib_hour", ramRate)
+				return cpuRate, ramRate
+			}
+			slog.Debug("Node capacity unknown, cannot split node price", "instance_type", instanceType)
+		}
+	}
+
+	return getCPUPriceForNode(prices, nodeLabels), getRAMPriceForNode(prices, nodeLabels)
+}
+
+// splitNodePrice derives per-core and per-GiB rates so that cores*cpuRate + GiB*ramRate == nodePrice
+// and cpuRate == ratio*ramRate
+func splitNodePrice(nodePrice float64, capacity types.NodeCapacity, ratio float64) (float64, float64, bool) {
+	ramGiB := capacity.RAMBytes / types.GiB
+	if capacity.CPUCores <= 0 || ramGiB <= 0 || ratio <= 0 {
+		return 0.0, 0.0, false
+	}
+	ramRate := nodePrice / (ratio*capacity.CPUCores + ramGiB)
+	return ratio * ramRate, ramRate, true
+}
+
+// pricesAt returns the prices in effect at t: the schedule entry covering t, or the top-level prices
+func pricesAt(pricingConf *types.PricingConfig, t time.Time) (int, types.Prices) {
+	for i, entry := range pricingConf.Schedule {
+		if entry.Contains(t) {
+			return i, entry.Resolve(pricingConf.Prices)
+		}
+	}
+	return -1, pricingConf.Prices
+}
+
+// pricingVersionDuring identifies the prices in effect from start to end, the version of the whole config if
+// they cannot be hashed
+func pricingVersionDuring(pricingConf *types.PricingConfig, start, end time.Time) string {
+	version, err := config.PricingVersionDuring(pricingConf, start, end)
+	if err != nil {
+		slog.Warn("Error hashing the prices of a range, reporting the config version", "version", pricingConf.Version, "error", err)
+		return pricingConf.Version
+	}
+	return version
+}
+
+// withClusterPrices returns the cluster pricing with the tenant level settings of the global pricing,
+// or the global pricing when the cluster has none
+func withClusterPrices(global, cluster *types.PricingConfig) *types.PricingConfig {
+	if cluster == nil {
+		return global
+	}
+	merged := *cluster
+	merged.TieredPricing = global.TieredPricing
+	merged.IdleCostPolicy = global.IdleCostPolicy
+	merged.BillingMode = global.BillingMode
+	merged.Currency = global.Currency
+	return &merged
+}
@@ -0,0 +1,128 @@
+package calculator
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestNodeRateLookupSchedule(t *testing.T) {
+	feb := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	conf := &types.PricingConfig{
+		Prices: types.Prices{DefaultCPUPricePerHour: 1, DefaultRAMPricePerGBHour: 2},
+		Schedule: []types.PriceEntry{
+			{EffectiveFrom: feb, EffectiveTo: mar, Prices: types.Prices{DefaultCPUPricePerHour: 3}},
+			{EffectiveFrom: mar, Prices: types.Prices{DefaultCPUPricePerHour: 5, DefaultRAMPricePerGBHour: 6}},
+		},
+	}
+	step := time.Minute
+	rates := newNodeRateLookup(conf, step, nil, nil, nil)
+
+	tests := []struct {
+		name             string
+		sampleTime       time.Time
+		wantCPU, wantRAM float64
+	}{
+		{name: "before schedule", sampleTime: feb.Add(-time.Hour), wantCPU: 1, wantRAM: 2},
+		// The sample at the boundary covers the last step before it
+		{name: "step ending at boundary", sampleTime: feb, wantCPU: 1, wantRAM: 2},
+		{name: "first step of entry", sampleTime: feb.Add(step), wantCPU: 3, wantRAM: 2},
+		{name: "open-ended entry", sampleTime: mar.Add(24 * time.Hour), wantCPU: 5, wantRAM: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, ram := rates("node-1", model.TimeFromUnixNano(tt.sampleTime.UnixNano()))
+			if cpu != tt.wantCPU || ram != tt.wantRAM {
+				t.Errorf("rates = (%v, %v), want (%v, %v)", cpu, ram, tt.wantCPU, tt.wantRAM)
+			}
+		})
+	}
+}
+
+func TestSplitNodePrice(t *testing.T) {
+	tests := []struct {
+		name             string
+		nodePrice        float64
+		capacity         types.NodeCapacity
+		ratio            float64
+		wantCPU, wantRAM float64
+		wantOK           bool
+	}{
+		// ramRate = 12 / (2*2 + 4)
+		{name: "ratio weights cores", nodePrice: 12, capacity: types.NodeCapacity{CPUCores: 2, RAMBytes: 4 * types.GiB}, ratio: 2, wantCPU: 3, wantRAM: 1.5, wantOK: true},
+		{name: "equal weights", nodePrice: 8, capacity: types.NodeCapacity{CPUCores: 2, RAMBytes: 6 * types.GiB}, ratio: 1, wantCPU: 1, wantRAM: 1, wantOK: true},
+		// 512 MiB is half a GiB: ramRate = 3 / (4*0.5 + 0.5)
+		{name: "RAM in bytes converted to GiB", nodePrice: 3, capacity: types.NodeCapacity{CPUCores: 0.5, RAMBytes: types.GiB / 2}, ratio: 4, wantCPU: 4.8, wantRAM: 1.2, wantOK: true},
+		{name: "no CPU capacity", nodePrice: 12, capacity: types.NodeCapacity{RAMBytes: 4 * types.GiB}, ratio: 2},
+		{name: "no RAM capacity", nodePrice: 12, capacity: types.NodeCapacity{CPUCores: 2}, ratio: 2},
+		{name: "no ratio", nodePrice: 12, capacity: types.NodeCapacity{CPUCores: 2, RAMBytes: 4 * types.GiB}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, ram, ok := splitNodePrice(tt.nodePrice, tt.capacity, tt.ratio)
+			if ok != tt.wantOK || math.Abs(cpu-tt.wantCPU) > 1e-9 || math.Abs(ram-tt.wantRAM) > 1e-9 {
+				t.Errorf("splitNodePrice() = (%v, %v, %v), want (%v, %v, %v)", cpu, ram, ok, tt.wantCPU, tt.wantRAM, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetRatesForNodeCapacityType(t *testing.T) {
+	prices := &types.Prices{
+		DefaultCPUPricePerHour:   1,
+		DefaultRAMPricePerGBHour: 1,
+		NodePriceByInstanceType:  map[string]float64{"m5.large": 12},
+		CPUToRAMCostRatio:        2,
+		CapacityTypes: map[string]types.CapacityTypePricing{
+			CapacityTypeSpot: {
+				NodePriceByInstanceType: map[string]float64{"m5.xlarge": 6},
+				PriceMultiplier:         0.5,
+			},
+		},
+	}
+	// 2 cores and 2 GiB: ramRate = P / (2*2 + 2)
+	capacity := types.NodeCapacity{CPUCores: 2, RAMBytes: 2 * types.GiB}
+	large := map[string]string{instanceTypeLabelKeys[0]: "m5.large"}
+	xlarge := map[string]string{instanceTypeLabelKeys[0]: "m5.xlarge"}
+
+	tests := []struct {
+		name             string
+		labels           map[string]string
+		capacityType     string
+		wantCPU, wantRAM float64
+	}{
+		{name: "on-demand node price", labels: large, capacityType: CapacityTypeOnDemand, wantCPU: 4, wantRAM: 2},
+		{name: "spot multiplier on node price", labels: large, capacityType: CapacityTypeSpot, wantCPU: 2, wantRAM: 1},
+		{name: "spot node price", labels: xlarge, capacityType: CapacityTypeSpot, wantCPU: 2, wantRAM: 1},
+		{name: "unknown capacity type", labels: nil, capacityType: "", wantCPU: 1, wantRAM: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, ram := getRatesForNode(prices, tt.labels, capacity, tt.capacityType)
+			if cpu != tt.wantCPU || ram != tt.wantRAM {
+				t.Errorf("rates = (%v, %v), want (%v, %v)", cpu, ram, tt.wantCPU, tt.wantRAM)
+			}
+		})
+	}
+}
+
+func TestNormalizeCapacityType(t *testing.T) {
+	tests := map[string]string{
+		"spot":      CapacityTypeSpot,
+		"SPOT":      CapacityTypeSpot,
+		"true":      CapacityTypeSpot,
+		"ON_DEMAND": CapacityTypeOnDemand,
+		"on-demand": CapacityTypeOnDemand,
+		"reserved":  "reserved",
+	}
+	for in, want := range tests {
+		if got := normalizeCapacityType(in); got != want {
+			t.Errorf("normalizeCapacityType(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
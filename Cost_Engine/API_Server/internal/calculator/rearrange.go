@@ -2,61 +2,211 @@ package calculator
 
 import (
 	"log/slog"
-	"regexp"
+	"slices"
+	"sort"
 
 	"simple-cost-calculator/internal/types"
 )
 
-func RearrangeCosts(podCosts []types.PodCost) (map[string]types.GroupedCostSummary, error) {
+// RearrangeCosts groups pod costs into the v1 response: group -> {namespace: cost, totalCost, window}.
+// totalCost is the charged amount, after tiered pricing, the namespaces stay at list price. The discount is
+// only reported by /v2, like the cluster breakdown, since every other key of the v1 map may be a namespace.
+func RearrangeCosts(podCosts []types.PodCost, pricing *types.PricingConfig, prior TierUsage) (map[string]types.GroupedCostSummary, error) {
 	if len(podCosts) == 0 {
 		slog.Info("RearrangeCosts received empty podCosts slice, returning empty map.")
 		return make(map[string]types.GroupedCostSummary), nil
 	}
 
-	intermediateResult := make(map[string]map[string]float64)
-	windows := make(map[string]types.Window) // Save window for each group
+	// make final result
+	finalResult := make(map[string]types.GroupedCostSummary)
+
+	for groupKey, tenant := range TenantCosts(podCosts, pricing, prior) {
+		summary := make(types.GroupedCostSummary)
+
+		for _, ns := range tenant.Namespaces {
+			summary[ns.Name] = ns.Total
+		}
+
+		summary["totalCost"] = tenant.Total
+		summary["window"] = tenant.Window
 
-	// Regex get namesapce type ns(anything)-user(digits)
-	re := regexp.MustCompile(`^(?:ns.+)-(user\d+)$`)
+		finalResult[groupKey] = summary
+	}
+
+	return finalResult, nil
+}
+
+// TenantCosts aggregates pod costs per tenant and applies tiered pricing, prior holds the usage
+// earlier in each tier period (see TierPeriods)
+func TenantCosts(podCosts []types.PodCost, pricing *types.PricingConfig, prior TierUsage) map[string]*types.TenantCost {
+	tenants := AggregateTenantCosts(podCosts, pricing.IdleCostPolicy, pricing.Currency)
+	ApplyTieredPricing(tenants, pricing.TieredPricing, prior)
+	return tenants
+}
 
+// AggregateTenantCosts groups pod costs by tenant and namespace with a CPU/RAM split, applying the idle cost policy.
+// Tenants come from the calculator's grouping rules, pods without one belong to "system". Tenants and namespaces
+// spanning several clusters are summed, with the list cost of each named cluster in Clusters.
+func AggregateTenantCosts(podCosts []types.PodCost, idlePolicy types.IdleCostPolicy, currency string) map[string]*types.TenantCost {
+	// Idle capacity of a cluster is only distributed to the namespaces running in it
+	byCluster := make(map[string][]types.PodCost)
 	for _, pc := range podCosts {
-		if pc.Namespace == "" {
-			slog.Debug("Skipping pod cost entry with empty namespace during rearrange", "pod", pc.Pod)
-			continue
+		byCluster[pc.Cluster] = append(byCluster[pc.Cluster], pc)
+	}
+	if len(byCluster) == 1 {
+		for name, rows := range byCluster {
+			if name == "" {
+				return aggregateClusterCosts(rows, idlePolicy, currency)
+			}
+		}
+	}
+
+	tenants := make(map[string]*types.TenantCost)
+	for name, rows := range byCluster {
+		for groupKey, tc := range aggregateClusterCosts(rows, idlePolicy, currency) {
+			tenant, exists := tenants[groupKey]
+			if !exists {
+				tenant = &types.TenantCost{Window: tc.Window, Currency: currency, Resources: newResourceCosts()}
+				tenants[groupKey] = tenant
+			}
+			tenant.Namespaces = mergeNamespaceCosts(tenant.Namespaces, tc.Namespaces)
+			addResources(&tenant.Resources, tc.Resources)
+			tenant.Total += tc.Total
+			tenant.Clusters = append(tenant.Clusters, types.ClusterCost{Name: name, Total: tc.Total, Resources: tc.Resources})
 		}
-		groupKey := "system" // Default group key "system" if regex doesn't match
-		originalNamespace := pc.Namespace
+	}
+	for _, tenant := range tenants {
+		sort.Slice(tenant.Clusters, func(i, j int) bool { return tenant.Clusters[i].Name < tenant.Clusters[j].Name })
+	}
+	return tenants
+}
 
-		matches := re.FindStringSubmatch(originalNamespace)
-		if len(matches) == 2 {
-			groupKey = matches[1]
+// mergeNamespaceCosts adds the namespaces of src to dst, summing namespaces present in both, ordered by name
+func mergeNamespaceCosts(dst, src []types.NamespaceCost) []types.NamespaceCost {
+	for _, ns := range src {
+		i := sort.Search(len(dst), func(i int) bool { return dst[i].Name >= ns.Name })
+		if i < len(dst) && dst[i].Name == ns.Name {
+			addResources(&dst[i].Resources, ns.Resources)
+			dst[i].Total += ns.Total
+			continue
 		}
+		dst = slices.Insert(dst, i, ns)
+	}
+	return dst
+}
+
+// aggregateClusterCosts is AggregateTenantCosts for the pod costs of one cluster
+func aggregateClusterCosts(podCosts []types.PodCost, idlePolicy types.IdleCostPolicy, currency string) map[string]*types.TenantCost {
+	namespaceCosts := make(map[string]map[string]*types.NamespaceCost)
+	windows := make(map[string]types.Window) // Save window for each group
+	var idle types.ResourceCosts
 
-		if _, exists := intermediateResult[groupKey]; !exists {
-			intermediateResult[groupKey] = make(map[string]float64)
+	addCost := func(groupKey, namespace string, pc types.PodCost) {
+		if _, exists := namespaceCosts[groupKey]; !exists {
+			namespaceCosts[groupKey] = make(map[string]*types.NamespaceCost)
 			windows[groupKey] = pc.Window
 		}
+		ns, exists := namespaceCosts[groupKey][namespace]
+		if !exists {
+			ns = &types.NamespaceCost{Name: namespace, Resources: newResourceCosts()}
+			namespaceCosts[groupKey][namespace] = ns
+		}
+		addResources(&ns.Resources, podResources(pc))
+	}
 
-		intermediateResult[groupKey][originalNamespace] += pc.TotalCost
+	for _, pc := range podCosts {
+		if pc.Idle {
+			switch idlePolicy {
+			case types.IdleCostSeparate:
+				// Idle rows are keyed by node name inside the idle group
+				addCost(types.IdleGroupKey, pc.Pod, pc)
+			case types.IdleCostDistribute:
+				addResources(&idle, podResources(pc))
+			}
+			continue
+		}
+		if pc.Namespace == "" {
+			slog.Debug("Skipping pod cost entry with empty namespace during rearrange", "pod", pc.Pod)
+			continue
+		}
+		groupKey := pc.Tenant // Resolved by the calculator's grouping rules
+		if groupKey == "" {
+			groupKey = "system"
+		}
+		addCost(groupKey, pc.Namespace, pc)
 	}
 
-	// make final result
-	finalResult := make(map[string]types.GroupedCostSummary)
+	if idlePolicy == types.IdleCostDistribute && idle.CPU.Cost+idle.RAM.Cost > 0 {
+		distributeIdleCost(namespaceCosts, idle)
+	}
 
-	for groupKey, namespaceCosts := range intermediateResult {
-		summary := make(types.GroupedCostSummary)
-		groupTotalCost := 0.0
+	tenants := make(map[string]*types.TenantCost, len(namespaceCosts))
+	for groupKey, namespaces := range namespaceCosts {
+		tenant := &types.TenantCost{
+			Window:     windows[groupKey],
+			Currency:   currency,
+			Namespaces: make([]types.NamespaceCost, 0, len(namespaces)),
+			Resources:  newResourceCosts(),
+		}
+		for _, ns := range namespaces {
+			ns.Total = ns.Resources.CPU.Cost + ns.Resources.RAM.Cost
+			tenant.Namespaces = append(tenant.Namespaces, *ns)
+			addResources(&tenant.Resources, ns.Resources)
+			tenant.Total += ns.Total
+		}
+		sort.Slice(tenant.Namespaces, func(i, j int) bool {
+			return tenant.Namespaces[i].Name < tenant.Namespaces[j].Name
+		})
+		tenants[groupKey] = tenant
+	}
+	return tenants
+}
 
-		for ns, cost := range namespaceCosts {
-			summary[ns] = cost
-			groupTotalCost += cost
+// distributeIdleCost adds idle cost to every namespace proportionally to its share of the total usage cost
+func distributeIdleCost(namespaceCosts map[string]map[string]*types.NamespaceCost, idle types.ResourceCosts) {
+	usageTotal := 0.0
+	for _, namespaces := range namespaceCosts {
+		for _, ns := range namespaces {
+			usageTotal += ns.Resources.CPU.Cost + ns.Resources.RAM.Cost
+		}
+	}
+	if usageTotal <= 0 {
+		slog.Warn("No usage cost to distribute idle cost over", "idle_cost", idle.CPU.Cost+idle.RAM.Cost)
+		return
+	}
+	for _, namespaces := range namespaceCosts {
+		for _, ns := range namespaces {
+			share := (ns.Resources.CPU.Cost + ns.Resources.RAM.Cost) / usageTotal
+			ns.Resources.CPU.Quantity += idle.CPU.Quantity * share
+			ns.Resources.CPU.Cost += idle.CPU.Cost * share
+			ns.Resources.RAM.Quantity += idle.RAM.Quantity * share
+			ns.Resources.RAM.Cost += idle.RAM.Cost * share
 		}
+	}
+}
 
-		summary["totalCost"] = groupTotalCost
-		summary["window"] = windows[groupKey]
+func newResourceCosts() types.ResourceCosts {
+	return types.ResourceCosts{
+		CPU: types.ResourceCost{Unit: types.UnitCoreHours},
+		RAM: types.ResourceCost{Unit: types.UnitGiBHours},
+	}
+}
 
-		finalResult[groupKey] = summary
+// podResources returns the billed quantities and costs of a pod cost row
+func podResources(pc types.PodCost) types.ResourceCosts {
+	rc := newResourceCosts()
+	rc.CPU.Quantity, rc.CPU.Cost = pc.CPUBilledCoreHours, pc.CPUCost
+	rc.RAM.Quantity, rc.RAM.Cost = pc.RAMBilledGiBHours, pc.RAMCost
+	if pc.Idle {
+		// Idle rows only carry capacity quantities
+		rc.CPU.Quantity, rc.RAM.Quantity = pc.CPUCoreHours, pc.RAMGiBHours
 	}
+	return rc
+}
 
-	return finalResult, nil
+func addResources(dst *types.ResourceCosts, src types.ResourceCosts) {
+	dst.CPU.Quantity += src.CPU.Quantity
+	dst.CPU.Cost += src.CPU.Cost
+	dst.RAM.Quantity += src.RAM.Quantity
+	dst.RAM.Cost += src.RAM.Cost
 }
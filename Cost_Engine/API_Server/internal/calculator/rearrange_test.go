@@ -0,0 +1,81 @@
+package calculator
+
+import (
+	"math"
+	"testing"
+
+	"simple-cost-calculator/internal/types"
+)
+
+func TestAggregateTenantCostsAcrossClusters(t *testing.T) {
+	podCosts := []types.PodCost{
+		{Cluster: "eu", Tenant: "user1", Namespace: "ns1-user1", Pod: "a", CPUCost: 3, TotalCost: 3},
+		{Cluster: "eu", Tenant: "user2", Namespace: "ns1-user2", Pod: "b", CPUCost: 1, TotalCost: 1},
+		{Cluster: "us", Tenant: "user1", Namespace: "ns1-user1", Pod: "a", CPUCost: 2, TotalCost: 2},
+		// Idle capacity of eu is shared 3:1 between the eu namespaces only
+		{Cluster: "eu", Namespace: types.IdleGroupKey, Pod: "node-eu", CPUCost: 4, TotalCost: 4, Idle: true},
+	}
+
+	tenants := AggregateTenantCosts(podCosts, types.IdleCostDistribute, "USD")
+
+	user1 := tenants["user1"]
+	if user1 == nil || len(user1.Namespaces) != 1 {
+		t.Fatalf("user1 = %+v, want one namespace summed across clusters", user1)
+	}
+	if math.Abs(user1.Total-8) > 1e-9 || math.Abs(user1.Namespaces[0].Total-8) > 1e-9 {
+		t.Errorf("user1 total %v namespace %v, want 8", user1.Total, user1.Namespaces[0].Total)
+	}
+	want := map[string]float64{"eu": 6, "us": 2}
+	if len(user1.Clusters) != len(want) {
+		t.Fatalf("user1 clusters = %+v, want %v", user1.Clusters, want)
+	}
+	for _, c := range user1.Clusters {
+		if math.Abs(c.Total-want[c.Name]) > 1e-9 {
+			t.Errorf("user1 cluster %s = %v, want %v", c.Name, c.Total, want[c.Name])
+		}
+	}
+	if got := tenants["user2"].Total; math.Abs(got-2) > 1e-9 {
+		t.Errorf("user2 total = %v, want 2", got)
+	}
+
+	// The v1 map keeps the breakdown out of the namespaces, a namespace named clusters included
+	podCosts = append(podCosts, types.PodCost{Cluster: "us", Tenant: "user2", Namespace: "clusters", Pod: "c", CPUCost: 1, TotalCost: 1})
+	summary, err := RearrangeCosts(podCosts, &types.PricingConfig{IdleCostPolicy: types.IdleCostDistribute}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := summary["user2"]["clusters"]; got != 1.0 {
+		t.Errorf("user2 namespace clusters = %v, want 1", got)
+	}
+	if _, ok := summary["user1"]["clusters"]; ok {
+		t.Errorf("user1 has a clusters entry %v, want only namespaces, totalCost and window", summary["user1"]["clusters"])
+	}
+
+	// Unnamed single cluster has no breakdown
+	single := AggregateTenantCosts([]types.PodCost{{Tenant: "user1", Namespace: "ns1-user1", Pod: "a", CPUCost: 1, TotalCost: 1}}, types.IdleCostNone, "USD")
+	if single["user1"].Clusters != nil {
+		t.Errorf("single cluster breakdown = %+v, want none", single["user1"].Clusters)
+	}
+}
+
+func TestRearrangeCostsDiscount(t *testing.T) {
+	podCosts := []types.PodCost{
+		{Tenant: "user1", Namespace: "ns1-user1", Pod: "a", CPUBilledCoreHours: 10, CPUCost: 30, TotalCost: 30},
+		{Tenant: "user1", Namespace: "discount", Pod: "b", CPUBilledCoreHours: 10, CPUCost: 30, TotalCost: 30},
+	}
+	pricing := &types.PricingConfig{TieredPricing: []types.TierPolicy{{
+		Resource: "cpu",
+		Period:   types.TierPeriodWindow,
+		Tiers:    []types.PriceTier{{UpTo: 5, Price: 0}, {Price: 3}},
+	}}}
+
+	summary, err := RearrangeCosts(podCosts, pricing, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user1 := summary["user1"]
+	// The namespaces stay at list price, a namespace named discount included, totalCost is charged
+	if user1["ns1-user1"] != 30.0 || user1["discount"] != 30.0 || user1["totalCost"] != 45.0 {
+		t.Errorf("namespaces %v and %v, totalCost %v, want 30, 30 and 45", user1["ns1-user1"], user1["discount"], user1["totalCost"])
+	}
+}
@@ -0,0 +1,115 @@
+// internal/calculator/tiers.go
+
+package calculator
+
+import (
+	"slices"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// TierUsage holds, per tier period, the tenant quantities that count towards the tier thresholds
+type TierUsage map[types.TierPeriod]PeriodUsage
+
+// PeriodUsage holds the quantities of a tier period around the window being priced. Prior is what each tenant
+// consumed earlier in the period the window starts in. Later holds, in order, what it consumed in every following
+// period the window reaches into, where thresholds start over.
+type PeriodUsage struct {
+	Prior map[string]types.ResourceCosts
+	Later []map[string]types.ResourceCosts
+}
+
+// ApplyTieredPricing charges the tenant quantities covered by a tier policy at the tier prices instead of
+// the list prices, recording the difference as discount lines. Namespace and resource costs stay at list
+// price, the tenant Total becomes the charged amount. The idle group is never tiered.
+func ApplyTieredPricing(tenants map[string]*types.TenantCost, policies []types.TierPolicy, prior TierUsage) {
+	if len(policies) == 0 {
+		return
+	}
+	for name, tenant := range tenants {
+		if name == types.IdleGroupKey {
+			continue
+		}
+		resources := map[string]types.ResourceCost{"cpu": tenant.Resources.CPU, "ram": tenant.Resources.RAM}
+		for _, resource := range []string{"cpu", "ram"} {
+			policy, ok := tierPolicyFor(policies, name, resource)
+			if !ok {
+				continue
+			}
+			usage := prior[policy.Period]
+			rc := resources[resource]
+			// Every later period starts over, the rest of the window is in the first period
+			first, tiered := rc.Quantity, 0.0
+			for _, later := range usage.Later {
+				quantity := resourceQuantity(later[name], resource)
+				first -= quantity
+				tiered += tieredCharge(policy.Tiers, quantity)
+			}
+			// Thresholds count what was already consumed in the first period, nothing for window periods
+			before := resourceQuantity(usage.Prior[name], resource)
+			first = max(first, 0)
+			tiered += tieredCharge(policy.Tiers, before+first) - tieredCharge(policy.Tiers, before)
+			line := types.DiscountLine{
+				Resource:   resource,
+				Period:     policy.Period,
+				Quantity:   rc.Quantity,
+				Unit:       rc.Unit,
+				ListCost:   rc.Cost,
+				TieredCost: tiered,
+				Discount:   rc.Cost - tiered,
+			}
+			tenant.Discounts = append(tenant.Discounts, line)
+			tenant.Discount += line.Discount
+			tenant.Total -= line.Discount
+		}
+	}
+}
+
+// TierPeriods returns the distinct non-window periods used by the policies, whose prior usage must be queried
+func TierPeriods(policies []types.TierPolicy) []types.TierPeriod {
+	var periods []types.TierPeriod
+	for _, policy := range policies {
+		if policy.Period != types.TierPeriodWindow && !slices.Contains(periods, policy.Period) {
+			periods = append(periods, policy.Period)
+		}
+	}
+	return periods
+}
+
+// tierPolicyFor returns the first policy for the resource that lists the tenant, or lists no tenants
+func tierPolicyFor(policies []types.TierPolicy, tenant, resource string) (types.TierPolicy, bool) {
+	for _, policy := range policies {
+		if policy.Resource == resource && (len(policy.Tenants) == 0 || slices.Contains(policy.Tenants, tenant)) {
+			return policy, true
+		}
+	}
+	return types.TierPolicy{}, false
+}
+
+// tieredCharge prices a cumulative quantity across the tiers, the last price also applies beyond the last bound
+func tieredCharge(tiers []types.PriceTier, quantity float64) float64 {
+	var charge, lower float64
+	for _, tier := range tiers {
+		if quantity <= lower {
+			break
+		}
+		upper := tier.UpTo
+		if upper == 0 || quantity < upper {
+			upper = quantity
+		}
+		charge += (upper - lower) * tier.Price
+		lower = upper
+	}
+	// Past the last bounded tier the last price keeps applying
+	if last := tiers[len(tiers)-1]; quantity > lower && last.UpTo != 0 {
+		charge += (quantity - lower) * last.Price
+	}
+	return charge
+}
+
+func resourceQuantity(rc types.ResourceCosts, resource string) float64 {
+	if resource == "cpu" {
+		return rc.CPU.Quantity
+	}
+	return rc.RAM.Quantity
+}
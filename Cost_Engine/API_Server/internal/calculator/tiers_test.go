@@ -0,0 +1,84 @@
+package calculator
+
+import (
+	"math"
+	"testing"
+
+	"simple-cost-calculator/internal/types"
+)
+
+func TestTieredCharge(t *testing.T) {
+	tiers := []types.PriceTier{{UpTo: 10, Price: 0}, {UpTo: 100, Price: 2}, {Price: 1}}
+	bounded := []types.PriceTier{{UpTo: 10, Price: 0}, {UpTo: 20, Price: 3}}
+	tests := []struct {
+		name     string
+		tiers    []types.PriceTier
+		quantity float64
+		want     float64
+	}{
+		{name: "within free tier", tiers: tiers, quantity: 5, want: 0},
+		{name: "second tier", tiers: tiers, quantity: 50, want: 80},
+		{name: "open last tier", tiers: tiers, quantity: 150, want: 180 + 50},
+		{name: "last price beyond last bound", tiers: bounded, quantity: 25, want: 30 + 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tieredCharge(tt.tiers, tt.quantity); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("tieredCharge(%v) = %v, want %v", tt.quantity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTieredPricing(t *testing.T) {
+	newTenant := func(cpuHours, cpuCost float64) *types.TenantCost {
+		return &types.TenantCost{
+			Total: cpuCost,
+			Resources: types.ResourceCosts{
+				CPU: types.ResourceCost{Quantity: cpuHours, Unit: types.UnitCoreHours, Cost: cpuCost},
+				RAM: types.ResourceCost{Unit: types.UnitGiBHours},
+			},
+		}
+	}
+	tenants := map[string]*types.TenantCost{
+		"user1":            newTenant(20, 100),
+		"user2":            newTenant(20, 100),
+		types.IdleGroupKey: newTenant(20, 100),
+	}
+	policies := []types.TierPolicy{{
+		Tenants:  []string{"user1", types.IdleGroupKey},
+		Resource: "cpu",
+		Period:   types.TierPeriodMonth,
+		Tiers:    []types.PriceTier{{UpTo: 10, Price: 0}, {Price: 2}},
+	}}
+	// user1 already used 5 free core-hours this month, 5 remain free
+	prior := TierUsage{types.TierPeriodMonth: {Prior: map[string]types.ResourceCosts{"user1": {CPU: types.ResourceCost{Quantity: 5}}}}}
+
+	ApplyTieredPricing(tenants, policies, prior)
+
+	user1 := tenants["user1"]
+	if len(user1.Discounts) != 1 || user1.Discounts[0].TieredCost != 30 {
+		t.Fatalf("user1 discounts = %+v, want one line with tiered cost 30", user1.Discounts)
+	}
+	if user1.Discount != 70 || user1.Total != 30 {
+		t.Errorf("user1 discount = %v total = %v, want 70 and 30", user1.Discount, user1.Total)
+	}
+	if tenants["user2"].Total != 100 || len(tenants["user2"].Discounts) != 0 {
+		t.Errorf("user2 not covered by a policy but got %+v", tenants["user2"])
+	}
+	if tenants[types.IdleGroupKey].Total != 100 {
+		t.Errorf("idle group must not be tiered, got total %v", tenants[types.IdleGroupKey].Total)
+	}
+
+	// 12 of the 20 core-hours fall in the next month, whose 10 free core-hours start over: the 8 left in
+	// this month cost 3*2 after the 5 prior free ones, the next month 2*2
+	tenants = map[string]*types.TenantCost{"user1": newTenant(20, 100)}
+	prior[types.TierPeriodMonth] = PeriodUsage{
+		Prior: prior[types.TierPeriodMonth].Prior,
+		Later: []map[string]types.ResourceCosts{{"user1": {CPU: types.ResourceCost{Quantity: 12}}}},
+	}
+	ApplyTieredPricing(tenants, policies, prior)
+	if got := tenants["user1"].Total; got != 10 {
+		t.Errorf("user1 total across a month boundary = %v, want 10", got)
+	}
+}
@@ -0,0 +1,192 @@
+// internal/calculator/timeseries.go
+
+package calculator
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+// CalculateCostTimeSeries calculates the cost of every tenant and namespace for each step of the time range.
+// A *PartialResultError comes with the series, whose points are zero over the missing sub-ranges.
+func (cc *CostCalculator) CalculateCostTimeSeries(ctx context.Context, start, end time.Time, step time.Duration, opts CalcOptions) (*types.CostTimeSeries, error) {
+	pricing := cc.Pricing()
+	details, err := cc.calculate(ctx, pricing, start, end, step, opts)
+	var partial *PartialResultError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
+	}
+	series := buildCostTimeSeries(details, start, end, step, pricing.IdleCostPolicy)
+	versions := map[string]bool{}
+	for _, detail := range details {
+		versions[detail.cost.PricingVersion] = true
+	}
+	series.PricingVersion = joinSorted(versions)
+	if series.PricingVersion == "" {
+		series.PricingVersion = pricing.Version
+	}
+	return series, err
+}
+
+// seriesKey identifies the series of a namespace in a cluster
+type seriesKey struct {
+	cluster, tenant, namespace string
+}
+
+// buildCostTimeSeries buckets per-step pod costs by tenant and namespace, applying the idle policy per step
+// the same way AggregateTenantCosts applies it to window totals: idle capacity of a cluster is distributed to
+// the namespaces running in it only
+func buildCostTimeSeries(details []podCostDetail, start, end time.Time, step time.Duration, idlePolicy types.IdleCostPolicy) *types.CostTimeSeries {
+	// Samples are evaluated at start+step, start+2*step, ..., end
+	var timestamps []time.Time
+	stepIndex := make(map[model.Time]int)
+	for t := start.Add(step); !t.After(end); t = t.Add(step) {
+		stepIndex[model.TimeFromUnixNano(t.UnixNano())] = len(timestamps)
+		timestamps = append(timestamps, t)
+	}
+
+	namespaceSteps := make(map[seriesKey][]stepCost)
+	idleSteps := make(map[string][]stepCost) // by cluster
+	addSteps := func(key seriesKey, steps stepCosts) {
+		buckets, exists := namespaceSteps[key]
+		if !exists {
+			buckets = make([]stepCost, len(timestamps))
+			namespaceSteps[key] = buckets
+		}
+		for ts, c := range steps {
+			if i, ok := stepIndex[ts]; ok {
+				buckets[i].cpu += c.cpu
+				buckets[i].ram += c.ram
+			}
+		}
+	}
+
+	for _, detail := range details {
+		pc := detail.cost
+		if pc.Idle {
+			switch idlePolicy {
+			case types.IdleCostSeparate:
+				// Idle series are keyed by node name inside the idle group
+				addSteps(seriesKey{cluster: pc.Cluster, tenant: types.IdleGroupKey, namespace: pc.Pod}, detail.steps)
+			case types.IdleCostDistribute:
+				buckets, exists := idleSteps[pc.Cluster]
+				if !exists {
+					buckets = make([]stepCost, len(timestamps))
+					idleSteps[pc.Cluster] = buckets
+				}
+				for ts, c := range detail.steps {
+					if i, ok := stepIndex[ts]; ok {
+						buckets[i].cpu += c.cpu
+						buckets[i].ram += c.ram
+					}
+				}
+			}
+			continue
+		}
+		if pc.Namespace == "" {
+			continue
+		}
+		tenant := pc.Tenant
+		if tenant == "" {
+			tenant = "system"
+		}
+		addSteps(seriesKey{cluster: pc.Cluster, tenant: tenant, namespace: pc.Namespace}, detail.steps)
+	}
+
+	for cluster, idle := range idleSteps {
+		for i := range idle {
+			usageTotal := 0.0
+			for key, buckets := range namespaceSteps {
+				if key.cluster == cluster {
+					usageTotal += buckets[i].cpu + buckets[i].ram
+				}
+			}
+			if usageTotal <= 0 {
+				continue
+			}
+			for key, buckets := range namespaceSteps {
+				if key.cluster != cluster {
+					continue
+				}
+				share := (buckets[i].cpu + buckets[i].ram) / usageTotal
+				buckets[i].cpu += idle[i].cpu * share
+				buckets[i].ram += idle[i].ram * share
+			}
+		}
+	}
+
+	// Namespaces and idle nodes are summed across clusters
+	merged := make(map[seriesKey][]stepCost)
+	for key, buckets := range namespaceSteps {
+		key.cluster = ""
+		if sum, exists := merged[key]; exists {
+			for i, c := range buckets {
+				sum[i].cpu += c.cpu
+				sum[i].ram += c.ram
+			}
+			continue
+		}
+		merged[key] = buckets
+	}
+
+	result := &types.CostTimeSeries{
+		Window:     types.Window{Start: start, End: end},
+		Step:       step.String(),
+		Tenants:    []types.CostSeries{},
+		Namespaces: []types.CostSeries{},
+	}
+	tenantSteps := make(map[string][]stepCost)
+	for key, buckets := range merged {
+		result.Namespaces = append(result.Namespaces, types.CostSeries{
+			Tenant:    key.tenant,
+			Namespace: key.namespace,
+			Points:    toCostPoints(timestamps, buckets),
+		})
+		tenantBuckets, exists := tenantSteps[key.tenant]
+		if !exists {
+			tenantBuckets = make([]stepCost, len(timestamps))
+			tenantSteps[key.tenant] = tenantBuckets
+		}
+		for i, c := range buckets {
+			tenantBuckets[i].cpu += c.cpu
+			tenantBuckets[i].ram += c.ram
+		}
+	}
+	for tenant, buckets := range tenantSteps {
+		result.Tenants = append(result.Tenants, types.CostSeries{
+			Tenant: tenant,
+			Points: toCostPoints(timestamps, buckets),
+		})
+	}
+
+	sort.Slice(result.Tenants, func(i, j int) bool {
+		return result.Tenants[i].Tenant < result.Tenants[j].Tenant
+	})
+	sort.Slice(result.Namespaces, func(i, j int) bool {
+		a, b := result.Namespaces[i], result.Namespaces[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		return a.Namespace < b.Namespace
+	})
+	return result
+}
+
+func toCostPoints(timestamps []time.Time, buckets []stepCost) []types.CostPoint {
+	points := make([]types.CostPoint, len(timestamps))
+	for i, t := range timestamps {
+		points[i] = types.CostPoint{
+			Timestamp: t,
+			CPUCost:   buckets[i].cpu,
+			RAMCost:   buckets[i].ram,
+			TotalCost: buckets[i].cpu + buckets[i].ram,
+		}
+	}
+	return points
+}
@@ -0,0 +1,125 @@
+package calculator
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestBuildCostTimeSeriesAcrossClusters(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Minute)
+	first := model.TimeFromUnix(start.Add(time.Minute).Unix())
+	detail := func(pc types.PodCost, cpu float64) podCostDetail {
+		return podCostDetail{cost: pc, steps: stepCosts{first: {cpu: cpu}}}
+	}
+	// The rows of TestAggregateTenantCostsAcrossClusters, all in the first step
+	details := []podCostDetail{
+		detail(types.PodCost{Cluster: "eu", Tenant: "user1", Namespace: "ns1-user1", Pod: "a", CPUCost: 3, TotalCost: 3}, 3),
+		detail(types.PodCost{Cluster: "eu", Tenant: "user2", Namespace: "ns1-user2", Pod: "b", CPUCost: 1, TotalCost: 1}, 1),
+		detail(types.PodCost{Cluster: "us", Tenant: "user1", Namespace: "ns1-user1", Pod: "a", CPUCost: 2, TotalCost: 2}, 2),
+		detail(types.PodCost{Cluster: "eu", Namespace: types.IdleGroupKey, Pod: "node-eu", CPUCost: 4, TotalCost: 4, Idle: true}, 4),
+	}
+	podCosts := make([]types.PodCost, 0, len(details))
+	for _, d := range details {
+		podCosts = append(podCosts, d.cost)
+	}
+
+	series := buildCostTimeSeries(details, start, end, time.Minute, types.IdleCostDistribute)
+	tenants := AggregateTenantCosts(podCosts, types.IdleCostDistribute, "USD")
+	if len(series.Tenants) != len(tenants) {
+		t.Fatalf("tenant series = %+v, want %d tenants", series.Tenants, len(tenants))
+	}
+	// Idle capacity of eu is shared 3:1 between the eu namespaces only, as in the window totals
+	for _, s := range series.Tenants {
+		if got, want := s.Points[0].TotalCost, tenants[s.Tenant].Total; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s first step = %v, want the window total %v", s.Tenant, got, want)
+		}
+		if s.Points[1].TotalCost != 0 {
+			t.Errorf("%s second step = %v, want 0", s.Tenant, s.Points[1].TotalCost)
+		}
+	}
+	if len(series.Namespaces) != 2 {
+		t.Errorf("namespace series = %+v, want ns1-user1 summed across clusters and ns1-user2", series.Namespaces)
+	}
+}
+
+func TestCalculateCostTimeSeriesReplay(t *testing.T) {
+	// On the replay (see TestIdleCostPolicies) web of user1 runs the 10 minutes, batch of user2 the first 5,
+	// and the idle capacity of node-a costs 6.25
+	replay, err := source.NewReplay("testdata/replay", prom.StandaloneCAdvisor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+
+	tests := []struct {
+		policy types.IdleCostPolicy
+		want   map[string]float64
+	}{
+		{policy: types.IdleCostNone, want: map[string]float64{"user1": 1, "user2": 0.75}},
+		{policy: types.IdleCostSeparate, want: map[string]float64{"user1": 1, "user2": 0.75, types.IdleGroupKey: 6.25}},
+		// Idle cost is shared by the usage of each step: 2.75 idle in the first 5 steps goes 2:3 to web and
+		// batch, the 3.5 of the last 5 to web alone
+		{policy: types.IdleCostDistribute, want: map[string]float64{"user1": 1 + 2.75*0.4 + 3.5, "user2": 0.75 + 2.75*0.6}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			pricing := &types.PricingConfig{
+				Prices:         types.Prices{DefaultCPUPricePerHour: 6, DefaultRAMPricePerGBHour: 3},
+				IdleCostPolicy: tt.policy,
+				BillingMode:    types.BillingModeUsage,
+			}
+			series, err := NewCostCalculator(replay, pricing, grouper).CalculateCostTimeSeries(context.Background(), start, end, time.Minute, CalcOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(series.Tenants) != len(tt.want) {
+				t.Fatalf("tenant series = %+v, want %v", series.Tenants, tt.want)
+			}
+			for _, s := range series.Tenants {
+				// One point per step, ending at start+1m, ..., end
+				if len(s.Points) != 10 || !s.Points[0].Timestamp.Equal(start.Add(time.Minute)) || !s.Points[9].Timestamp.Equal(end) {
+					t.Fatalf("%s points = %+v, want the 10 steps ending at 00:01 to 00:10", s.Tenant, s.Points)
+				}
+				total := 0.0
+				for _, p := range s.Points {
+					total += p.TotalCost
+				}
+				if want, ok := tt.want[s.Tenant]; !ok || math.Abs(total-want) > 1e-9 {
+					t.Errorf("%s steps sum to %v, want %v", s.Tenant, total, want)
+				}
+				// The tenant series rolls up its namespace series
+				for i, p := range s.Points {
+					sum := 0.0
+					for _, ns := range series.Namespaces {
+						if ns.Tenant == s.Tenant {
+							sum += ns.Points[i].TotalCost
+						}
+					}
+					if math.Abs(sum-p.TotalCost) > 1e-9 {
+						t.Errorf("%s step %d = %v, want the sum of its namespaces %v", s.Tenant, i, p.TotalCost, sum)
+					}
+				}
+				// batch stopped after the first 5 steps
+				if s.Tenant == "user2" && (s.Points[4].TotalCost <= 0 || s.Points[5].TotalCost != 0) {
+					t.Errorf("user2 points = %+v, want cost in the first 5 steps only", s.Points)
+				}
+			}
+		})
+	}
+}
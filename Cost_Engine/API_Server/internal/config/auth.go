@@ -0,0 +1,97 @@
+// internal/config/auth.go
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"simple-cost-calculator/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Loads the API credentials from a YAML file, reading the keyFile of every API key.
+func LoadAuthConfig(filePath string) (*types.AuthConfig, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading auth file '%s': %w", filePath, err)
+	}
+
+	var config types.AuthConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling auth config '%s': %w", filePath, err)
+	}
+
+	// Validation
+	if len(config.APIKeys) == 0 && config.JWT == nil {
+		return nil, fmt.Errorf("no apiKeys nor jwt in auth config '%s'", filePath)
+	}
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i := range config.APIKeys {
+		key := &config.APIKeys[i]
+		if key.Name == "" {
+			return nil, fmt.Errorf("API key %d has no name in auth config '%s'", i, filePath)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("duplicate API key '%s' in auth config '%s'", key.Name, filePath)
+		}
+		names[key.Name] = true
+		if key.KeyFile != "" {
+			if key.Key != "" {
+				return nil, fmt.Errorf("API key '%s' has both key and keyFile in auth config '%s'", key.Name, filePath)
+			}
+			secret, err := os.ReadFile(key.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("error reading keyFile of API key '%s': %w", key.Name, err)
+			}
+			key.Key = strings.TrimSpace(string(secret))
+		}
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key '%s' has no key in auth config '%s'", key.Name, filePath)
+		}
+		if keys[key.Key] {
+			return nil, fmt.Errorf("API key '%s' reuses the key of another in auth config '%s'", key.Name, filePath)
+		}
+		keys[key.Key] = true
+		if key.Role == "" {
+			key.Role = types.AuthRoleTenant
+		}
+		if err := validateRole(key.Role, key.Tenant); err != nil {
+			return nil, fmt.Errorf("API key '%s': %w in auth config '%s'", key.Name, err, filePath)
+		}
+	}
+	if jwt := config.JWT; jwt != nil {
+		if jwt.JWKSFile == "" {
+			return nil, fmt.Errorf("jwt has no jwksFile in auth config '%s'", filePath)
+		}
+		if jwt.TenantClaim == "" {
+			jwt.TenantClaim = "tenant"
+		}
+		if jwt.RoleClaim == "" {
+			jwt.RoleClaim = "role"
+		}
+	}
+
+	return &config, nil
+}
+
+// validateRole checks that a tenant credential names its tenant and that others do not
+func validateRole(role types.AuthRole, tenant string) error {
+	switch role {
+	case types.AuthRoleTenant:
+		if tenant == "" {
+			return fmt.Errorf("tenant role needs a tenant")
+		}
+	case types.AuthRoleAdmin, types.AuthRoleService:
+		if tenant != "" {
+			return fmt.Errorf("%s role reads every tenant, remove tenant '%s'", role, tenant)
+		}
+	default:
+		return fmt.Errorf("invalid role '%s' (admin, service, tenant)", role)
+	}
+	return nil
+}
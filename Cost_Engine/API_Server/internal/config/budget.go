@@ -0,0 +1,134 @@
+// internal/config/budget.go
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultBudgetThresholds apply when neither the budget nor the config sets thresholds
+var DefaultBudgetThresholds = []float64{50, 80, 100}
+
+// Loads the budget configuration from a YAML file, a missing file is an empty configuration
+// that budgets created through the API will be saved to.
+func LoadBudgetConfig(filePath string) (*types.BudgetConfig, error) {
+	var config types.BudgetConfig
+	data, err := os.ReadFile(filePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("error reading budget file '%s': %w", filePath, err)
+	default:
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("error unmarshalling budget config '%s': %w", filePath, err)
+		}
+	}
+
+	// Validation
+	if len(config.DefaultThresholds) == 0 {
+		config.DefaultThresholds = DefaultBudgetThresholds
+	}
+	if err := validateThresholds(config.DefaultThresholds); err != nil {
+		return nil, fmt.Errorf("invalid defaultThresholds: %w in budget config '%s'", err, filePath)
+	}
+	for i, webhook := range config.Webhooks {
+		if webhook.URL == "" {
+			return nil, fmt.Errorf("webhook %d has no url in budget config '%s'", i, filePath)
+		}
+		switch webhook.Format {
+		case "":
+			config.Webhooks[i].Format = types.WebhookGeneric
+		case types.WebhookAlertmanager, types.WebhookGeneric:
+		default:
+			return nil, fmt.Errorf("invalid webhook format '%s' (alertmanager, generic) in budget config '%s'", webhook.Format, filePath)
+		}
+	}
+	names := make(map[string]bool)
+	for i := range config.Budgets {
+		if err := ValidateBudget(&config.Budgets[i]); err != nil {
+			return nil, fmt.Errorf("%w in budget config '%s'", err, filePath)
+		}
+		if names[config.Budgets[i].Name] {
+			return nil, fmt.Errorf("duplicate budget '%s' in budget config '%s'", config.Budgets[i].Name, filePath)
+		}
+		names[config.Budgets[i].Name] = true
+	}
+
+	return &config, nil
+}
+
+// ValidateBudget checks a budget from the config file or the API and defaults its period to monthly
+func ValidateBudget(budget *types.Budget) error {
+	if budget.Name == "" {
+		return fmt.Errorf("budget has no name")
+	}
+	if budget.Tenant == "" {
+		return fmt.Errorf("budget '%s' has no tenant", budget.Name)
+	}
+	if budget.Amount <= 0 {
+		return fmt.Errorf("budget '%s' amount must be > 0", budget.Name)
+	}
+	switch budget.Period {
+	case "":
+		budget.Period = types.BudgetMonthly
+	case types.BudgetMonthly:
+	case types.BudgetRolling:
+		window, err := time.ParseDuration(budget.Window)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("rolling budget '%s' needs a positive window (e.g. 168h)", budget.Name)
+		}
+	default:
+		return fmt.Errorf("budget '%s' has invalid period '%s' (monthly, rolling)", budget.Name, budget.Period)
+	}
+	if budget.Period == types.BudgetMonthly && budget.Window != "" {
+		return fmt.Errorf("monthly budget '%s' cannot set a window", budget.Name)
+	}
+	if budget.Period == types.BudgetRolling && budget.ForecastAlert {
+		return fmt.Errorf("rolling budget '%s' cannot alert on the month-end forecast", budget.Name)
+	}
+	if err := validateThresholds(budget.Thresholds); err != nil {
+		return fmt.Errorf("budget '%s' thresholds: %w", budget.Name, err)
+	}
+	return nil
+}
+
+func validateThresholds(thresholds []float64) error {
+	for _, threshold := range thresholds {
+		if threshold <= 0 {
+			return fmt.Errorf("threshold %v must be > 0", threshold)
+		}
+	}
+	return nil
+}
+
+// SaveBudgetConfig writes the budget configuration atomically, so a crash never leaves a truncated file
+func SaveBudgetConfig(filePath string, config *types.BudgetConfig) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error marshalling budget config: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".budgets-*.yaml")
+	if err != nil {
+		return fmt.Errorf("error writing budget file '%s': %w", filePath, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing budget file '%s': %w", filePath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing budget file '%s': %w", filePath, err)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("error writing budget file '%s': %w", filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// internal/config/cluster.go
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Loads the cluster list from a YAML file, with the resolved label schemas of the clusters setting one, by name.
+func LoadClusterConfig(filePath string) (*types.ClusterConfig, map[string]prom.LabelSchema, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading cluster file '%s': %w", filePath, err)
+	}
+
+	var config types.ClusterConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling cluster config '%s': %w", filePath, err)
+	}
+
+	// Validation
+	if len(config.Clusters) == 0 {
+		return nil, nil, fmt.Errorf("no clusters in cluster config '%s'", filePath)
+	}
+	names := make(map[string]bool)
+	schemas := make(map[string]prom.LabelSchema)
+	for i, cluster := range config.Clusters {
+		if cluster.Name == "" {
+			return nil, nil, fmt.Errorf("cluster %d has no name in cluster config '%s'", i, filePath)
+		}
+		if names[cluster.Name] {
+			return nil, nil, fmt.Errorf("duplicate cluster '%s' in cluster config '%s'", cluster.Name, filePath)
+		}
+		names[cluster.Name] = true
+		if cluster.PrometheusAddress == "" {
+			return nil, nil, fmt.Errorf("cluster '%s' has no prometheusAddress in cluster config '%s'", cluster.Name, filePath)
+		}
+		if cluster.LabelSchema != nil {
+			schema, err := prom.NewLabelSchema(*cluster.LabelSchema)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cluster '%s': %w in cluster config '%s'", cluster.Name, err, filePath)
+			}
+			schemas[cluster.Name] = schema
+		}
+	}
+
+	return &config, schemas, nil
+}
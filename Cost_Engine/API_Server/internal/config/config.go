@@ -3,8 +3,13 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"time"
 
 	"simple-cost-calculator/internal/types"
 
@@ -22,6 +27,7 @@ func LoadPricingConfig(filePath string) (*types.PricingConfig, error) {
 	// default prices
 	config.CPUPriceByInstanceType = make(map[string]float64)
 	config.RAMPriceByInstanceType = make(map[string]float64)
+	config.NodePriceByInstanceType = make(map[string]float64)
 
 	err = yaml.Unmarshal(data, &config)
 	if err != nil {
@@ -29,12 +35,178 @@ func LoadPricingConfig(filePath string) (*types.PricingConfig, error) {
 	}
 
 	// Validation
-	if config.DefaultCPUPricePerHour <= 0 {
-		return nil, fmt.Errorf("invalid defaultCPUPricePerHour (<= 0) in pricing config '%s'", filePath)
+	if err := validatePrices(config.Prices); err != nil {
+		return nil, fmt.Errorf("%w in pricing config '%s'", err, filePath)
 	}
 
-	if config.DefaultRAMPricePerGBHour <= 0 {
-		return nil, fmt.Errorf("invalid defaultRAMPricePerGBHour (<= 0) in pricing config '%s'", filePath)
+	sort.SliceStable(config.Schedule, func(i, j int) bool {
+		return config.Schedule[i].EffectiveFrom.Before(config.Schedule[j].EffectiveFrom)
+	})
+	for i, entry := range config.Schedule {
+		if entry.EffectiveFrom.IsZero() {
+			return nil, fmt.Errorf("schedule entry %d has no effectiveFrom in pricing config '%s'", i, filePath)
+		}
+		if !entry.EffectiveTo.IsZero() && !entry.EffectiveTo.After(entry.EffectiveFrom) {
+			return nil, fmt.Errorf("schedule entry from %s ends before it starts in pricing config '%s'", entry.EffectiveFrom.Format(time.RFC3339), filePath)
+		}
+		if i > 0 {
+			prev := config.Schedule[i-1]
+			if prev.EffectiveTo.IsZero() || prev.EffectiveTo.After(entry.EffectiveFrom) {
+				return nil, fmt.Errorf("schedule entries from %s and %s overlap in pricing config '%s'", prev.EffectiveFrom.Format(time.RFC3339), entry.EffectiveFrom.Format(time.RFC3339), filePath)
+			}
+		}
+		if err := validatePrices(entry.Resolve(config.Prices)); err != nil {
+			return nil, fmt.Errorf("%w in schedule entry from %s in pricing config '%s'", err, entry.EffectiveFrom.Format(time.RFC3339), filePath)
+		}
+	}
+
+	for i := range config.TieredPricing {
+		if err := validateTierPolicy(&config.TieredPricing[i]); err != nil {
+			return nil, fmt.Errorf("invalid tieredPricing entry %d: %w in pricing config '%s'", i, err, filePath)
+		}
+	}
+
+	switch config.IdleCostPolicy {
+	case "":
+		config.IdleCostPolicy = types.IdleCostNone
+	case types.IdleCostNone, types.IdleCostSeparate, types.IdleCostDistribute:
+	default:
+		return nil, fmt.Errorf("invalid idleCostPolicy '%s' (none, separate, distribute) in pricing config '%s'", config.IdleCostPolicy, filePath)
+	}
+
+	if config.Currency == "" {
+		config.Currency = "USD"
+	}
+
+	if config.BillingMode == "" {
+		config.BillingMode = types.BillingModeUsage
+	} else if _, err := types.ParseBillingMode(string(config.BillingMode)); err != nil {
+		return nil, fmt.Errorf("%w in pricing config '%s'", err, filePath)
+	}
+
+	config.Version, err = PricingVersion(&config)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing pricing config '%s': %w", filePath, err)
+	}
+
+	return &config, nil
+}
+
+// validatePrices checks that every rate is positive and that node prices can be split
+func validatePrices(prices types.Prices) error {
+	if prices.DefaultCPUPricePerHour <= 0 {
+		return fmt.Errorf("invalid defaultCPUPricePerHour (<= 0)")
+	}
+	if prices.DefaultRAMPricePerGBHour <= 0 {
+		return fmt.Errorf("invalid defaultRAMPricePerGBHour (<= 0)")
+	}
+	for instanceType, price := range prices.NodePriceByInstanceType {
+		if price <= 0 {
+			return fmt.Errorf("invalid nodePriceByInstanceType '%s' (<= 0)", instanceType)
+		}
+	}
+	if len(prices.NodePriceByInstanceType) > 0 && prices.CPUToRAMCostRatio <= 0 {
+		return fmt.Errorf("cpuToRAMCostRatio must be > 0 when nodePriceByInstanceType is set")
+	}
+	for capacityType, ct := range prices.CapacityTypes {
+		if ct.PriceMultiplier < 0 {
+			return fmt.Errorf("invalid priceMultiplier (< 0) for capacity type '%s'", capacityType)
+		}
+		for instanceType, price := range ct.NodePriceByInstanceType {
+			if price <= 0 {
+				return fmt.Errorf("invalid nodePriceByInstanceType '%s' (<= 0) for capacity type '%s'", instanceType, capacityType)
+			}
+		}
+		if len(ct.NodePriceByInstanceType) > 0 && prices.CPUToRAMCostRatio <= 0 {
+			return fmt.Errorf("cpuToRAMCostRatio must be > 0 when nodePriceByInstanceType is set for capacity type '%s'", capacityType)
+		}
+	}
+	return nil
+}
+
+// validateTierPolicy checks a tier policy and defaults its period to window
+func validateTierPolicy(policy *types.TierPolicy) error {
+	if policy.Resource != "cpu" && policy.Resource != "ram" {
+		return fmt.Errorf("resource '%s' (cpu, ram)", policy.Resource)
+	}
+	switch policy.Period {
+	case "":
+		policy.Period = types.TierPeriodWindow
+	case types.TierPeriodWindow, types.TierPeriodDay, types.TierPeriodMonth:
+	default:
+		return fmt.Errorf("period '%s' (window, day, month)", policy.Period)
+	}
+	if len(policy.Tiers) == 0 {
+		return fmt.Errorf("no tiers")
+	}
+	previous := 0.0
+	for i, tier := range policy.Tiers {
+		if tier.Price < 0 {
+			return fmt.Errorf("tier %d price < 0", i)
+		}
+		last := i == len(policy.Tiers)-1
+		if tier.UpTo == 0 && last {
+			continue
+		}
+		if tier.UpTo <= previous {
+			return fmt.Errorf("tier %d upTo must be greater than the previous tier (only the last tier may omit it)", i)
+		}
+		previous = tier.UpTo
+	}
+	return nil
+}
+
+// PricingVersion identifies a parsed pricing config by the hash of its canonical JSON, so a bill can be traced
+// back to its prices and edits of comments or layout keep the version
+func PricingVersion(conf *types.PricingConfig) (string, error) {
+	normalized := *conf
+	normalized.Version = ""
+	normalized.Schedule = make([]types.PriceEntry, len(conf.Schedule))
+	for i, entry := range conf.Schedule {
+		entry.EffectiveFrom, entry.EffectiveTo = entry.EffectiveFrom.UTC(), entry.EffectiveTo.UTC()
+		normalized.Schedule[i] = entry
+	}
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])[:12], nil
+}
+
+// PricingVersionDuring identifies the prices in effect from start to end: the version of the config without the
+// schedule entries outside the range, so adding or changing entries of other periods keeps it. It is the
+// PricingVersion when every entry overlaps the range.
+func PricingVersionDuring(conf *types.PricingConfig, start, end time.Time) (string, error) {
+	during := *conf
+	during.Schedule = nil
+	for _, entry := range conf.Schedule {
+		if entry.EffectiveFrom.Before(end) && (entry.EffectiveTo.IsZero() || entry.EffectiveTo.After(start)) {
+			during.Schedule = append(during.Schedule, entry)
+		}
+	}
+	return PricingVersion(&during)
+}
+
+// Loads the tenant grouping configuration from a YAML file.
+func LoadGroupingConfig(filePath string) (*types.GroupingConfig, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading grouping file '%s': %w", filePath, err)
+	}
+
+	var config types.GroupingConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling grouping config '%s': %w", filePath, err)
+	}
+
+	// Validation
+	if config.Version == "" {
+		return nil, fmt.Errorf("missing version in grouping config '%s'", filePath)
+	}
+	if config.DefaultTenant == "" {
+		config.DefaultTenant = "system"
 	}
 
 	return &config, nil
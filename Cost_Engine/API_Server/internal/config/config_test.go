@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+func TestLoadPricingConfigSchedule(t *testing.T) {
+	const base = "defaultCPUPricePerHour: 1\ndefaultRAMPricePerGBHour: 1\n"
+	tests := []struct {
+		name     string
+		schedule string
+		wantErr  bool
+	}{
+		{name: "no schedule"},
+		{name: "contiguous entries", schedule: `
+schedule:
+  - effectiveFrom: 2025-02-01T00:00:00Z
+    defaultCPUPricePerHour: 3
+  - effectiveFrom: 2025-01-01T00:00:00Z
+    effectiveTo: 2025-02-01T00:00:00Z
+    defaultCPUPricePerHour: 2
+`},
+		{name: "overlapping entries", wantErr: true, schedule: `
+schedule:
+  - effectiveFrom: 2025-01-01T00:00:00Z
+    effectiveTo: 2025-02-15T00:00:00Z
+  - effectiveFrom: 2025-02-01T00:00:00Z
+`},
+		{name: "open-ended entry before another", wantErr: true, schedule: `
+schedule:
+  - effectiveFrom: 2025-01-01T00:00:00Z
+  - effectiveFrom: 2025-02-01T00:00:00Z
+`},
+		{name: "missing effectiveFrom", wantErr: true, schedule: `
+schedule:
+  - effectiveTo: 2025-02-01T00:00:00Z
+`},
+		{name: "negative entry price", wantErr: true, schedule: `
+schedule:
+  - effectiveFrom: 2025-01-01T00:00:00Z
+    defaultRAMPricePerGBHour: -1
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pricing.yaml")
+			if err := os.WriteFile(path, []byte(base+tt.schedule), 0644); err != nil {
+				t.Fatal(err)
+			}
+			conf, err := LoadPricingConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(conf.Schedule) > 1 && !conf.Schedule[0].EffectiveFrom.Before(conf.Schedule[1].EffectiveFrom) {
+				t.Errorf("schedule not sorted: %+v", conf.Schedule)
+			}
+		})
+	}
+}
+
+func TestPricingVersion(t *testing.T) {
+	const base = "defaultCPUPricePerHour: 1\ndefaultRAMPricePerGBHour: 1\nschedule:\n  - effectiveFrom: 2025-01-01T00:00:00Z\n    defaultCPUPricePerHour: 2\n"
+	load := func(content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "pricing.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		conf, err := LoadPricingConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conf.Version
+	}
+	version := load(base)
+
+	tests := []struct {
+		name     string
+		content  string
+		wantSame bool
+	}{
+		{name: "comment", content: "# prices of 2025\n" + base, wantSame: true},
+		{name: "layout", content: "defaultRAMPricePerGBHour:   1.0\ndefaultCPUPricePerHour: 1\nschedule:\n- defaultCPUPricePerHour: 2\n  effectiveFrom: 2025-01-01T01:00:00+01:00\n", wantSame: true},
+		{name: "price", content: strings.Replace(base, "defaultCPUPricePerHour: 2", "defaultCPUPricePerHour: 3", 1)},
+		{name: "currency", content: base + "currency: EUR\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := load(tt.content); (got == version) != tt.wantSame {
+				t.Errorf("version = %s, base %s, want same %v", got, version, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestPricingVersionDuring(t *testing.T) {
+	jan := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	conf := &types.PricingConfig{
+		Prices:   types.Prices{DefaultCPUPricePerHour: 1, DefaultRAMPricePerGBHour: 1},
+		Schedule: []types.PriceEntry{{EffectiveFrom: jan, EffectiveTo: feb, Prices: types.Prices{DefaultCPUPricePerHour: 2}}},
+	}
+	januaryVersion, err := PricingVersionDuring(conf, jan, jan.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whole, _ := PricingVersion(conf); januaryVersion != whole {
+		t.Errorf("version during January = %s, want the version %s since the only entry covers it", januaryVersion, whole)
+	}
+
+	// Prices for March keep the version of January
+	next := *conf
+	next.Schedule = append(slices.Clone(conf.Schedule), types.PriceEntry{EffectiveFrom: feb.AddDate(0, 1, 0), Prices: types.Prices{DefaultCPUPricePerHour: 3}})
+	if got, _ := PricingVersionDuring(&next, jan, jan.Add(time.Hour)); got != januaryVersion {
+		t.Errorf("version during January after adding March = %s, want %s", got, januaryVersion)
+	}
+	// but not of a range reaching into March
+	if got, _ := PricingVersionDuring(&next, feb, feb.AddDate(0, 2, 0)); got == januaryVersion {
+		t.Errorf("version of February to April = %s, want it to differ from January", got)
+	}
+	// Correcting the January entry changes it
+	corrected := *conf
+	corrected.Schedule = []types.PriceEntry{{EffectiveFrom: jan, EffectiveTo: feb, Prices: types.Prices{DefaultCPUPricePerHour: 2.5}}}
+	if got, _ := PricingVersionDuring(&corrected, jan, jan.Add(time.Hour)); got == januaryVersion {
+		t.Errorf("version during January after correcting its entry = %s, want it to change", got)
+	}
+}
@@ -0,0 +1,34 @@
+// internal/config/schema.go
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Loads the cAdvisor label schema from a YAML file.
+func LoadLabelSchemaConfig(filePath string) (*types.LabelSchemaConfig, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading label schema file '%s': %w", filePath, err)
+	}
+
+	var config types.LabelSchemaConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling label schema config '%s': %w", filePath, err)
+	}
+
+	// Validation
+	if _, err := prom.NewLabelSchema(config); err != nil {
+		return nil, fmt.Errorf("%w in label schema config '%s'", err, filePath)
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,93 @@
+// internal/config/watch.go
+
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce groups the burst of events an editor or a ConfigMap update produces into one reload
+const reloadDebounce = 500 * time.Millisecond
+
+// WatchPricingConfig reloads the pricing file on SIGHUP and, with watchFile, when it changes on disk, until ctx
+// is cancelled. Only configs that pass validation and differ from the current version are passed to apply,
+// an invalid file is logged and the current prices stay in effect.
+func WatchPricingConfig(ctx context.Context, filePath string, watchFile bool, currentVersion string, apply func(*types.PricingConfig)) error {
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	var watcher *fsnotify.Watcher
+	if watchFile {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		// Watch the directory, files are often replaced rather than written (editors, ConfigMap symlink swaps)
+		if err := watcher.Add(filepath.Dir(filePath)); err != nil {
+			watcher.Close()
+			return err
+		}
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		if watcher != nil {
+			defer watcher.Close()
+		}
+		defer signal.Stop(hup)
+
+		reload := func(reason string) {
+			conf, err := LoadPricingConfig(filePath)
+			if err != nil {
+				slog.Error("Pricing config reload rejected, keeping current prices", "reason", reason, "version", currentVersion, "error", err)
+				return
+			}
+			if conf.Version == currentVersion {
+				slog.Debug("Pricing config unchanged", "reason", reason, "version", currentVersion)
+				return
+			}
+			slog.Info("Pricing config reloaded", "reason", reason, "previous_version", currentVersion, "version", conf.Version)
+			currentVersion = conf.Version
+			apply(conf)
+		}
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload("SIGHUP")
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+					debounce = time.After(reloadDebounce)
+				}
+			case <-debounce:
+				debounce = nil
+				reload("file changed")
+			case err, ok := <-errs:
+				if !ok {
+					return
+				}
+				slog.Warn("Pricing config watcher error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
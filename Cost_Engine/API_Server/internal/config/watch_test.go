@@ -0,0 +1,53 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+func TestWatchPricingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("defaultCPUPricePerHour: 0.04\ndefaultRAMPricePerGBHour: 0.005\n")
+	initial, err := LoadPricingConfig(path)
+	if err != nil {
+		t.Fatalf("LoadPricingConfig: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	applied := make(chan *types.PricingConfig, 4)
+	if err := WatchPricingConfig(ctx, path, true, initial.Version, func(c *types.PricingConfig) { applied <- c }); err != nil {
+		t.Fatalf("WatchPricingConfig: %v", err)
+	}
+
+	// An invalid file must never be applied
+	write("defaultCPUPricePerHour: -1\ndefaultRAMPricePerGBHour: 0.005\n")
+	select {
+	case c := <-applied:
+		t.Fatalf("invalid config applied: %+v", c)
+	case <-time.After(2 * reloadDebounce):
+	}
+
+	write("defaultCPUPricePerHour: 0.05\ndefaultRAMPricePerGBHour: 0.005\n")
+	select {
+	case c := <-applied:
+		if c.DefaultCPUPricePerHour != 0.05 {
+			t.Errorf("applied CPU price = %v, want 0.05", c.DefaultCPUPricePerHour)
+		}
+		if c.Version == initial.Version {
+			t.Errorf("applied version = %q, initial %q", c.Version, initial.Version)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("valid config was not applied")
+	}
+}
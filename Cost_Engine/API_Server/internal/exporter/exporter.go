@@ -0,0 +1,148 @@
+// internal/exporter/exporter.go
+
+package exporter
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/metrics"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Exporter periodically calculates costs and exposes them as Prometheus metrics.
+// Namespace costs are counters accumulated over contiguous windows, so increase() over any
+// range gives the cost of that range. Pod level values are gauges holding the last refreshed
+// window only, so series of deleted pods do not pile up.
+type Exporter struct {
+	calc     *calculator.CostCalculator
+	interval time.Duration
+	step     time.Duration
+	lastEnd  time.Time
+
+	namespaceCost   *prometheus.CounterVec
+	podCost         *prometheus.GaugeVec
+	podCPUCoreHours *prometheus.GaugeVec
+	podRAMGiBHours  *prometheus.GaugeVec
+	windowStart     prometheus.Gauge
+	windowEnd       prometheus.Gauge
+	refreshSuccess  prometheus.Gauge
+}
+
+// NewExporter creates an exporter refreshing every interval and registers its metrics with reg
+func NewExporter(calc *calculator.CostCalculator, interval, step time.Duration, reg prometheus.Registerer) *Exporter {
+	podLabels := []string{"cluster", "tenant", "namespace", "pod"}
+	e := &Exporter{
+		calc:     calc,
+		interval: interval,
+		step:     step,
+		namespaceCost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Name:      "namespace_cost_total",
+			Help:      "Accumulated cost per namespace and resource since the exporter started, idle cost handled per the idle cost policy.",
+		}, []string{"cluster", "tenant", "namespace", "resource"}),
+		podCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "pod_cost",
+			Help:      "Cost of each pod over the last refreshed window.",
+		}, podLabels),
+		podCPUCoreHours: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "pod_cpu_core_hours",
+			Help:      "Billed CPU core-hours of each pod over the last refreshed window.",
+		}, podLabels),
+		podRAMGiBHours: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "pod_ram_gib_hours",
+			Help:      "Billed RAM GiB-hours of each pod over the last refreshed window.",
+		}, podLabels),
+		windowStart: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "cost_window_start_timestamp_seconds",
+			Help:      "Start of the last refreshed cost window.",
+		}),
+		windowEnd: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "cost_window_end_timestamp_seconds",
+			Help:      "End of the last refreshed cost window.",
+		}),
+		refreshSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Name:      "cost_refresh_success",
+			Help:      "Whether the last cost refresh succeeded (1) or failed (0).",
+		}),
+	}
+	reg.MustRegister(e.namespaceCost, e.podCost, e.podCPUCoreHours, e.podRAMGiBHours, e.windowStart, e.windowEnd, e.refreshSuccess)
+	return e
+}
+
+// Run refreshes the cost metrics until ctx is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.refresh(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("Cost metrics exporter stopped")
+			return
+		}
+	}
+}
+
+// refresh calculates the costs since the previous refresh. The newest step is left out as
+// its samples may not be scraped yet. A failed refresh is retried by the next one.
+func (e *Exporter) refresh(ctx context.Context, now time.Time) {
+	end := now.Truncate(e.step).Add(-e.step)
+	start := e.lastEnd
+	if start.IsZero() {
+		start = end.Add(-e.interval).Truncate(e.step)
+	}
+	if end.Sub(start) < e.step {
+		return
+	}
+
+	podCosts, pricing, err := e.calc.CalculatePodCosts(ctx, start, end, e.step, calculator.CalcOptions{})
+	if err != nil {
+		slog.Error("Error refreshing cost metrics", "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339), "error", err)
+		e.refreshSuccess.Set(0)
+		return
+	}
+
+	byCluster := make(map[string][]types.PodCost)
+	for _, pc := range podCosts {
+		byCluster[pc.Cluster] = append(byCluster[pc.Cluster], pc)
+	}
+	for cluster, clusterCosts := range byCluster {
+		for tenant, tc := range calculator.AggregateTenantCosts(clusterCosts, pricing.IdleCostPolicy, pricing.Currency) {
+			for _, ns := range tc.Namespaces {
+				e.namespaceCost.WithLabelValues(cluster, tenant, ns.Name, "cpu").Add(ns.Resources.CPU.Cost)
+				e.namespaceCost.WithLabelValues(cluster, tenant, ns.Name, "ram").Add(ns.Resources.RAM.Cost)
+			}
+		}
+	}
+
+	e.podCost.Reset()
+	e.podCPUCoreHours.Reset()
+	e.podRAMGiBHours.Reset()
+	for _, pc := range podCosts {
+		if pc.Idle {
+			continue
+		}
+		e.podCost.WithLabelValues(pc.Cluster, pc.Tenant, pc.Namespace, pc.Pod).Set(pc.TotalCost)
+		e.podCPUCoreHours.WithLabelValues(pc.Cluster, pc.Tenant, pc.Namespace, pc.Pod).Set(pc.CPUBilledCoreHours)
+		e.podRAMGiBHours.WithLabelValues(pc.Cluster, pc.Tenant, pc.Namespace, pc.Pod).Set(pc.RAMBilledGiBHours)
+	}
+
+	e.windowStart.Set(float64(start.Unix()))
+	e.windowEnd.Set(float64(end.Unix()))
+	e.refreshSuccess.Set(1)
+	e.lastEnd = end
+	slog.Debug("Cost metrics refreshed", "pods", len(podCosts), "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339))
+}
@@ -0,0 +1,99 @@
+package exporter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/metrics"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRefresh(t *testing.T) {
+	// The replay of the calculator tests: web of ns1-user1 costs 0.1 per step over 10 minutes, batch of
+	// ns1-user2 0.15 per step over the first 5
+	replay, err := source.NewReplay("../calculator/testdata/replay", prom.StandaloneCAdvisor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricing := &types.PricingConfig{
+		Prices:         types.Prices{DefaultCPUPricePerHour: 6, DefaultRAMPricePerGBHour: 3},
+		IdleCostPolicy: types.IdleCostNone,
+		BillingMode:    types.BillingModeUsage,
+	}
+	reg := prometheus.NewRegistry()
+	e := NewExporter(calculator.NewCostCalculator(replay, pricing, grouper), 5*time.Minute, time.Minute, reg)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// The newest step is left out: refreshing at 00:06 covers 00:00 to 00:05
+		now           time.Time
+		wantNamespace map[string]float64
+		wantPods      map[string]float64
+	}{
+		{name: "first window", now: start.Add(6 * time.Minute), wantNamespace: map[string]float64{"ns1-user1": 0.5, "ns1-user2": 0.75}, wantPods: map[string]float64{"web": 0.5, "batch": 0.75}},
+		// Counters add the next window only, batch has no cost left in it and its gauge is gone
+		{name: "contiguous window", now: start.Add(11 * time.Minute), wantNamespace: map[string]float64{"ns1-user1": 1, "ns1-user2": 0.75}, wantPods: map[string]float64{"web": 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e.refresh(context.Background(), tt.now)
+
+			namespaces := gather(t, reg, metrics.Namespace+"_namespace_cost_total", "namespace")
+			if len(namespaces) != len(tt.wantNamespace) {
+				t.Errorf("namespace counters = %v, want %v", namespaces, tt.wantNamespace)
+			}
+			for ns, want := range tt.wantNamespace {
+				if got := namespaces[ns]; math.Abs(got-want) > 1e-9 {
+					t.Errorf("%s counter = %v, want %v", ns, got, want)
+				}
+			}
+			pods := gather(t, reg, metrics.Namespace+"_pod_cost", "pod")
+			if len(pods) != len(tt.wantPods) {
+				t.Errorf("pod gauges = %v, want %v", pods, tt.wantPods)
+			}
+			for pod, want := range tt.wantPods {
+				if got := pods[pod]; math.Abs(got-want) > 1e-9 {
+					t.Errorf("%s gauge = %v, want %v", pod, got, want)
+				}
+			}
+		})
+	}
+}
+
+// gather sums the values of a metric by one of its labels
+func gather(t *testing.T, reg *prometheus.Registry, name, label string) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var key string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					key = l.GetValue()
+				}
+			}
+			values[key] += m.GetCounter().GetValue() + m.GetGauge().GetValue()
+		}
+	}
+	return values
+}
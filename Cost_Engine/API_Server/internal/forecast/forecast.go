@@ -0,0 +1,89 @@
+// internal/forecast/forecast.go
+
+package forecast
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/types"
+)
+
+// Confidence of the forecast bounds
+const Confidence = 0.95
+
+// Options define how a forecast is computed
+type Options struct {
+	// Model is linear, dayofweek or auto
+	Model string
+	// Step of the per-step cost history the models are fitted to
+	Step time.Duration
+	// Lookback is how much history the models are fitted to
+	Lookback time.Duration
+}
+
+// Forecast projects the spend of every tenant to the end of the current UTC month, from the
+// per-step cost time series of the calculator. Costs are list costs, before tiered pricing.
+func Forecast(ctx context.Context, calc *calculator.CostCalculator, now time.Time, opts Options) (*types.CostForecast, error) {
+	end := now.Truncate(opts.Step)
+	periodStart := types.TierPeriodMonth.Start(end)
+	period := types.Window{Start: periodStart, End: periodStart.AddDate(0, 1, 0)}
+	historyStart := end.Add(-opts.Lookback).Truncate(opts.Step)
+
+	start := historyStart
+	if periodStart.Before(start) {
+		start = periodStart.Truncate(opts.Step)
+	}
+
+	pricing := calc.Pricing()
+	result := &types.CostForecast{
+		Period:      period,
+		GeneratedAt: now,
+		Model:       opts.Model,
+		Lookback:    opts.Lookback.String(),
+		Step:        opts.Step.String(),
+		Confidence:  Confidence,
+		Currency:    pricing.Currency,
+		Tenants:     make(map[string]*types.TenantForecast),
+	}
+	if end.Sub(start) < opts.Step {
+		return result, nil
+	}
+
+	series, err := calc.CalculateCostTimeSeries(ctx, start, end, opts.Step, calculator.CalcOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate cost history: %w", err)
+	}
+	result.Tenants = forecastTenants(series.Tenants, opts.Model, period, historyStart, end, opts.Step)
+	return result, nil
+}
+
+// forecastTenants adds the spend of each tenant since the period start to the projection of the
+// remaining steps, fitted to the points after historyStart
+func forecastTenants(series []types.CostSeries, model string, period types.Window, historyStart, end time.Time, step time.Duration) map[string]*types.TenantForecast {
+	tenants := make(map[string]*types.TenantForecast, len(series))
+	for _, s := range series {
+		var spent float64
+		var history []types.CostPoint
+		for _, p := range s.Points {
+			if p.Timestamp.After(period.Start) && !p.Timestamp.After(end) {
+				spent += p.TotalCost
+			}
+			if p.Timestamp.After(historyStart) {
+				history = append(history, p)
+			}
+		}
+		p := project(model, history, end, period.End, step)
+		tenants[s.Tenant] = &types.TenantForecast{
+			Model:       p.model,
+			SpentToDate: spent,
+			Remaining:   p.expected,
+			Expected:    spent + p.expected,
+			Lower:       spent + p.low,
+			Upper:       spent + p.high,
+		}
+	}
+	return tenants
+}
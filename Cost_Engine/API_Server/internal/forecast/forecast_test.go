@@ -0,0 +1,178 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// hourlyPoints returns one point per hour ending in (from, to], costed by cost
+func hourlyPoints(from, to time.Time, cost func(time.Time) float64) []types.CostPoint {
+	var points []types.CostPoint
+	for t := from.Add(time.Hour); !t.After(to); t = t.Add(time.Hour) {
+		c := cost(t)
+		points = append(points, types.CostPoint{Timestamp: t, TotalCost: c})
+	}
+	return points
+}
+
+func TestProject(t *testing.T) {
+	// Monday 2025-06-02 00:00 UTC
+	monday := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	weekdaysOnly := func(t time.Time) float64 {
+		if wd := t.Add(-time.Nanosecond).Weekday(); wd == time.Saturday || wd == time.Sunday {
+			return 0
+		}
+		return 2
+	}
+
+	tests := []struct {
+		name      string
+		model     string
+		history   []types.CostPoint
+		after     time.Time
+		periodEnd time.Time
+		wantModel string
+		want      float64
+		wantExact bool
+	}{
+		{
+			name:      "flat cost extrapolates linearly",
+			model:     ModelLinear,
+			history:   hourlyPoints(monday, monday.Add(48*time.Hour), func(time.Time) float64 { return 1.5 }),
+			after:     monday.Add(48 * time.Hour),
+			periodEnd: monday.Add(58 * time.Hour),
+			wantModel: ModelLinear,
+			want:      15,
+			wantExact: true,
+		},
+		{
+			name:  "growing cost follows the trend",
+			model: ModelLinear,
+			history: hourlyPoints(monday, monday.Add(10*time.Hour), func(t time.Time) float64 {
+				return t.Sub(monday).Hours()
+			}),
+			after:     monday.Add(10 * time.Hour),
+			periodEnd: monday.Add(12 * time.Hour),
+			wantModel: ModelLinear,
+			want:      11 + 12,
+			wantExact: true,
+		},
+		{
+			name:  "falling trend is clamped at zero",
+			model: ModelLinear,
+			history: hourlyPoints(monday, monday.Add(10*time.Hour), func(t time.Time) float64 {
+				return 10 - t.Sub(monday).Hours()
+			}),
+			after:     monday.Add(10 * time.Hour),
+			periodEnd: monday.Add(20 * time.Hour),
+			wantModel: ModelLinear,
+			want:      0,
+			wantExact: true,
+		},
+		{
+			name:      "day of week predicts a free weekend",
+			model:     ModelDayOfWeek,
+			history:   hourlyPoints(monday, monday.Add(14*24*time.Hour), weekdaysOnly),
+			after:     monday.Add(19 * 24 * time.Hour),
+			periodEnd: monday.Add(21 * 24 * time.Hour),
+			wantModel: ModelDayOfWeek,
+			want:      0,
+			wantExact: true,
+		},
+		{
+			name:      "day of week predicts weekdays",
+			model:     ModelDayOfWeek,
+			history:   hourlyPoints(monday, monday.Add(14*24*time.Hour), weekdaysOnly),
+			after:     monday.Add(14 * 24 * time.Hour),
+			periodEnd: monday.Add(15 * 24 * time.Hour),
+			wantModel: ModelDayOfWeek,
+			want:      48,
+			wantExact: true,
+		},
+		{
+			name:      "auto uses day of week with two weeks of history",
+			model:     ModelAuto,
+			history:   hourlyPoints(monday, monday.Add(14*24*time.Hour), weekdaysOnly),
+			after:     monday.Add(19 * 24 * time.Hour),
+			periodEnd: monday.Add(21 * 24 * time.Hour),
+			wantModel: ModelDayOfWeek,
+			want:      0,
+			wantExact: true,
+		},
+		{
+			name:      "auto uses linear with less history",
+			model:     ModelAuto,
+			history:   hourlyPoints(monday, monday.Add(24*time.Hour), func(time.Time) float64 { return 1 }),
+			after:     monday.Add(24 * time.Hour),
+			periodEnd: monday.Add(34 * time.Hour),
+			wantModel: ModelLinear,
+			want:      10,
+			wantExact: true,
+		},
+		{
+			name:      "no history projects nothing",
+			model:     ModelLinear,
+			after:     monday,
+			periodEnd: monday.Add(24 * time.Hour),
+			wantModel: ModelLinear,
+			want:      0,
+			wantExact: true,
+		},
+		{
+			name:  "noisy cost has bounds around the expectation",
+			model: ModelLinear,
+			history: hourlyPoints(monday, monday.Add(48*time.Hour), func(t time.Time) float64 {
+				return 1 + float64(t.Hour()%2)
+			}),
+			after:     monday.Add(48 * time.Hour),
+			periodEnd: monday.Add(58 * time.Hour),
+			wantModel: ModelLinear,
+			want:      15,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := project(tt.model, tt.history, tt.after, tt.periodEnd, time.Hour)
+			if p.model != tt.wantModel {
+				t.Errorf("model = %s, want %s", p.model, tt.wantModel)
+			}
+			if math.Abs(p.expected-tt.want) > 0.5 {
+				t.Errorf("expected = %v, want %v", p.expected, tt.want)
+			}
+			if p.low > p.expected || p.high < p.expected || p.low < 0 {
+				t.Errorf("bounds [%v, %v] do not surround %v", p.low, p.high, p.expected)
+			}
+			if exact := math.Abs(p.high-p.low) < 1e-9; exact != tt.wantExact {
+				t.Errorf("bounds [%v, %v], want exact %v", p.low, p.high, tt.wantExact)
+			}
+		})
+	}
+}
+
+func TestForecastTenants(t *testing.T) {
+	periodStart := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	period := types.Window{Start: periodStart, End: periodStart.AddDate(0, 1, 0)}
+	end := periodStart.Add(48 * time.Hour)
+	series := []types.CostSeries{{
+		Tenant: "team-a",
+		// Two days of last month and two days of this one at 1 per hour
+		Points: hourlyPoints(periodStart.Add(-48*time.Hour), end, func(time.Time) float64 { return 1 }),
+	}}
+
+	tenants := forecastTenants(series, ModelLinear, period, periodStart.Add(-48*time.Hour), end, time.Hour)
+	got := tenants["team-a"]
+	if got == nil {
+		t.Fatal("missing tenant forecast")
+	}
+	if got.SpentToDate != 48 {
+		t.Errorf("SpentToDate = %v, want 48", got.SpentToDate)
+	}
+	// 28 days left in June
+	if math.Abs(got.Remaining-28*24) > 1e-6 || math.Abs(got.Expected-30*24) > 1e-6 {
+		t.Errorf("Remaining = %v, Expected = %v, want %v and %v", got.Remaining, got.Expected, 28*24, 30*24)
+	}
+}
@@ -0,0 +1,162 @@
+// internal/forecast/model.go
+
+package forecast
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// Forecast models
+const (
+	// ModelLinear fits a least-squares trend to the per-step cost
+	ModelLinear = "linear"
+	// ModelDayOfWeek averages the per-step cost of each hour of the week
+	ModelDayOfWeek = "dayofweek"
+	// ModelAuto uses dayofweek once two weeks of history are available, linear before
+	ModelAuto = "auto"
+)
+
+// z95 is the normal quantile of a 95% two-sided interval
+const z95 = 1.96
+
+// ParseModel checks a model name, empty means auto
+func ParseModel(s string) (string, error) {
+	switch s {
+	case "", ModelAuto:
+		return ModelAuto, nil
+	case ModelLinear, ModelDayOfWeek:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid model '%s' (auto, linear, dayofweek)", s)
+}
+
+// projection is the cost expected over the future steps, with its 95% bounds
+type projection struct {
+	model               string
+	expected, low, high float64
+}
+
+// project forecasts the cost of every step from after up to periodEnd from the history points
+func project(model string, history []types.CostPoint, after, periodEnd time.Time, step time.Duration) projection {
+	var future []time.Time
+	for t := after.Add(step); !t.After(periodEnd); t = t.Add(step) {
+		future = append(future, t)
+	}
+	if model == ModelAuto {
+		model = ModelLinear
+		if len(history) > 0 && history[len(history)-1].Timestamp.Sub(history[0].Timestamp)+step >= 14*24*time.Hour {
+			model = ModelDayOfWeek
+		}
+	}
+	if len(history) == 0 || len(future) == 0 {
+		return projection{model: model}
+	}
+
+	var p projection
+	if model == ModelDayOfWeek {
+		p = projectDayOfWeek(history, future)
+	} else {
+		p = projectLinear(history, future)
+	}
+	p.model = model
+	p.low = math.Max(p.low, 0)
+	return p
+}
+
+// projectLinear extrapolates the least-squares line through the history, assuming independent residuals
+func projectLinear(history []types.CostPoint, future []time.Time) projection {
+	origin := history[0].Timestamp
+	x := func(t time.Time) float64 { return t.Sub(origin).Hours() }
+
+	n := float64(len(history))
+	var sumX, sumY, sumXX, sumXY float64
+	for _, p := range history {
+		xi := x(p.Timestamp)
+		sumX += xi
+		sumY += p.TotalCost
+		sumXX += xi * xi
+		sumXY += xi * p.TotalCost
+	}
+	slope, intercept := 0.0, sumY/n
+	if denom := n*sumXX - sumX*sumX; len(history) > 2 && denom != 0 {
+		slope = (n*sumXY - sumX*sumY) / denom
+		intercept = (sumY - slope*sumX) / n
+	}
+
+	var ssr float64
+	for _, p := range history {
+		r := p.TotalCost - (intercept + slope*x(p.Timestamp))
+		ssr += r * r
+	}
+	variance := 0.0
+	if len(history) > 2 {
+		variance = ssr / (n - 2)
+	}
+
+	var expected float64
+	for _, t := range future {
+		// A falling trend never makes a step cost negative
+		expected += math.Max(intercept+slope*x(t), 0)
+	}
+	margin := z95 * math.Sqrt(variance*float64(len(future)))
+	return projection{expected: expected, low: expected - margin, high: expected + margin}
+}
+
+// projectDayOfWeek sums the mean cost of the hour of the week of every future step,
+// with bounds from the per-hour variance
+func projectDayOfWeek(history []types.CostPoint, future []time.Time) projection {
+	type slotStats struct{ n, sum, sumSq float64 }
+	slots := make(map[int]*slotStats)
+	var total, totalSq float64
+	for _, p := range history {
+		key := hourOfWeek(p.Timestamp)
+		s, ok := slots[key]
+		if !ok {
+			s = &slotStats{}
+			slots[key] = s
+		}
+		s.n++
+		s.sum += p.TotalCost
+		s.sumSq += p.TotalCost * p.TotalCost
+		total += p.TotalCost
+		totalSq += p.TotalCost * p.TotalCost
+	}
+	n := float64(len(history))
+	overallMean := total / n
+	overallVariance := sampleVariance(n, total, totalSq)
+
+	var expected, variance float64
+	for _, t := range future {
+		s, ok := slots[hourOfWeek(t)]
+		if !ok {
+			expected += overallMean
+			variance += overallVariance
+			continue
+		}
+		expected += s.sum / s.n
+		if s.n > 1 {
+			variance += sampleVariance(s.n, s.sum, s.sumSq)
+		} else {
+			variance += overallVariance
+		}
+	}
+	margin := z95 * math.Sqrt(variance)
+	return projection{expected: expected, low: expected - margin, high: expected + margin}
+}
+
+// hourOfWeek identifies the hour of the week (UTC) of the step ending at t
+func hourOfWeek(t time.Time) int {
+	t = t.UTC().Add(-time.Nanosecond)
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+func sampleVariance(n, sum, sumSq float64) float64 {
+	if n < 2 {
+		return 0
+	}
+	return math.Max((sumSq-sum*sum/n)/(n-1), 0)
+}
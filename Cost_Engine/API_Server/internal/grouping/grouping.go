@@ -0,0 +1,125 @@
+// internal/grouping/grouping.go
+
+package grouping
+
+import (
+	"fmt"
+	"regexp"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+)
+
+// BuiltinConfig reproduces the historical grouping: namespaces ns<anything>-user<digits> belong
+// to tenant user<digits>, everything else to "system"
+var BuiltinConfig = types.GroupingConfig{
+	Version:       "builtin",
+	DefaultTenant: "system",
+	Rules: []types.GroupingRule{
+		{NamespaceRegex: `^(?:ns.+)-(user\d+)$`, Tenant: "$1"},
+	},
+}
+
+// Grouper resolves the tenant group of a namespace from an ordered rule set
+type Grouper struct {
+	version       string
+	defaultTenant string
+	rules         []rule
+}
+
+type rule struct {
+	regex      *regexp.Regexp
+	tenant     string
+	namespaces map[string]string
+	label      string
+	annotation string
+}
+
+// NewGrouper compiles and validates a grouping configuration
+func NewGrouper(conf *types.GroupingConfig) (*Grouper, error) {
+	g := &Grouper{version: conf.Version, defaultTenant: conf.DefaultTenant}
+	if g.defaultTenant == "" {
+		g.defaultTenant = "system"
+	}
+
+	for i, r := range conf.Rules {
+		matchers := 0
+		compiled := rule{
+			tenant:     r.Tenant,
+			namespaces: r.Namespaces,
+		}
+		if r.NamespaceLabel != "" {
+			compiled.label = prom.KSMLabelName(r.NamespaceLabel)
+		}
+		if r.NamespaceAnnotation != "" {
+			compiled.annotation = prom.KSMAnnotationName(r.NamespaceAnnotation)
+		}
+		if r.NamespaceRegex != "" {
+			// Anchored so the regex must match the whole namespace name
+			re, err := regexp.Compile("^(?:" + r.NamespaceRegex + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("grouping rule %d: invalid namespaceRegex: %w", i, err)
+			}
+			if r.Tenant == "" {
+				return nil, fmt.Errorf("grouping rule %d: namespaceRegex requires a tenant template", i)
+			}
+			compiled.regex = re
+			matchers++
+		}
+		if len(r.Namespaces) > 0 {
+			matchers++
+		}
+		if r.NamespaceLabel != "" {
+			matchers++
+		}
+		if r.NamespaceAnnotation != "" {
+			matchers++
+		}
+		if matchers != 1 {
+			return nil, fmt.Errorf("grouping rule %d: exactly one of namespaceRegex, namespaces, namespaceLabel, namespaceAnnotation must be set", i)
+		}
+		g.rules = append(g.rules, compiled)
+	}
+	return g, nil
+}
+
+// Version returns the version of the rule set
+func (g *Grouper) Version() string {
+	return g.version
+}
+
+// NeedsNamespaceMetadata reports whether any rule reads namespace labels or annotations
+func (g *Grouper) NeedsNamespaceMetadata() (labels bool, annotations bool) {
+	for _, r := range g.rules {
+		labels = labels || r.label != ""
+		annotations = annotations || r.annotation != ""
+	}
+	return labels, annotations
+}
+
+// Tenant returns the tenant group of a namespace, the first matching rule wins
+func (g *Grouper) Tenant(namespace string, meta types.NamespaceMetadata) string {
+	for _, r := range g.rules {
+		switch {
+		case r.regex != nil:
+			if match := r.regex.FindStringSubmatchIndex(namespace); match != nil {
+				if tenant := string(r.regex.ExpandString(nil, r.tenant, namespace, match)); tenant != "" {
+					return tenant
+				}
+			}
+		case r.namespaces != nil:
+			if tenant, ok := r.namespaces[namespace]; ok && tenant != "" {
+				return tenant
+			}
+		case r.label != "":
+			if tenant := meta.Labels[r.label]; tenant != "" {
+				return tenant
+			}
+		case r.annotation != "":
+			if tenant := meta.Annotations[r.annotation]; tenant != "" {
+				return tenant
+			}
+		}
+	}
+	return g.defaultTenant
+}
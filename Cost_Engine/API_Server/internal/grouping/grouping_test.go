@@ -0,0 +1,80 @@
+package grouping
+
+import (
+	"testing"
+
+	"simple-cost-calculator/internal/types"
+)
+
+func TestGrouperTenant(t *testing.T) {
+	conf := &types.GroupingConfig{
+		Version:       "test",
+		DefaultTenant: "system",
+		Rules: []types.GroupingRule{
+			{NamespaceLabel: "billing.tenant"},
+			{Namespaces: map[string]string{"shared-db": "acme"}},
+			{NamespaceRegex: `^(?:ns.+)-(user\d+)$`, Tenant: "$1"},
+			{NamespaceRegex: `team-(?P<team>[a-z]+)-.*`, Tenant: "team-${team}"},
+			{NamespaceAnnotation: "billing.example.com/tenant"},
+		},
+	}
+	g, err := NewGrouper(conf)
+	if err != nil {
+		t.Fatalf("NewGrouper() error = %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		namespace string
+		meta      types.NamespaceMetadata
+		want      string
+	}{
+		{name: "Regex capture", namespace: "ns1-user2", want: "user2"},
+		{name: "Named capture template", namespace: "team-web-prod", want: "team-web"},
+		{name: "Exact namespace map", namespace: "shared-db", want: "acme"},
+		{
+			name:      "Label rule wins over later rules",
+			namespace: "ns1-user2",
+			meta:      types.NamespaceMetadata{Labels: map[string]string{"label_billing_tenant": "acme"}},
+			want:      "acme",
+		},
+		{
+			name:      "Annotation rule",
+			namespace: "payments",
+			meta:      types.NamespaceMetadata{Annotations: map[string]string{"annotation_billing_example_com_tenant": "globex"}},
+			want:      "globex",
+		},
+		{name: "No match falls back to default", namespace: "kube-system", want: "system"},
+		// The unanchored team rule only matches a substring of the name
+		{name: "Regex must match the whole name", namespace: "old-team-web-prod", want: "system"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := g.Tenant(tc.namespace, tc.meta); got != tc.want {
+				t.Errorf("Tenant(%q) = %q, want %q", tc.namespace, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewGrouperInvalidRules(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule types.GroupingRule
+	}{
+		{name: "No matcher", rule: types.GroupingRule{Tenant: "acme"}},
+		{name: "Two matchers", rule: types.GroupingRule{NamespaceLabel: "a", NamespaceAnnotation: "b"}},
+		{name: "Regex without tenant", rule: types.GroupingRule{NamespaceRegex: "^ns$"}},
+		{name: "Invalid regex", rule: types.GroupingRule{NamespaceRegex: "(", Tenant: "$1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := &types.GroupingConfig{Version: "test", Rules: []types.GroupingRule{tc.rule}}
+			if _, err := NewGrouper(conf); err == nil {
+				t.Errorf("NewGrouper() expected error for rule %+v", tc.rule)
+			}
+		})
+	}
+}
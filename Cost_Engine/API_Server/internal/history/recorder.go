@@ -0,0 +1,300 @@
+// internal/history/recorder.go
+
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/metrics"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+)
+
+// settleDelay leaves time for the last samples of an interval to be scraped before it is recorded
+const settleDelay = 2 * time.Minute
+
+// maxRecordAttempts is how many runs an interval is retried before the recorder skips it
+const maxRecordAttempts = 5
+
+// Recorder computes the costs of every closed interval in the background and answers
+// pod cost queries from the store, hitting Prometheus only for what is not stored
+type Recorder struct {
+	store    *Store
+	calc     *calculator.CostCalculator
+	interval time.Duration
+	step     time.Duration
+	backfill time.Duration
+
+	// failing counts the failed attempts at the next interval, skippedUntil is the end of the last
+	// skipped one. Both are only used by Run.
+	failing      int
+	skippedUntil time.Time
+}
+
+// NewRecorder creates a recorder storing intervals of the given length computed with step.
+// On an empty store, recording starts backfill before now.
+func NewRecorder(store *Store, calc *calculator.CostCalculator, interval, step, backfill time.Duration) (*Recorder, error) {
+	if interval < step || interval%step != 0 {
+		return nil, fmt.Errorf("history interval %s must be a multiple of step %s", interval, step)
+	}
+	return &Recorder{store: store, calc: calc, interval: interval, step: step, backfill: backfill}, nil
+}
+
+// Run records closed intervals until ctx is cancelled
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		r.recordClosedIntervals(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			slog.Info("History recorder stopped")
+			return
+		}
+	}
+}
+
+// recordClosedIntervals computes and stores every interval closed since the last stored one
+func (r *Recorder) recordClosedIntervals(ctx context.Context, now time.Time) {
+	next := now.Add(-r.backfill).Truncate(r.interval)
+	latest, ok, err := r.store.Latest()
+	if err != nil {
+		slog.Error("Error reading latest stored interval", "error", err)
+		return
+	}
+	if ok {
+		next = latest.Add(r.interval)
+	}
+	if r.skippedUntil.After(next) {
+		next = r.skippedUntil
+	}
+
+	recorded := 0
+	for !next.Add(r.interval + settleDelay).After(now) {
+		start, end := next, next.Add(r.interval)
+		if err := r.record(ctx, start, end, now); err != nil {
+			// Retried on the next runs, intervals are recorded in order until one keeps failing
+			r.failing++
+			if r.failing < maxRecordAttempts {
+				slog.Error("Error recording interval", "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339), "attempt", r.failing, "error", err)
+				return
+			}
+			slog.Error("Skipping interval that keeps failing, it is calculated from Prometheus when queried",
+				"start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339), "attempts", r.failing, "error", err)
+			metrics.HistorySkippedIntervals.Inc()
+			r.skippedUntil = end
+		} else {
+			recorded++
+		}
+		r.failing = 0
+		next = end
+	}
+	if recorded > 0 {
+		slog.Info("Recorded closed intervals", "count", recorded, "recorded_until", next.Format(time.RFC3339))
+	}
+}
+
+// record calculates and stores one interval. Partial results are not stored.
+func (r *Recorder) record(ctx context.Context, start, end, now time.Time) error {
+	pricing := r.calc.Pricing()
+	version := r.calc.PricingVersion(pricing)
+	podCosts, _, err := r.calc.CalculatePodCosts(ctx, start, end, r.step, calculator.CalcOptions{Pricing: pricing})
+	if err != nil {
+		return err
+	}
+	if r.calc.PricingVersion(pricing) != version {
+		return fmt.Errorf("cluster pricing was reloaded during the calculation")
+	}
+	if err := r.store.Put(StoredInterval{
+		Window:          types.Window{Start: start, End: end},
+		Step:            r.step.String(),
+		GroupingVersion: r.calc.Grouper().Version(),
+		PricingVersion:  r.calc.PricingVersionDuring(pricing, start, end),
+		Accounting:      r.calc.Accounting(),
+		ComputedAt:      now,
+		Pods:            podCosts,
+	}); err != nil {
+		return fmt.Errorf("error storing interval: %w", err)
+	}
+	return nil
+}
+
+// superseded reports whether the prices in effect over a stored interval changed since it was recorded, which
+// a correction of its schedule entry does but adding entries for other periods does not
+func (r *Recorder) superseded(iv *StoredInterval, pricing *types.PricingConfig) bool {
+	return iv.PricingVersion != r.calc.PricingVersionDuring(pricing, iv.Window.Start, iv.Window.End)
+}
+
+// Stored reports whether a query priced with a snapshot can be answered from the store: it must use the
+// recorded step and the configured billing mode and usage accounting at pod level
+func (r *Recorder) Stored(step time.Duration, opts calculator.CalcOptions, pricing *types.PricingConfig) bool {
+	return step == r.step && !opts.ByContainer &&
+		(opts.BillingMode == "" || opts.BillingMode == pricing.BillingMode) &&
+		(opts.Accounting == "" || opts.Accounting == r.calc.Accounting())
+}
+
+// PodCosts returns pod costs for the range, using stored intervals where available and
+// Prometheus for the remaining head, gaps and still-open tail, with the pricing snapshot of every part.
+// Stored intervals are authoritative: they keep the costs they were recorded with after a configuration
+// change, since Prometheus may no longer hold their samples.
+func (r *Recorder) PodCosts(ctx context.Context, start, end time.Time, step time.Duration, opts calculator.CalcOptions) ([]types.PodCost, *types.PricingConfig, error) {
+	if opts.Pricing == nil {
+		opts.Pricing = r.calc.Pricing()
+	}
+	if opts.Pricing == nil || !r.Stored(step, opts, opts.Pricing) {
+		return r.calc.CalculatePodCosts(ctx, start, end, step, opts)
+	}
+
+	var parts [][]types.PodCost
+	var missing []types.Window
+	addMissing := func(from, to time.Time) {
+		if !to.After(from) {
+			return
+		}
+		if n := len(missing); n > 0 && missing[n-1].End.Equal(from) {
+			missing[n-1].End = to
+			return
+		}
+		missing = append(missing, types.Window{Start: from, End: to})
+	}
+
+	cursor := start
+	storedCount, supersededCount := 0, 0
+	for t := ceilTime(start, r.interval); !t.Add(r.interval).After(end); t = t.Add(r.interval) {
+		iv, err := r.store.Get(t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if iv == nil {
+			continue
+		}
+		if r.superseded(iv, opts.Pricing) {
+			supersededCount++
+		}
+		addMissing(cursor, t)
+		parts = append(parts, iv.Pods)
+		cursor = t.Add(r.interval)
+		storedCount++
+	}
+	addMissing(cursor, end)
+
+	slog.Info("Answering pod costs from history", "stored_intervals", storedCount, "prometheus_ranges", len(missing))
+	if supersededCount > 0 {
+		slog.Warn("Stored intervals keep the prices they were recorded with, which have changed since", "intervals", supersededCount, "pricing_version", r.calc.PricingVersionDuring(opts.Pricing, start, end))
+	}
+	var incomplete []types.Window
+	for _, w := range missing {
+		podCosts, _, err := r.calc.CalculatePodCosts(ctx, w.Start, w.End, step, opts)
+		var partial *calculator.PartialResultError
+		if errors.As(err, &partial) {
+			incomplete = append(incomplete, partial.Missing...)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("error calculating range %s - %s: %w", w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339), err)
+		}
+		parts = append(parts, podCosts)
+	}
+
+	merged := mergePodCosts(types.Window{Start: start, End: end}, parts...)
+	if len(incomplete) > 0 {
+		return merged, opts.Pricing, &calculator.PartialResultError{Missing: incomplete}
+	}
+	return merged, opts.Pricing, nil
+}
+
+// mergePodCosts sums the rows of the same pod (or container, or idle node) across consecutive ranges
+func mergePodCosts(window types.Window, parts ...[]types.PodCost) []types.PodCost {
+	type rowKey struct {
+		cluster, tenant, key string
+		idle                 bool
+	}
+	merged := make(map[rowKey]*types.PodCost)
+	nodes := make(map[rowKey]map[string]bool)
+	pricingVersions := make(map[rowKey]map[string]bool)
+	capacityTypes := make(map[rowKey]map[string]bool)
+	var order []rowKey
+
+	for _, part := range parts {
+		for _, pc := range part {
+			k := rowKey{cluster: pc.Cluster, tenant: pc.Tenant, key: prom.GetContainerKey(pc.Namespace, pc.Pod, pc.Container), idle: pc.Idle}
+			row, exists := merged[k]
+			if !exists {
+				row = &types.PodCost{
+					Cluster:     pc.Cluster,
+					Tenant:      pc.Tenant,
+					Namespace:   pc.Namespace,
+					Pod:         pc.Pod,
+					Container:   pc.Container,
+					Window:      window,
+					BillingMode: pc.BillingMode,
+					Idle:        pc.Idle,
+				}
+				merged[k] = row
+				nodes[k] = make(map[string]bool)
+				pricingVersions[k] = make(map[string]bool)
+				capacityTypes[k] = make(map[string]bool)
+				order = append(order, k)
+			}
+			row.CPUCost += pc.CPUCost
+			row.CPUCoreHours += pc.CPUCoreHours
+			row.CPURequestCoreHours += pc.CPURequestCoreHours
+			row.CPUBilledCoreHours += pc.CPUBilledCoreHours
+			row.RAMCost += pc.RAMCost
+			row.RAMGiBHours += pc.RAMGiBHours
+			row.RAMRequestGiBHours += pc.RAMRequestGiBHours
+			row.RAMBilledGiBHours += pc.RAMBilledGiBHours
+			row.TotalCost += pc.TotalCost
+			for _, node := range pc.Nodes {
+				nodes[k][node] = true
+			}
+			if pc.PricingVersion != "" {
+				pricingVersions[k][pc.PricingVersion] = true
+			}
+			for _, ct := range strings.Split(pc.CapacityType, ",") {
+				if ct != "" {
+					capacityTypes[k][ct] = true
+				}
+			}
+		}
+	}
+
+	results := make([]types.PodCost, 0, len(order))
+	for _, k := range order {
+		row := merged[k]
+		for node := range nodes[k] {
+			row.Nodes = append(row.Nodes, node)
+		}
+		sort.Strings(row.Nodes)
+		row.PricingVersion = joinSorted(pricingVersions[k])
+		row.CapacityType = joinSorted(capacityTypes[k])
+		results = append(results, *row)
+	}
+	return results
+}
+
+// joinSorted joins the set members in order with commas
+func joinSorted(set map[string]bool) string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+// ceilTime rounds t up to a multiple of d
+func ceilTime(t time.Time, d time.Duration) time.Time {
+	truncated := t.Truncate(d)
+	if truncated.Equal(t) {
+		return t
+	}
+	return truncated.Add(d)
+}
@@ -0,0 +1,215 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
+)
+
+// failingSource answers every query with an error
+type failingSource struct{}
+
+func (failingSource) Usage(context.Context, source.Query) (cpu, ram source.Series, err error) {
+	return cpu, ram, errors.New("prometheus is down")
+}
+
+func (failingSource) Requests(context.Context, source.Query) (cpu, ram source.Series, err error) {
+	return cpu, ram, errors.New("prometheus is down")
+}
+
+func (failingSource) Nodes(context.Context, source.Query) (source.NodeInfo, error) {
+	return source.NodeInfo{}, errors.New("prometheus is down")
+}
+
+func (failingSource) NamespaceMetadata(context.Context, time.Time, bool, bool) (map[string]types.NamespaceMetadata, error) {
+	return nil, errors.New("prometheus is down")
+}
+
+// newTestRecorder returns a recorder of 5m intervals at 1m over a new store
+func newTestRecorder(t *testing.T, src source.MetricsSource, pricing *types.PricingConfig) *Recorder {
+	t.Helper()
+	store, err := OpenStore(filepath.Join(t.TempDir(), "history.db"), 5*time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecorder(store, calculator.NewCostCalculator(src, pricing, grouper), 5*time.Minute, time.Minute, 12*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRecorderSkipsFailingInterval(t *testing.T) {
+	r := newTestRecorder(t, failingSource{}, &types.PricingConfig{})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(12 * time.Minute) // two closed intervals
+
+	for run := 1; run <= maxRecordAttempts; run++ {
+		r.recordClosedIntervals(context.Background(), now)
+		if run < maxRecordAttempts && !r.skippedUntil.IsZero() {
+			t.Fatalf("run %d skipped the first interval, want it retried %d times", run, maxRecordAttempts)
+		}
+	}
+	if want := start.Add(5 * time.Minute); !r.skippedUntil.Equal(want) || r.failing != 1 {
+		t.Errorf("skipped until %v with %d failures, want %v and the second interval failing once", r.skippedUntil, r.failing, want)
+	}
+}
+
+func TestRecorderKeepsStoredIntervals(t *testing.T) {
+	// The replay of the calculator tests: web and batch over 10 minutes, web costs 1 at these prices
+	replay, err := source.NewReplay("../calculator/testdata/replay", prom.StandaloneCAdvisor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricing := types.PricingConfig{
+		Prices:      types.Prices{DefaultCPUPricePerHour: 6, DefaultRAMPricePerGBHour: 3},
+		BillingMode: types.BillingModeUsage,
+		Version:     "v1",
+	}
+	r := newTestRecorder(t, replay, &pricing)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Minute)
+	r.recordClosedIntervals(context.Background(), start.Add(12*time.Minute))
+	if latest, ok, _ := r.store.Latest(); !ok || !latest.Equal(start.Add(5*time.Minute)) {
+		t.Fatalf("latest stored interval = %v, want both intervals stored", latest)
+	}
+
+	webCost := func(r *Recorder) (float64, string) {
+		podCosts, _, err := r.PodCosts(context.Background(), start, end, time.Minute, calculator.CalcOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pc := range podCosts {
+			if pc.Pod == "web" {
+				return pc.TotalCost, pc.PricingVersion
+			}
+		}
+		return 0, ""
+	}
+	recorded := r.calc.PricingVersionDuring(&pricing, start, end)
+	if cost, version := webCost(r); math.Abs(cost-1) > 1e-9 || version != recorded {
+		t.Errorf("stored web cost = %v (%s), want 1 (%s)", cost, version, recorded)
+	}
+	iv, err := r.store.Get(start)
+	if err != nil || iv == nil {
+		t.Fatalf("stored interval = %+v, %v", iv, err)
+	}
+
+	// Prices for the next month leave the recorded ones in effect
+	scheduled := pricing
+	scheduled.Schedule = []types.PriceEntry{{EffectiveFrom: start.AddDate(0, 1, 0), Prices: types.Prices{DefaultCPUPricePerHour: 12}}}
+	if r.superseded(iv, &scheduled) {
+		t.Errorf("interval superseded by a schedule entry for the next month")
+	}
+
+	// After a pricing reload, with Prometheus no longer holding the window, the stored costs still answer
+	doubled := pricing
+	doubled.Prices = types.Prices{DefaultCPUPricePerHour: 12, DefaultRAMPricePerGBHour: 6}
+	doubled.Version = "v2"
+	reloaded, err := NewRecorder(r.store, calculator.NewCostCalculator(failingSource{}, &doubled, r.calc.Grouper()), r.interval, r.step, r.backfill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.superseded(iv, &doubled) {
+		t.Errorf("interval not superseded by new prices")
+	}
+	if cost, version := webCost(reloaded); math.Abs(cost-1) > 1e-9 || version != recorded {
+		t.Errorf("web cost after a pricing reload = %v (%s), want the stored 1 (%s)", cost, version, recorded)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "history.db"), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	defer store.Close()
+
+	if _, ok, err := store.Latest(); err != nil || ok {
+		t.Fatalf("Latest on empty store = ok %v, err %v", ok, err)
+	}
+
+	base := time.Unix(1700000000, 0).Truncate(time.Hour)
+	for i := 0; i < 3; i++ {
+		start := base.Add(time.Duration(i) * time.Hour)
+		err := store.Put(StoredInterval{
+			Window: types.Window{Start: start, End: start.Add(time.Hour)},
+			Pods:   []types.PodCost{{Namespace: "ns1", Pod: "p", TotalCost: float64(i)}},
+		})
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	latest, ok, err := store.Latest()
+	if err != nil || !ok || !latest.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("Latest = %v, %v, %v; want %v", latest, ok, err, base.Add(2*time.Hour))
+	}
+	iv, err := store.Get(base.Add(time.Hour))
+	if err != nil || iv == nil || iv.Pods[0].TotalCost != 1 {
+		t.Errorf("Get = %+v, %v", iv, err)
+	}
+	if iv, err := store.Get(base.Add(5 * time.Hour)); err != nil || iv != nil {
+		t.Errorf("Get missing = %+v, %v; want nil", iv, err)
+	}
+}
+
+func TestMergePodCosts(t *testing.T) {
+	window := types.Window{Start: time.Unix(0, 0), End: time.Unix(7200, 0)}
+	first := []types.PodCost{
+		{Tenant: "user1", Namespace: "ns1-user1", Pod: "a", Nodes: []string{"n1"}, CPUCost: 1, RAMCost: 2, TotalCost: 3},
+		{Tenant: types.IdleGroupKey, Namespace: types.IdleGroupKey, Pod: "n1", Idle: true, TotalCost: 1},
+	}
+	second := []types.PodCost{
+		{Tenant: "user1", Namespace: "ns1-user1", Pod: "a", Nodes: []string{"n2"}, CPUCost: 2, RAMCost: 1, TotalCost: 3},
+		{Tenant: "user2", Namespace: "ns1-user2", Pod: "b", Nodes: []string{"n1"}, TotalCost: 5},
+	}
+
+	merged := mergePodCosts(window, first, second)
+	if len(merged) != 3 {
+		t.Fatalf("got %d rows, want 3", len(merged))
+	}
+	a := merged[0]
+	if a.CPUCost != 3 || a.RAMCost != 3 || a.TotalCost != 6 {
+		t.Errorf("merged costs = cpu %v ram %v total %v; want 3 3 6", a.CPUCost, a.RAMCost, a.TotalCost)
+	}
+	if len(a.Nodes) != 2 || a.Nodes[0] != "n1" || a.Nodes[1] != "n2" {
+		t.Errorf("merged nodes = %v; want [n1 n2]", a.Nodes)
+	}
+	if a.Window != window {
+		t.Errorf("merged window = %+v; want %+v", a.Window, window)
+	}
+	if !merged[1].Idle || merged[1].TotalCost != 1 {
+		t.Errorf("idle row = %+v", merged[1])
+	}
+}
+
+func TestCeilTime(t *testing.T) {
+	tests := []struct {
+		in, want int64
+	}{
+		{0, 0},
+		{1, 3600},
+		{3600, 3600},
+		{3601, 7200},
+	}
+	for _, tt := range tests {
+		if got := ceilTime(time.Unix(tt.in, 0), time.Hour); got.Unix() != tt.want {
+			t.Errorf("ceilTime(%d) = %d; want %d", tt.in, got.Unix(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// internal/history/store.go
+
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoredInterval holds the pod costs computed for one closed interval
+type StoredInterval struct {
+	Window          types.Window `json:"window"`
+	Step            string       `json:"step"`
+	GroupingVersion string       `json:"groupingVersion"`
+	// PricingVersion and Accounting the pods were priced with, kept to trace the stored costs back to
+	// their configuration
+	PricingVersion string                `json:"pricingVersion"`
+	Accounting     types.UsageAccounting `json:"accounting"`
+	ComputedAt     time.Time             `json:"computedAt"`
+	Pods           []types.PodCost       `json:"pods"`
+}
+
+// Store persists computed intervals in an embedded bbolt database.
+// Intervals are keyed by their start time in a bucket per interval length and step,
+// so changing either never mixes incompatible records.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// OpenStore opens (or creates) the history database at path
+func OpenStore(path string, interval, step time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database '%s': %w", path, err)
+	}
+	s := &Store{db: db, bucket: []byte(fmt.Sprintf("intervals/%s/%s", interval, step))}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating history bucket '%s': %w", s.bucket, err)
+	}
+	return s, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put stores (or replaces) an interval
+func (s *Store) Put(iv StoredInterval) error {
+	value, err := json.Marshal(iv)
+	if err != nil {
+		return fmt.Errorf("error encoding interval %s: %w", iv.Window.Start.Format(time.RFC3339), err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put(intervalKey(iv.Window.Start), value)
+	})
+}
+
+// Get returns the interval starting at start, or nil if it was not stored
+func (s *Store) Get(start time.Time) (*StoredInterval, error) {
+	var iv *StoredInterval
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(s.bucket).Get(intervalKey(start))
+		if value == nil {
+			return nil
+		}
+		iv = &StoredInterval{}
+		return json.Unmarshal(value, iv)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading interval %s: %w", start.Format(time.RFC3339), err)
+	}
+	return iv, nil
+}
+
+// Latest returns the start of the most recent stored interval, ok is false when the store is empty
+func (s *Store) Latest() (start time.Time, ok bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		key, _ := tx.Bucket(s.bucket).Cursor().Last()
+		if key != nil {
+			start, ok = time.Unix(int64(binary.BigEndian.Uint64(key)), 0), true
+		}
+		return nil
+	})
+	return start, ok, err
+}
+
+// intervalKey encodes a start time so that keys sort chronologically
+func intervalKey(start time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(start.Unix()))
+	return key
+}
@@ -0,0 +1,67 @@
+// internal/metrics/metrics.go
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Namespace prefixes every metric exported by the API server
+const Namespace = "cost_engine"
+
+// Health metrics of the API server itself, served on /metrics next to the cost metrics
+var (
+	// PrometheusQueryDuration observes the latency of queries sent to Prometheus, by type (range or instant)
+	PrometheusQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "prometheus_query_duration_seconds",
+		Help:      "Latency of queries sent to Prometheus.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"type"})
+
+	// PrometheusQueryErrors counts failed Prometheus queries, by type
+	PrometheusQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "prometheus_query_errors_total",
+		Help:      "Number of Prometheus queries that failed.",
+	}, []string{"type"})
+
+	// CalculationDuration observes how long a full cost calculation takes
+	CalculationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "calculation_duration_seconds",
+		Help:      "Duration of cost calculations, Prometheus queries included.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+
+	// CalculationErrors counts cost calculations that failed
+	CalculationErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "calculation_errors_total",
+		Help:      "Number of cost calculations that failed.",
+	})
+
+	// HTTPRequestDuration observes API request latency, by handler and status code
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of API requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"handler", "code"})
+
+	// HistorySkippedIntervals counts the intervals the history recorder gave up on, they are calculated
+	// from Prometheus when queried
+	HistorySkippedIntervals = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "history_skipped_intervals_total",
+		Help:      "Number of intervals the history recorder failed to record and skipped.",
+	})
+
+	// AuthFailures counts rejected API requests, by reason (missing, invalid, forbidden)
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "auth_failures_total",
+		Help:      "Number of API requests rejected by authentication or authorization.",
+	}, []string{"reason"})
+)
@@ -0,0 +1,142 @@
+// internal/prom/counter.go
+
+package prom
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// CounterLookback is how far before a window raw counter samples are selected, to find the value each
+// series had when the window started. Series without a sample in it only count if they started since.
+const CounterLookback = 5 * time.Minute
+
+// ParseCPUCounterSteps raw container_cpu_usage_seconds_total samples to map[namespace/pod] (or
+// namespace/pod/container) -> timestamp -> coreSeconds used during the step ending at that timestamp,
+// over the window (start, end]. starts is the ContainerStartQuery result, telling when each series began.
+func (s LabelSchema) ParseCPUCounterSteps(result, starts model.Value, start, end time.Time, step time.Duration, byContainer bool) PodStepSeries {
+	return parseCounterSteps(result, seriesStarts(starts), start, end, step, s.Namespace, s.Pod, s.containerLabel(byContainer))
+}
+
+// seriesStarts maps the labels (without metric name) of every series of a ContainerStartQuery result to
+// its earliest start time
+func seriesStarts(result model.Value) map[model.Fingerprint]time.Time {
+	starts := make(map[model.Fingerprint]time.Time)
+	matrix, ok := result.(model.Matrix)
+	if !ok {
+		slog.Warn("seriesStarts expected matrix type", "received", fmt.Sprintf("%T", result))
+		return starts
+	}
+	for _, sampleStream := range matrix {
+		fp := withoutName(sampleStream.Metric).Fingerprint()
+		for _, sample := range sampleStream.Values {
+			started := time.Unix(0, int64(float64(sample.Value)*float64(time.Second)))
+			if earliest, ok := starts[fp]; !ok || started.Before(earliest) {
+				starts[fp] = started
+			}
+		}
+	}
+	return starts
+}
+
+// withoutName returns the labels of a metric without its name
+func withoutName(metric model.Metric) model.Metric {
+	labels := metric.Clone()
+	delete(labels, model.MetricNameLabel)
+	return labels
+}
+
+// ParseRAMSampleSumSteps sum_over_time RAM result to map[namespace/pod] (or namespace/pod/container) ->
+// timestamp -> byteSeconds, each sample weighing one scrape interval
+func (s LabelSchema) ParseRAMSampleSumSteps(result model.Value, scrapeInterval time.Duration, byContainer bool) PodStepSeries {
+	return parsePodSteps("RAM", result, scrapeInterval, s.Namespace, s.Pod, s.containerLabel(byContainer))
+}
+
+// parseCounterSteps sums the per-step increases of every counter series into its pod (or container) key
+func parseCounterSteps(result model.Value, starts map[model.Fingerprint]time.Time, start, end time.Time, step time.Duration, namespaceLabel, podLabel, containerLabel model.LabelName) PodStepSeries {
+	podSteps := make(PodStepSeries)
+	matrix, ok := result.(model.Matrix)
+	if !ok {
+		slog.Warn("parseCounterSteps expected matrix type", "received", fmt.Sprintf("%T", result))
+		return podSteps
+	}
+
+	for _, sampleStream := range matrix {
+		namespace := string(sampleStream.Metric[namespaceLabel])
+		pod := string(sampleStream.Metric[podLabel])
+		container := ""
+		if containerLabel != "" {
+			container = string(sampleStream.Metric[containerLabel])
+			if container == "" {
+				continue
+			}
+		}
+		if namespace == "" || pod == "" {
+			continue
+		}
+		podKey := GetContainerKey(namespace, pod, container)
+
+		increases, ok := CounterIncreaseSteps(sampleStream.Values, start, end, step, starts[withoutName(sampleStream.Metric).Fingerprint()])
+		if !ok {
+			// A scrape gap or a relabelled series, its first value holds usage from before the window
+			slog.Warn("Dropping CPU counter without a sample before the window that started earlier", "pod", podKey, "series", sampleStream.Metric.String())
+			continue
+		}
+		steps, exists := podSteps[podKey]
+		if !exists {
+			steps = make(map[model.Time]float64)
+			podSteps[podKey] = steps
+		}
+		for ts, increase := range increases {
+			steps[ts] += increase
+		}
+	}
+
+	slog.Debug("Exiting parseCounterSteps", "final_map_size", len(podSteps))
+	return podSteps
+}
+
+// CounterIncreaseSteps returns how much a counter grew during each step of the window (start, end], keyed by
+// step end. Unlike increase() nothing is extrapolated: the growth between two samples counts in the step of the
+// later one, so the steps always add up to the growth between the last samples before start and before end
+// whatever their length, and contiguous windows add up to the whole. A decrease is a counter reset (container
+// restart) and counts the new value. A series whose first sample is after start counts its first value only if
+// it started (startedAt) at most CounterLookback before start, otherwise the value includes usage before the
+// window: ok is false and the series must be dropped. Samples must be in time order, duplicated timestamps
+// (overlapping shards) are skipped.
+func CounterIncreaseSteps(values []model.SamplePair, start, end time.Time, step time.Duration, startedAt time.Time) (increases map[model.Time]float64, ok bool) {
+	increases = make(map[model.Time]float64)
+	windowStart, windowEnd := model.TimeFromUnixNano(start.UnixNano()), model.TimeFromUnixNano(end.UnixNano())
+	stepMillis := int64(step / time.Millisecond)
+	if stepMillis <= 0 {
+		return increases, true
+	}
+
+	var prev *model.SamplePair
+	for i := range values {
+		cur := &values[i]
+		value := float64(cur.Value)
+		if isNaN(value) || cur.Timestamp > windowEnd || (prev != nil && cur.Timestamp <= prev.Timestamp) {
+			continue
+		}
+		if prev == nil && cur.Timestamp > windowStart && startedAt.Before(start.Add(-CounterLookback)) {
+			return nil, false
+		}
+		increase := value
+		if prev != nil && value >= float64(prev.Value) {
+			increase = value - float64(prev.Value)
+		}
+		// Samples up to start only set the baseline, a first sample after it counts whole
+		prev = cur
+		if cur.Timestamp <= windowStart || increase == 0 {
+			continue
+		}
+		// Steps are left-open like range selectors: a sample on a step end belongs to that step
+		offset := int64(cur.Timestamp - windowStart)
+		increases[windowStart+model.Time((offset+stepMillis-1)/stepMillis*stepMillis)] += increase
+	}
+	return increases, true
+}
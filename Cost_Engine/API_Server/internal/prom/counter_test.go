@@ -0,0 +1,169 @@
+package prom
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// scrapeCounter samples a counter growing at rate(t) cores every interval from from to to, offset from
+// the step grid, resetting to zero at resetAt if set
+func scrapeCounter(from, to time.Time, interval time.Duration, rate func(time.Time) float64, resetAt time.Time) []model.SamplePair {
+	var values []model.SamplePair
+	var counter float64
+	prev := from
+	for ts := from; !ts.After(to); ts = ts.Add(interval) {
+		counter += rate(ts) * ts.Sub(prev).Seconds()
+		if !resetAt.IsZero() && !prev.After(resetAt) && ts.After(resetAt) {
+			counter = rate(ts) * ts.Sub(resetAt).Seconds()
+		}
+		values = append(values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: model.SampleValue(counter)})
+		prev = ts
+	}
+	return values
+}
+
+// increaseSteps is CounterIncreaseSteps for series that either have a baseline or started at their first sample
+func increaseSteps(t *testing.T, values []model.SamplePair, start, end time.Time, step time.Duration) map[model.Time]float64 {
+	t.Helper()
+	increases, ok := CounterIncreaseSteps(values, start, end, step, values[0].Timestamp.Time())
+	if !ok {
+		t.Fatalf("series starting at its first sample %s was dropped", values[0].Timestamp.Time())
+	}
+	return increases
+}
+
+func sumIncreases(increases map[model.Time]float64) float64 {
+	var total float64
+	for _, v := range increases {
+		total += v
+	}
+	return total
+}
+
+func TestCounterIncreaseStepsInvariantToStep(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(6 * time.Hour)
+	busy := func(ts time.Time) float64 { return 0.5 + 0.4*math.Sin(float64(ts.Unix())/900) }
+
+	series := map[string][]model.SamplePair{
+		// Scraped every 15s, 7s off the step grid, from before the window
+		"steady": scrapeCounter(start.Add(-CounterLookback+7*time.Second), end, 15*time.Second, busy, time.Time{}),
+		// Container restarted in the middle of the window
+		"restarted": scrapeCounter(start.Add(-CounterLookback+3*time.Second), end, 15*time.Second, busy, start.Add(2*time.Hour+5*time.Second)),
+		// Pod living 40 seconds, much shorter than any step
+		"short-lived": scrapeCounter(start.Add(3*time.Hour+10*time.Second), start.Add(3*time.Hour+50*time.Second), 20*time.Second, busy, time.Time{}),
+	}
+
+	for name, values := range series {
+		t.Run(name, func(t *testing.T) {
+			reference := sumIncreases(increaseSteps(t, values, start, end, time.Minute))
+			if reference <= 0 {
+				t.Fatalf("total increase = %v, want > 0", reference)
+			}
+			for _, step := range []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour} {
+				increases := increaseSteps(t, values, start, end, step)
+				if got := sumIncreases(increases); math.Abs(got-reference) > 1e-9*reference {
+					t.Errorf("step %s: total %v, want %v as with 1m steps", step, got, reference)
+				}
+				for ts := range increases {
+					offset := ts.Time().Sub(start)
+					if offset <= 0 || offset > end.Sub(start) || offset%step != 0 {
+						t.Errorf("step %s: increase keyed at %s, not a step end of the window", step, ts.Time())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCounterIncreaseStepsContiguousWindows(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	mid, end := start.Add(time.Hour), start.Add(2*time.Hour)
+	constant := func(time.Time) float64 { return 2 }
+	values := scrapeCounter(start.Add(-CounterLookback+11*time.Second), end, 15*time.Second, constant, time.Time{})
+
+	whole := sumIncreases(increaseSteps(t, values, start, end, time.Minute))
+	split := sumIncreases(increaseSteps(t, values, start, mid, time.Minute)) + sumIncreases(increaseSteps(t, values, mid, end, time.Minute))
+	if math.Abs(whole-split) > 1e-9 {
+		t.Errorf("contiguous windows add up to %v, want %v", split, whole)
+	}
+	// 2 cores over the 2 hours between the last samples before start and before end
+	if want := 2 * 2 * 3600.0; math.Abs(whole-want) > 1e-6 {
+		t.Errorf("total increase = %v core-seconds, want %v", whole, want)
+	}
+}
+
+func TestCounterIncreaseStepsWithoutBaseline(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	// First scraped 10 minutes into the window, the counter already holds a day of usage and grows 1 core
+	var values []model.SamplePair
+	for ts := start.Add(10 * time.Minute); !ts.After(end); ts = ts.Add(15 * time.Second) {
+		values = append(values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: model.SampleValue(86400 + ts.Sub(start.Add(10*time.Minute)).Seconds())})
+	}
+	whole := float64(values[len(values)-1].Value)
+
+	tests := []struct {
+		name      string
+		startedAt time.Time
+		wantOk    bool
+	}{
+		{name: "started in the window", startedAt: start.Add(9 * time.Minute), wantOk: true},
+		{name: "started within the lookback", startedAt: start.Add(-CounterLookback / 2), wantOk: true},
+		{name: "scrape gap", startedAt: start.Add(-24 * time.Hour), wantOk: false},
+		{name: "unknown start", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			increases, ok := CounterIncreaseSteps(values, start, end, time.Minute, tt.startedAt)
+			if ok != tt.wantOk {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got := sumIncreases(increases); ok && math.Abs(got-whole) > 1e-9 {
+				t.Errorf("total increase = %v, want the whole counter %v", got, whole)
+			}
+		})
+	}
+
+	// With a baseline the start time does not matter
+	steady := scrapeCounter(start.Add(-time.Minute), end, 15*time.Second, func(time.Time) float64 { return 1 }, time.Time{})
+	if _, ok := CounterIncreaseSteps(steady, start, end, time.Minute, time.Time{}); !ok {
+		t.Error("series with a sample before the window was dropped")
+	}
+}
+
+func TestRAMSampleSumInvariantToStep(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	interval := 15 * time.Second
+	metric := model.Metric{StandaloneCAdvisor.Namespace: "ns1-user1", StandaloneCAdvisor.Pod: "pod"}
+	// A pod alive 50 minutes, sampled 7s off the step grid
+	var samples []model.SamplePair
+	for ts := start.Add(7 * time.Second); ts.Before(start.Add(50 * time.Minute)); ts = ts.Add(interval) {
+		samples = append(samples, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: model.SampleValue(1 << 30)})
+	}
+	// sumOverTime evaluates sum_over_time(ram[step]) at every step end of the hour
+	sumOverTime := func(step time.Duration) model.Matrix {
+		stream := &model.SampleStream{Metric: metric}
+		for end := start.Add(step); !end.After(start.Add(time.Hour)); end = end.Add(step) {
+			var sum float64
+			for _, s := range samples {
+				if ts := s.Timestamp.Time(); ts.After(end.Add(-step)) && !ts.After(end) {
+					sum += float64(s.Value)
+				}
+			}
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(end.UnixNano()), Value: model.SampleValue(sum)})
+		}
+		return model.Matrix{stream}
+	}
+
+	want := float64(len(samples)) * (1 << 30) * interval.Seconds()
+	for _, step := range []time.Duration{time.Minute, 7*time.Minute + 30*time.Second, time.Hour} {
+		got := sumSteps(StandaloneCAdvisor.ParseRAMSampleSumSteps(sumOverTime(step), interval, false))[GetPodKey("ns1-user1", "pod")]
+		if math.Abs(got-want) > 1e-6*want {
+			t.Errorf("step %s: %v byte-seconds, want %v", step, got, want)
+		}
+	}
+}
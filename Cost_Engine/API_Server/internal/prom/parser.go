@@ -6,67 +6,136 @@ import (
 	"fmt"
 	"log/slog"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/common/model"
 )
 
+// PodStepSeries maps namespace/pod (or namespace/pod/container) -> sample timestamp -> amount accumulated
+// during the step ending at that timestamp
+type PodStepSeries map[string]map[model.Time]float64
+
 func GetPodKey(namespace, pod string) string {
 	return fmt.Sprintf("%s/%s", namespace, pod)
 }
 
+// GetContainerKey returns the series key of a container, pod level keys have no container part
+func GetContainerKey(namespace, pod, container string) string {
+	if container == "" {
+		return GetPodKey(namespace, pod)
+	}
+	return fmt.Sprintf("%s/%s/%s", namespace, pod, container)
+}
+
+// SplitKey splits a pod or container series key into namespace, pod and container ("" for pod keys)
+func SplitKey(key string) (namespace, pod, container string, ok bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 2 {
+		return "", "", "", false
+	}
+	if len(parts) == 3 {
+		container = parts[2]
+	}
+	return parts[0], parts[1], container, true
+}
+
 // ParseCPUUsage query result CPU to map[namespace/pod] -> totalCoreSeconds
-func ParseCPUUsage(result model.Value, step time.Duration) map[string]float64 {
-	slog.Debug("Entering ParseCPUUsage")
-	podUsage := make(map[string]float64)
+func (s LabelSchema) ParseCPUUsage(result model.Value, step time.Duration) map[string]float64 {
+	return sumSteps(s.ParseCPUUsageSteps(result, step, false))
+}
+
+// ParseRAMUsage query result RAM to map[namespace/pod] -> totalByteSeconds
+func (s LabelSchema) ParseRAMUsage(result model.Value, step time.Duration) map[string]float64 {
+	return sumSteps(s.ParseRAMUsageSteps(result, step, false))
+}
+
+// ParseCPUUsageSteps query result CPU to map[namespace/pod] (or namespace/pod/container) -> timestamp -> coreSeconds
+func (s LabelSchema) ParseCPUUsageSteps(result model.Value, step time.Duration, byContainer bool) PodStepSeries {
+	return parsePodSteps("CPU", result, step, s.Namespace, s.Pod, s.containerLabel(byContainer))
+}
+
+// ParseRAMUsageSteps query result RAM to map[namespace/pod] (or namespace/pod/container) -> timestamp -> byteSeconds
+func (s LabelSchema) ParseRAMUsageSteps(result model.Value, step time.Duration, byContainer bool) PodStepSeries {
+	return parsePodSteps("RAM", result, step, s.Namespace, s.Pod, s.containerLabel(byContainer))
+}
+
+// ParseRequestSteps kube-state-metrics requests result to map[namespace/pod] -> timestamp -> requested amount * seconds
+func ParseRequestSteps(result model.Value, step time.Duration) PodStepSeries {
+	return parsePodSteps("request", result, step, "namespace", "pod", "")
+}
+
+// ParseRequestContainerSteps kube-state-metrics requests result to map[namespace/pod/container] -> timestamp -> requested amount * seconds
+func ParseRequestContainerSteps(result model.Value, step time.Duration) PodStepSeries {
+	return parsePodSteps("request", result, step, "namespace", "pod", "container")
+}
+
+// parsePodSteps converts a per-pod rate/average matrix into per-step amounts (value * step seconds)
+// Series are keyed by container as well when containerLabel is set.
+func parsePodSteps(resource string, result model.Value, step time.Duration, namespaceLabel, podLabel, containerLabel model.LabelName) PodStepSeries {
+	slog.Debug("Entering parsePodSteps", "resource", resource)
+	podSteps := make(PodStepSeries)
 	matrix, ok := result.(model.Matrix)
 	if !ok {
 		slog.Warn(
-			"ParseCPUUsage expected matrix type",
+			"parsePodSteps expected matrix type",
+			"resource", resource,
 			"expected", "model.Matrix",
 			"received", fmt.Sprintf("%T", result),
 		)
-		return podUsage
+		return podSteps
 	}
 
-	slog.Debug("ParseCPUUsage processing matrix", "series_count", len(matrix))
+	slog.Debug("parsePodSteps processing matrix", "resource", resource, "series_count", len(matrix))
 
+	stepSeconds := step.Seconds()
 	for i, sampleStream := range matrix {
 		metric := sampleStream.Metric
 
-		namespace := string(metric["container_label_io_kubernetes_pod_namespace"])
-		pod := string(metric["container_label_io_kubernetes_pod_name"])
+		namespace := string(metric[namespaceLabel])
+		pod := string(metric[podLabel])
 
-		slog.Debug("Processing CPU series", "series_index", i, "raw_labels", metric)
+		slog.Debug("Processing series", "resource", resource, "series_index", i, "raw_labels", metric)
 
 		if namespace == "" || pod == "" {
 			slog.Debug(
-				"Skipping CPU series",
+				"Skipping series",
+				"resource", resource,
 				"series_index", i,
 				"reason", "missing k8s labels",
 				"namespace", namespace,
 				"pod", pod,
-				"namespace_label_key", "container_label_io_kubernetes_pod_namespace",
-				"pod_label_key", "container_label_io_kubernetes_pod_name",
+				"namespace_label_key", namespaceLabel,
+				"pod_label_key", podLabel,
 			)
 			continue
 		}
-		podKey := GetPodKey(namespace, pod)
+		container := ""
+		if containerLabel != "" {
+			container = string(metric[containerLabel])
+			if container == "" {
+				slog.Debug("Skipping series", "resource", resource, "series_index", i, "reason", "missing container label", "container_label_key", containerLabel)
+				continue
+			}
+		}
+		podKey := GetContainerKey(namespace, pod, container)
 
-		slog.Debug("Processing CPU series for pod", "series_index", i, "pod_key", podKey)
+		steps, exists := podSteps[podKey]
+		if !exists {
+			steps = make(map[model.Time]float64)
+			podSteps[podKey] = steps
+		}
 
-		var totalCoreSecs float64
 		var pointsProcessed int
 		for _, pair := range sampleStream.Values {
 			value, err := strconv.ParseFloat(pair.Value.String(), 64)
 			if err == nil && !isNaN(value) {
-				stepSeconds := step.Seconds()
-				pointCoreSecs := value * stepSeconds
-				totalCoreSecs += pointCoreSecs
+				steps[pair.Timestamp] += value * stepSeconds
 				pointsProcessed++
 			} else if err != nil {
 				slog.Warn(
-					"Could not parse CPU value",
+					"Could not parse value",
+					"resource", resource,
 					"raw_value", pair.Value.String(),
 					"pod_key", podKey,
 					"timestamp", pair.Timestamp.Time(),
@@ -74,102 +143,190 @@ func ParseCPUUsage(result model.Value, step time.Duration) map[string]float64 {
 				)
 			} else if isNaN(value) {
 				slog.Debug(
-					"Skipping NaN CPU value",
+					"Skipping NaN value",
+					"resource", resource,
 					"pod_key", podKey,
 					"timestamp", pair.Timestamp.Time(),
 				)
 			}
 		}
-		podUsage[podKey] += totalCoreSecs
 		slog.Debug(
-			"Finished processing CPU series for pod",
+			"Finished processing series for pod",
+			"resource", resource,
 			"series_index", i,
 			"pod_key", podKey,
 			"points_processed", pointsProcessed,
-			"total_core_seconds", totalCoreSecs,
 		)
 	}
 
-	slog.Debug("Exiting ParseCPUUsage", "final_map_size", len(podUsage))
-	return podUsage
+	slog.Debug("Exiting parsePodSteps", "resource", resource, "final_map_size", len(podSteps))
+	return podSteps
 }
 
-// ParseRAMUsage query result RAM to map[namespace/pod] -> totalByteSeconds
-func ParseRAMUsage(result model.Value, step time.Duration) map[string]float64 {
-
-	slog.Debug("Entering ParseRAMUsage")
-	podUsage := make(map[string]float64)
+// ParsePodNodes kube_pod_info result to map[namespace/pod] -> timestamp -> node
+func ParsePodNodes(result model.Value) map[string]map[model.Time]string {
+	podNodes := make(map[string]map[model.Time]string)
 	matrix, ok := result.(model.Matrix)
 	if !ok {
-		slog.Warn(
-			"ParseRAMUsage expected matrix type",
-			"expected", "model.Matrix",
-			"received", fmt.Sprintf("%T", result),
-		)
-		return podUsage
+		slog.Warn("ParsePodNodes expected matrix type", "received", fmt.Sprintf("%T", result))
+		return podNodes
 	}
 
-	slog.Debug("ParseRAMUsage processing matrix", "series_count", len(matrix))
+	for _, sampleStream := range matrix {
+		namespace := string(sampleStream.Metric["namespace"])
+		pod := string(sampleStream.Metric["pod"])
+		node := string(sampleStream.Metric["node"])
+		if namespace == "" || pod == "" || node == "" {
+			continue
+		}
+		podKey := GetPodKey(namespace, pod)
+		if _, exists := podNodes[podKey]; !exists {
+			podNodes[podKey] = make(map[model.Time]string)
+		}
+		for _, pair := range sampleStream.Values {
+			podNodes[podKey][pair.Timestamp] = node
+		}
+	}
 
-	for i, sampleStream := range matrix {
-		metric := sampleStream.Metric
-		namespace := string(metric["container_label_io_kubernetes_pod_namespace"])
-		pod := string(metric["container_label_io_kubernetes_pod_name"])
+	slog.Debug("Exiting ParsePodNodes", "final_map_size", len(podNodes))
+	return podNodes
+}
 
-		slog.Debug("Processing RAM series", "series_index", i, "raw_labels", metric)
+// ParseNodeLabels kube_node_labels result to map[node] -> labels
+// When a node has several label sets in the range, the last series returned wins.
+func ParseNodeLabels(result model.Value) map[string]map[string]string {
+	nodeLabels := make(map[string]map[string]string)
+	var metrics []model.Metric
+	switch v := result.(type) {
+	case model.Matrix:
+		for _, sampleStream := range v {
+			metrics = append(metrics, sampleStream.Metric)
+		}
+	case model.Vector:
+		for _, sample := range v {
+			metrics = append(metrics, sample.Metric)
+		}
+	default:
+		slog.Warn("ParseNodeLabels expected matrix or vector type", "received", fmt.Sprintf("%T", result))
+		return nodeLabels
+	}
 
-		if namespace == "" || pod == "" {
-			slog.Debug(
-				"Skipping RAM series",
-				"series_index", i,
-				"reason", "missing k8s labels",
-				"namespace", namespace,
-				"pod", pod,
-				"namespace_label_key", "container_label_io_kubernetes_pod_namespace",
-				"pod_label_key", "container_label_io_kubernetes_pod_name",
-			)
+	for _, metric := range metrics {
+		node := string(metric["node"])
+		if node == "" {
 			continue
 		}
-		podKey := GetPodKey(namespace, pod)
+		labels := make(map[string]string, len(metric))
+		for name, value := range metric {
+			labels[string(name)] = string(value)
+		}
+		nodeLabels[node] = labels
+	}
 
-		slog.Debug("Processing RAM series for pod", "series_index", i, "pod_key", podKey)
+	slog.Debug("Exiting ParseNodeLabels", "final_map_size", len(nodeLabels))
+	return nodeLabels
+}
 
-		var totalByteSecs float64
-		var pointsProcessed int
+// ParseNodeSeries node-exporter result (by nodename) to map[node] -> timestamp -> value
+func ParseNodeSeries(result model.Value) map[string]map[model.Time]float64 {
+	nodeSeries := make(map[string]map[model.Time]float64)
+	matrix, ok := result.(model.Matrix)
+	if !ok {
+		slog.Warn("ParseNodeSeries expected matrix type", "received", fmt.Sprintf("%T", result))
+		return nodeSeries
+	}
+
+	for _, sampleStream := range matrix {
+		node := string(sampleStream.Metric["nodename"])
+		if node == "" {
+			continue
+		}
+		if _, exists := nodeSeries[node]; !exists {
+			nodeSeries[node] = make(map[model.Time]float64)
+		}
 		for _, pair := range sampleStream.Values {
-			avgBytes, err := strconv.ParseFloat(pair.Value.String(), 64)
-			if err == nil && !isNaN(avgBytes) {
-				stepSeconds := step.Seconds()
-				pointByteSecs := avgBytes * stepSeconds
-				totalByteSecs += pointByteSecs
-				pointsProcessed++
-			} else if err != nil {
-				slog.Warn(
-					"Could not parse RAM value",
-					"raw_value", pair.Value.String(),
-					"pod_key", podKey,
-					"timestamp", pair.Timestamp.Time(),
-					"error", err,
-				)
-			} else if isNaN(avgBytes) {
-				slog.Debug(
-					"Skipping NaN RAM value",
-					"pod_key", podKey,
-					"timestamp", pair.Timestamp.Time(),
-				)
+			if value := float64(pair.Value); !isNaN(value) {
+				nodeSeries[node][pair.Timestamp] = value
 			}
 		}
-		podUsage[podKey] += totalByteSecs
-		slog.Debug(
-			"Finished processing RAM series for pod",
-			"series_index", i,
-			"pod_key", podKey,
-			"points_processed", pointsProcessed,
-			"total_byte_seconds", totalByteSecs,
-		)
 	}
 
-	slog.Debug("Exiting ParseRAMUsage", "final_map_size", len(podUsage))
+	slog.Debug("Exiting ParseNodeSeries", "final_map_size", len(nodeSeries))
+	return nodeSeries
+}
+
+// LatestValue returns the value with the greatest timestamp in a series
+func LatestValue(series map[model.Time]float64) float64 {
+	var latestTs model.Time
+	var latest float64
+	found := false
+	for ts, value := range series {
+		if !found || ts > latestTs {
+			latestTs, latest, found = ts, value, true
+		}
+	}
+	return latest
+}
+
+// ParseNamespaceMetadata kube_namespace_labels/kube_namespace_annotations result to map[namespace] -> labels
+// Only labels starting with prefix (label_, annotation_) are kept.
+func ParseNamespaceMetadata(result model.Value, prefix string) map[string]map[string]string {
+	namespaceLabels := make(map[string]map[string]string)
+	vector, ok := result.(model.Vector)
+	if !ok {
+		slog.Warn("ParseNamespaceMetadata expected vector type", "received", fmt.Sprintf("%T", result))
+		return namespaceLabels
+	}
+
+	for _, sample := range vector {
+		namespace := string(sample.Metric["namespace"])
+		if namespace == "" {
+			continue
+		}
+		labels := make(map[string]string)
+		for name, value := range sample.Metric {
+			if strings.HasPrefix(string(name), prefix) {
+				labels[string(name)] = string(value)
+			}
+		}
+		namespaceLabels[namespace] = labels
+	}
+
+	slog.Debug("Exiting ParseNamespaceMetadata", "prefix", prefix, "final_map_size", len(namespaceLabels))
+	return namespaceLabels
+}
+
+// KSMLabelName converts a Kubernetes label key to the name kube-state-metrics exposes it under
+// e.g. node.kubernetes.io/instance-type -> label_node_kubernetes_io_instance_type
+func KSMLabelName(key string) string {
+	return "label_" + sanitizeLabelName(key)
+}
+
+// KSMAnnotationName converts a Kubernetes annotation key to the name kube-state-metrics exposes it under
+// e.g. billing.example.com/tenant -> annotation_billing_example_com_tenant
+func KSMAnnotationName(key string) string {
+	return "annotation_" + sanitizeLabelName(key)
+}
+
+func sanitizeLabelName(key string) string {
+	sanitized := []byte(key)
+	for i, c := range sanitized {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			sanitized[i] = '_'
+		}
+	}
+	return string(sanitized)
+}
+
+func sumSteps(podSteps PodStepSeries) map[string]float64 {
+	podUsage := make(map[string]float64, len(podSteps))
+	for podKey, steps := range podSteps {
+		var total float64
+		for _, amount := range steps {
+			total += amount
+		}
+		podUsage[podKey] = total
+	}
 	return podUsage
 }
 
@@ -8,22 +8,51 @@ import (
 	"log/slog"
 	"time"
 
+	"simple-cost-calculator/internal/metrics"
+
 	prometheusAPI "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 )
 
 const (
-	// Query to get CPU usage rate (core-seconds) per pod, step will be replaced
-	CPUUsageRateQueryTemplate = `sum(rate(container_cpu_usage_seconds_total{image!="",container_label_io_kubernetes_pod_namespace!="",container_label_io_kubernetes_pod_name!=""}[%s])) by (container_label_io_kubernetes_pod_namespace, container_label_io_kubernetes_pod_name)`
+	// cAdvisor usage queries depend on the labels of the scraped cAdvisor, see LabelSchema
+
+	// Query to get CPU requests (cores) per running pod (kube-state-metrics)
+	CPURequestsQuery = `sum(kube_pod_container_resource_requests{resource="cpu"} * on(namespace, pod) group_left() max(kube_pod_status_phase{phase="Running"}) by (namespace, pod)) by (namespace, pod)`
+
+	// Query to get memory requests (bytes) per running pod (kube-state-metrics)
+	RAMRequestsQuery = `sum(kube_pod_container_resource_requests{resource="memory"} * on(namespace, pod) group_left() max(kube_pod_status_phase{phase="Running"}) by (namespace, pod)) by (namespace, pod)`
+
+	// Query to get CPU / memory requests per container of running pods (kube-state-metrics)
+	CPURequestsByContainerQuery = `sum(kube_pod_container_resource_requests{resource="cpu"} * on(namespace, pod) group_left() max(kube_pod_status_phase{phase="Running"}) by (namespace, pod)) by (namespace, pod, container)`
+	RAMRequestsByContainerQuery = `sum(kube_pod_container_resource_requests{resource="memory"} * on(namespace, pod) group_left() max(kube_pod_status_phase{phase="Running"}) by (namespace, pod)) by (namespace, pod, container)`
+
+	// Query to get the node each pod is scheduled on (kube-state-metrics)
+	PodNodeInfoQuery = `max(kube_pod_info{node!=""}) by (namespace, pod, node)`
+
+	// Query to get node labels (kube-state-metrics, labels must be allowed via --metric-labels-allowlist)
+	NodeLabelsQuery = `kube_node_labels`
+
+	// Query to get namespace labels / annotations (kube-state-metrics, must be allowed via
+	// --metric-labels-allowlist / --metric-annotations-allowlist)
+	NamespaceLabelsQuery      = `kube_namespace_labels`
+	NamespaceAnnotationsQuery = `kube_namespace_annotations`
+
+	// Query to get CPU cores per node (node-exporter), keyed by the node hostname from node_uname_info
+	NodeCPUCoresQuery = `count(node_cpu_seconds_total{mode="idle"} * on(instance) group_left(nodename) node_uname_info) by (nodename)`
 
-	// Query to get RAM usage (bytes) per pod, step will be replaced
-	RAMUsageAvgBytesQueryTemplate = `avg(avg_over_time(container_memory_working_set_bytes{image!="",container_label_io_kubernetes_pod_namespace!="",container_label_io_kubernetes_pod_name!="",container_label_io_cri_containerd_kind="container"}[%s])) by (container_label_io_kubernetes_pod_namespace, container_label_io_kubernetes_pod_name)`
+	// Query to get total memory (bytes) per node (node-exporter), keyed by the node hostname from node_uname_info
+	NodeMemoryBytesQuery = `max(node_memory_MemTotal_bytes * on(instance) group_left(nodename) node_uname_info) by (nodename)`
 )
 
 // QueryRange performs a range query against Prometheus API and returns the result.
 func QueryRange(ctx context.Context, api prometheusAPI.API, query string, queryRange prometheusAPI.Range) (model.Value, error) {
+	timer := prometheus.NewTimer(metrics.PrometheusQueryDuration.WithLabelValues("range"))
 	result, warnings, err := api.QueryRange(ctx, query, queryRange)
+	timer.ObserveDuration()
 	if err != nil {
+		metrics.PrometheusQueryErrors.WithLabelValues("range").Inc()
 		return nil, fmt.Errorf("prometheus range query failed for query '%s': %w", query, err)
 	}
 	if len(warnings) > 0 {
@@ -34,8 +63,11 @@ func QueryRange(ctx context.Context, api prometheusAPI.API, query string, queryR
 
 // QueryInstant performs an instant query against Prometheus API and returns the result.
 func QueryInstant(ctx context.Context, api prometheusAPI.API, query string, queryTime time.Time) (model.Value, error) {
+	timer := prometheus.NewTimer(metrics.PrometheusQueryDuration.WithLabelValues("instant"))
 	result, warnings, err := api.Query(ctx, query, queryTime)
+	timer.ObserveDuration()
 	if err != nil {
+		metrics.PrometheusQueryErrors.WithLabelValues("instant").Inc()
 		return nil, fmt.Errorf("prometheus instant query failed for query '%s': %w", query, err)
 	}
 	if len(warnings) > 0 {
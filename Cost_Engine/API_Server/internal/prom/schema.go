@@ -0,0 +1,191 @@
+// internal/prom/schema.go
+
+package prom
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+// LabelSchema names the labels of the cAdvisor series carrying the Kubernetes namespace, pod and container,
+// and builds and parses the cAdvisor queries with them
+type LabelSchema struct {
+	Namespace, Pod model.LabelName
+	// Container may be empty, per-container queries are then unavailable
+	Container model.LabelName
+	// ContainerMatchers keep only container series in RAM queries, may be empty
+	ContainerMatchers string
+}
+
+// StandaloneCAdvisor is the schema of the cAdvisor DaemonSet, which exposes the container runtime labels
+var StandaloneCAdvisor = LabelSchema{
+	Namespace:         "container_label_io_kubernetes_pod_namespace",
+	Pod:               "container_label_io_kubernetes_pod_name",
+	Container:         "container_label_io_kubernetes_container_name",
+	ContainerMatchers: `container_label_io_cri_containerd_kind="container"`,
+}
+
+// KubeletCAdvisor is the schema of the kubelet /metrics/cadvisor endpoint, whose pod cgroup and pause
+// container series have an empty or POD container
+var KubeletCAdvisor = LabelSchema{
+	Namespace:         "namespace",
+	Pod:               "pod",
+	Container:         "container",
+	ContainerMatchers: `container!="",container!="POD"`,
+}
+
+// NewLabelSchema resolves a label schema configuration, standalone if the profile is empty
+func NewLabelSchema(conf types.LabelSchemaConfig) (LabelSchema, error) {
+	switch conf.Profile {
+	case "", types.LabelSchemaStandalone:
+		return StandaloneCAdvisor, nil
+	case types.LabelSchemaKubelet:
+		return KubeletCAdvisor, nil
+	case types.LabelSchemaCustom:
+		containerMatchers, err := parseMatchers(conf.ContainerMatchers)
+		if err != nil {
+			return LabelSchema{}, fmt.Errorf("custom label schema has invalid containerMatchers: %w", err)
+		}
+		schema := LabelSchema{
+			Namespace:         model.LabelName(conf.NamespaceLabel),
+			Pod:               model.LabelName(conf.PodLabel),
+			Container:         model.LabelName(conf.ContainerLabel),
+			ContainerMatchers: containerMatchers,
+		}
+		// Labels are written unquoted into PromQL selectors
+		if !schema.Namespace.IsValidLegacy() || !schema.Pod.IsValidLegacy() {
+			return LabelSchema{}, fmt.Errorf("custom label schema needs valid namespaceLabel and podLabel, got '%s' and '%s'", schema.Namespace, schema.Pod)
+		}
+		if schema.Container != "" && !schema.Container.IsValidLegacy() {
+			return LabelSchema{}, fmt.Errorf("custom label schema has an invalid containerLabel '%s'", schema.Container)
+		}
+		return schema, nil
+	}
+	return LabelSchema{}, fmt.Errorf("invalid label schema profile '%s' (standalone, kubelet, custom)", conf.Profile)
+}
+
+// parseMatchers checks comma-separated PromQL label matchers (label="value", !=, =~ or !~) and returns them
+// normalized, since they are pasted into the selectors of every RAM query
+func parseMatchers(input string) (string, error) {
+	var matchers []string
+	rest := strings.TrimSpace(input)
+	for rest != "" {
+		end := strings.IndexAny(rest, "=!")
+		if end < 0 {
+			return "", fmt.Errorf("matcher '%s' has no operator", rest)
+		}
+		label := model.LabelName(strings.TrimSpace(rest[:end]))
+		if !label.IsValidLegacy() {
+			return "", fmt.Errorf("invalid label name '%s'", label)
+		}
+		rest = rest[end:]
+
+		op := ""
+		for _, candidate := range []string{"=~", "!~", "!=", "="} {
+			if strings.HasPrefix(rest, candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return "", fmt.Errorf("label '%s' has an invalid operator", label)
+		}
+		rest = strings.TrimSpace(rest[len(op):])
+
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil || quoted[0] == '\'' {
+			return "", fmt.Errorf("label '%s' needs a quoted value", label)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", fmt.Errorf("label '%s' has an invalid value %s: %w", label, quoted, err)
+		}
+		if op == "=~" || op == "!~" {
+			if _, err := regexp.Compile("^(?:" + value + ")$"); err != nil {
+				return "", fmt.Errorf("label '%s' has an invalid regex: %w", label, err)
+			}
+		}
+		matchers = append(matchers, fmt.Sprintf("%s%s%s", label, op, strconv.Quote(value)))
+
+		rest = strings.TrimSpace(rest[len(quoted):])
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return "", fmt.Errorf("expected ',' after the matcher of label '%s', got '%s'", label, rest)
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+	return strings.Join(matchers, ","), nil
+}
+
+// CPUUsageRateQuery returns the query of the CPU usage rate (cores) per pod, or per container, over each step
+func (s LabelSchema) CPUUsageRateQuery(step time.Duration, byContainer bool) string {
+	return fmt.Sprintf(`sum(rate(container_cpu_usage_seconds_total{%s}[%s])) by (%s)`, s.matchers(byContainer, false), step, s.groupBy(byContainer))
+}
+
+// RAMUsageAvgBytesQuery returns the query of the RAM usage (bytes) per pod, or per container, averaged over each step
+func (s LabelSchema) RAMUsageAvgBytesQuery(step time.Duration, byContainer bool) string {
+	return fmt.Sprintf(`avg(avg_over_time(container_memory_working_set_bytes{%s}[%s])) by (%s)`, s.matchers(byContainer, true), step, s.groupBy(byContainer))
+}
+
+// CPUUsageCounterSelector returns the selector of the raw CPU usage counters (core-seconds) of pod, or named,
+// containers, for exact accounting
+func (s LabelSchema) CPUUsageCounterSelector(byContainer bool) string {
+	return fmt.Sprintf(`container_cpu_usage_seconds_total{%s}`, s.matchers(byContainer, false))
+}
+
+// RAMUsageSumBytesQuery returns the query of the sum of RAM samples (bytes) per pod, or per container, over each
+// step, for exact accounting
+func (s LabelSchema) RAMUsageSumBytesQuery(step time.Duration, byContainer bool) string {
+	return fmt.Sprintf(`sum(sum_over_time(container_memory_working_set_bytes{%s}[%s])) by (%s)`, s.matchers(byContainer, true), step, s.groupBy(byContainer))
+}
+
+// ContainerStartQuery returns the query of the start time (Unix seconds) of every counter series of
+// CPUUsageCounterSelector over each step, telling series that began in a window from scrape gaps
+func (s LabelSchema) ContainerStartQuery(step time.Duration, byContainer bool) string {
+	return fmt.Sprintf(`min_over_time(container_start_time_seconds{%s}[%s])`, s.matchers(byContainer, false), step)
+}
+
+// CheckContainer returns an error when per-container queries are unavailable
+func (s LabelSchema) CheckContainer() error {
+	if s.Container == "" {
+		return fmt.Errorf("the label schema has no container label, per-container costs are unavailable")
+	}
+	return nil
+}
+
+// matchers returns the label matchers of pod (or named container) series, of containers only in RAM queries
+func (s LabelSchema) matchers(byContainer, containersOnly bool) string {
+	matchers := fmt.Sprintf(`image!="",%s!="",%s!=""`, s.Namespace, s.Pod)
+	if byContainer {
+		matchers += fmt.Sprintf(`,%s!=""`, s.Container)
+	}
+	if containersOnly && s.ContainerMatchers != "" {
+		matchers += "," + s.ContainerMatchers
+	}
+	return matchers
+}
+
+// groupBy returns the labels usage is summed by
+func (s LabelSchema) groupBy(byContainer bool) string {
+	if byContainer {
+		return fmt.Sprintf("%s, %s, %s", s.Namespace, s.Pod, s.Container)
+	}
+	return fmt.Sprintf("%s, %s", s.Namespace, s.Pod)
+}
+
+// containerLabel returns the label usage is keyed by besides namespace and pod, none at pod level
+func (s LabelSchema) containerLabel(byContainer bool) model.LabelName {
+	if byContainer {
+		return s.Container
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestNewLabelSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    types.LabelSchemaConfig
+		want    LabelSchema
+		wantErr bool
+	}{
+		{name: "default", conf: types.LabelSchemaConfig{}, want: StandaloneCAdvisor},
+		{name: "kubelet", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaKubelet}, want: KubeletCAdvisor},
+		{
+			name: "custom",
+			conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod_name", ContainerMatchers: " kind=\"container\" "},
+			want: LabelSchema{Namespace: "ns", Pod: "pod_name", ContainerMatchers: `kind="container"`},
+		},
+		{
+			name: "custom with several matchers",
+			conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod", ContainerMatchers: `container != "", container !~ "POD|pause", `},
+			want: LabelSchema{Namespace: "ns", Pod: "pod", ContainerMatchers: `container!="",container!~"POD|pause"`},
+		},
+		{name: "custom with unquoted matcher value", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod", ContainerMatchers: `kind=container`}, wantErr: true},
+		{name: "custom with injected selector", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod", ContainerMatchers: `kind="container"}) or vector(1) #`}, wantErr: true},
+		{name: "custom with invalid matcher regex", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod", ContainerMatchers: `container=~"("`}, wantErr: true},
+		{name: "custom with invalid matcher label", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod", ContainerMatchers: `container-kind="container"`}, wantErr: true},
+		{name: "custom without pod label", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns"}, wantErr: true},
+		{name: "custom with invalid container label", conf: types.LabelSchemaConfig{Profile: types.LabelSchemaCustom, NamespaceLabel: "ns", PodLabel: "pod", ContainerLabel: "container-name"}, wantErr: true},
+		{name: "unknown profile", conf: types.LabelSchemaConfig{Profile: "docker"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewLabelSchema(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewLabelSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NewLabelSchema() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelSchemaQueries(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "standalone CPU",
+			query: StandaloneCAdvisor.CPUUsageRateQuery(time.Minute, false),
+			want:  `sum(rate(container_cpu_usage_seconds_total{image!="",container_label_io_kubernetes_pod_namespace!="",container_label_io_kubernetes_pod_name!=""}[1m0s])) by (container_label_io_kubernetes_pod_namespace, container_label_io_kubernetes_pod_name)`,
+		},
+		{
+			name:  "standalone RAM by container",
+			query: StandaloneCAdvisor.RAMUsageAvgBytesQuery(time.Minute, true),
+			want:  `avg(avg_over_time(container_memory_working_set_bytes{image!="",container_label_io_kubernetes_pod_namespace!="",container_label_io_kubernetes_pod_name!="",container_label_io_kubernetes_container_name!="",container_label_io_cri_containerd_kind="container"}[1m0s])) by (container_label_io_kubernetes_pod_namespace, container_label_io_kubernetes_pod_name, container_label_io_kubernetes_container_name)`,
+		},
+		{
+			name:  "kubelet RAM",
+			query: KubeletCAdvisor.RAMUsageAvgBytesQuery(5*time.Minute, false),
+			want:  `avg(avg_over_time(container_memory_working_set_bytes{image!="",namespace!="",pod!="",container!="",container!="POD"}[5m0s])) by (namespace, pod)`,
+		},
+		{
+			name:  "kubelet CPU counters by container",
+			query: KubeletCAdvisor.CPUUsageCounterSelector(true),
+			want:  `container_cpu_usage_seconds_total{image!="",namespace!="",pod!="",container!=""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.query != tt.want {
+				t.Errorf("query = %s, want %s", tt.query, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeletParseUsage(t *testing.T) {
+	ts := model.Time(1735689660000)
+	result := model.Matrix{
+		{Metric: model.Metric{"namespace": "ns1-user1", "pod": "web", "container": "app"}, Values: []model.SamplePair{{Timestamp: ts, Value: 0.5}}},
+		// Standalone labels are not read by the kubelet schema
+		{Metric: model.Metric{"container_label_io_kubernetes_pod_namespace": "ns1-user1", "container_label_io_kubernetes_pod_name": "batch"}, Values: []model.SamplePair{{Timestamp: ts, Value: 1}}},
+	}
+
+	got := KubeletCAdvisor.ParseCPUUsageSteps(result, time.Minute, true)
+	if len(got) != 1 || got["ns1-user1/web/app"][ts] != 30 {
+		t.Errorf("ParseCPUUsageSteps() = %v, want 30 core-seconds for ns1-user1/web/app", got)
+	}
+}
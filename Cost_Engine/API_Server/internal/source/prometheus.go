@@ -0,0 +1,197 @@
+// internal/source/prometheus.go
+
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	prometheusAPI "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// Prometheus reads cAdvisor (through its LabelSchema), kube-state-metrics and node-exporter series with the PromQL of prom
+type Prometheus struct {
+	api    prometheusAPI.API
+	limits QueryLimits
+	// schema names the labels of the scraped cAdvisor
+	schema prom.LabelSchema
+	// scrapeInterval of cAdvisor, each RAM sample stands for one interval with exact accounting
+	scrapeInterval time.Duration
+}
+
+// NewPrometheus creates a source querying a Prometheus server
+func NewPrometheus(api prometheusAPI.API, limits QueryLimits, schema prom.LabelSchema, scrapeInterval time.Duration) *Prometheus {
+	return &Prometheus{api: api, limits: limits, schema: schema, scrapeInterval: scrapeInterval}
+}
+
+// Usage queries the CPU and RAM usage of every pod (or container)
+func (p *Prometheus) Usage(ctx context.Context, q Query) (Series, Series, error) {
+	if q.ByContainer {
+		if err := p.schema.CheckContainer(); err != nil {
+			return Series{}, Series{}, err
+		}
+	}
+	queries := map[string]string{
+		"cpu": p.schema.CPUUsageRateQuery(q.Step, q.ByContainer),
+		"ram": p.schema.RAMUsageAvgBytesQuery(q.Step, q.ByContainer),
+	}
+	parseCPU := func(result model.Value) prom.PodStepSeries {
+		return p.schema.ParseCPUUsageSteps(result, q.Step, q.ByContainer)
+	}
+	parseRAM := func(result model.Value) prom.PodStepSeries {
+		return p.schema.ParseRAMUsageSteps(result, q.Step, q.ByContainer)
+	}
+
+	// Exact accounting integrates the raw CPU counters and weighs every RAM sample by the scrape interval
+	samples := map[string]string{}
+	exact := q.Accounting == types.UsageAccountingExact
+	var results map[string]*rangeQuery
+	if exact {
+		if p.scrapeInterval <= 0 {
+			return Series{}, Series{}, fmt.Errorf("exact usage accounting needs the scrape interval")
+		}
+		delete(queries, "cpu")
+		samples["cpu"] = p.schema.CPUUsageCounterSelector(q.ByContainer)
+		queries["cpuStart"] = p.schema.ContainerStartQuery(q.Step, q.ByContainer)
+		queries["ram"] = p.schema.RAMUsageSumBytesQuery(q.Step, q.ByContainer)
+		parseCPU = func(result model.Value) prom.PodStepSeries {
+			return p.schema.ParseCPUCounterSteps(result, results["cpuStart"].result, q.Start, q.End, q.Step, q.ByContainer)
+		}
+		parseRAM = func(result model.Value) prom.PodStepSeries {
+			return p.schema.ParseRAMSampleSumSteps(result, p.scrapeInterval, q.ByContainer)
+		}
+	}
+
+	results = runRangeQueries(ctx, p.api, q.Range(), queries, samples, p.limits)
+	if err := results["cpu"].err; err != nil {
+		return Series{}, Series{}, fmt.Errorf("error querying CPU usage: %w", err)
+	}
+	if err := results["ram"].err; err != nil {
+		return Series{}, Series{}, fmt.Errorf("error querying RAM usage: %w", err)
+	}
+	// The CPU counters are parsed with the start times
+	if err := results["cpuStart"].err; exact && err != nil {
+		return Series{}, Series{}, fmt.Errorf("error querying container start times: %w", err)
+	}
+	cpu := Series{Steps: parseCPU(results["cpu"].result), Missing: missingWindows(results["cpu"], q.Step)}
+	ram := Series{Steps: parseRAM(results["ram"].result), Missing: missingWindows(results["ram"], q.Step)}
+	if exact {
+		// Counters of the series missing a start time are dropped, report their windows too
+		cpu.Missing = append(cpu.Missing, missingWindows(results["cpuStart"], q.Step)...)
+		dropMissingSteps(cpu.Steps, cpu.Missing)
+	}
+	return cpu, ram, nil
+}
+
+// Requests queries the CPU and RAM requests of every running pod (or container)
+func (p *Prometheus) Requests(ctx context.Context, q Query) (Series, Series, error) {
+	queries := map[string]string{"cpuRequest": prom.CPURequestsQuery, "ramRequest": prom.RAMRequestsQuery}
+	parse := prom.ParseRequestSteps
+	if q.ByContainer {
+		queries = map[string]string{"cpuRequest": prom.CPURequestsByContainerQuery, "ramRequest": prom.RAMRequestsByContainerQuery}
+		parse = prom.ParseRequestContainerSteps
+	}
+
+	results := runRangeQueries(ctx, p.api, q.Range(), queries, nil, p.limits)
+	for _, name := range []string{"cpuRequest", "ramRequest"} {
+		if err := results[name].err; err != nil {
+			return Series{}, Series{}, fmt.Errorf("error querying %s: %w", name, err)
+		}
+	}
+	cpu := Series{Steps: parse(results["cpuRequest"].result, q.Step), Missing: missingWindows(results["cpuRequest"], q.Step)}
+	ram := Series{Steps: parse(results["ramRequest"].result, q.Step), Missing: missingWindows(results["ramRequest"], q.Step)}
+	return cpu, ram, nil
+}
+
+// Nodes queries the kube_pod_info nodes, kube_node_labels and node-exporter capacity
+func (p *Prometheus) Nodes(ctx context.Context, q Query) (NodeInfo, error) {
+	queries := map[string]string{
+		"podInfo":    prom.PodNodeInfoQuery,
+		"nodeLabels": prom.NodeLabelsQuery,
+		"nodeCPU":    prom.NodeCPUCoresQuery,
+		"nodeMemory": prom.NodeMemoryBytesQuery,
+	}
+	results := runRangeQueries(ctx, p.api, q.Range(), queries, nil, p.limits)
+
+	info := NodeInfo{PodNodes: map[string]map[model.Time]string{}, Labels: map[string]map[string]string{}}
+	var errs []error
+	if err := results["podInfo"].err; err != nil {
+		errs = append(errs, fmt.Errorf("error querying pod nodes: %w", err))
+	} else {
+		info.PodNodes = prom.ParsePodNodes(results["podInfo"].result)
+	}
+	if err := results["nodeLabels"].err; err != nil {
+		errs = append(errs, fmt.Errorf("error querying node labels: %w", err))
+	} else {
+		info.Labels = prom.ParseNodeLabels(results["nodeLabels"].result)
+	}
+	if err := errors.Join(results["nodeCPU"].err, results["nodeMemory"].err); err != nil {
+		errs = append(errs, fmt.Errorf("error querying node capacity: %w", err))
+	} else {
+		info.CPUCores = prom.ParseNodeSeries(results["nodeCPU"].result)
+		info.RAMBytes = prom.ParseNodeSeries(results["nodeMemory"].result)
+	}
+	return info, errors.Join(errs...)
+}
+
+// NamespaceMetadata queries kube_namespace_labels and/or kube_namespace_annotations at a time
+func (p *Prometheus) NamespaceMetadata(ctx context.Context, at time.Time, labels, annotations bool) (map[string]types.NamespaceMetadata, error) {
+	namespaceMeta := make(map[string]types.NamespaceMetadata)
+	var errs []error
+	if labels {
+		result, err := prom.QueryInstant(ctx, p.api, prom.NamespaceLabelsQuery, at)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error querying namespace labels: %w", err))
+		} else {
+			for namespace, labels := range prom.ParseNamespaceMetadata(result, "label_") {
+				meta := namespaceMeta[namespace]
+				meta.Labels = labels
+				namespaceMeta[namespace] = meta
+			}
+		}
+	}
+	if annotations {
+		result, err := prom.QueryInstant(ctx, p.api, prom.NamespaceAnnotationsQuery, at)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error querying namespace annotations: %w", err))
+		} else {
+			for namespace, annotations := range prom.ParseNamespaceMetadata(result, "annotation_") {
+				meta := namespaceMeta[namespace]
+				meta.Annotations = annotations
+				namespaceMeta[namespace] = meta
+			}
+		}
+	}
+	return namespaceMeta, errors.Join(errs...)
+}
+
+// dropMissingSteps removes the amounts of the steps ending within missing windows. The first counter sample
+// after a failed shard would otherwise charge the growth across the whole shard to one of its steps, while the
+// shard is also reported missing.
+func dropMissingSteps(steps prom.PodStepSeries, missing []types.Window) {
+	for _, window := range missing {
+		from, to := model.TimeFromUnixNano(window.Start.UnixNano()), model.TimeFromUnixNano(window.End.UnixNano())
+		for _, podSteps := range steps {
+			for ts := range podSteps {
+				if ts > from && ts <= to {
+					delete(podSteps, ts)
+				}
+			}
+		}
+	}
+}
+
+// missingWindows converts the failed shards of a query to the windows of the steps they cover
+func missingWindows(rq *rangeQuery, step time.Duration) []types.Window {
+	var missing []types.Window
+	for _, shard := range rq.missing {
+		missing = append(missing, types.Window{Start: shard.Start.Add(-step), End: shard.End})
+	}
+	return missing
+}
@@ -0,0 +1,41 @@
+package source
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestDropMissingSteps(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	step := time.Minute
+	// A counter growing 1 core-second per second over 3 hours queried in hourly shards, the middle one failed:
+	// the first shard holds its samples, the last one its samples from CounterLookback before its first step
+	metric := model.Metric{model.MetricNameLabel: "container_cpu_usage_seconds_total", "container_label_io_kubernetes_pod_namespace": "ns1", "container_label_io_kubernetes_pod_name": "pod"}
+	var values []model.SamplePair
+	addSamples := func(from, to time.Time) {
+		for ts := from; !ts.After(to); ts = ts.Add(15 * time.Second) {
+			values = append(values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: model.SampleValue(ts.Sub(start.Add(-time.Hour)).Seconds())})
+		}
+	}
+	addSamples(start.Add(-prom.CounterLookback), start.Add(time.Hour))
+	addSamples(start.Add(2*time.Hour-prom.CounterLookback), start.Add(3*time.Hour))
+	starts := model.Matrix{{Metric: metric, Values: []model.SamplePair{{Timestamp: model.TimeFromUnixNano(start.UnixNano()), Value: model.SampleValue(start.Add(-time.Hour).Unix())}}}}
+
+	steps := prom.StandaloneCAdvisor.ParseCPUCounterSteps(model.Matrix{{Metric: metric, Values: values}}, starts, start, start.Add(3*time.Hour), step, false)
+	dropMissingSteps(steps, []types.Window{{Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)}})
+
+	var total float64
+	for _, amount := range steps[prom.GetPodKey("ns1", "pod")] {
+		total += amount
+	}
+	// Two hours of usage, nothing of the missing hour
+	if want := 2 * 3600.0; math.Abs(total-want) > 1e-6 {
+		t.Errorf("total = %v core-seconds, want %v", total, want)
+	}
+}
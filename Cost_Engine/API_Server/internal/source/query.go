@@ -0,0 +1,183 @@
+// internal/source/query.go
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"simple-cost-calculator/internal/prom"
+
+	prometheusAPI "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// QueryLimits bound the range queries sent to Prometheus
+type QueryLimits struct {
+	// MaxPoints per series of one range query, longer ranges are split into step-aligned shards
+	// (Prometheus rejects queries over 11,000 points per series)
+	MaxPoints int
+	// MaxConcurrentShards of one query in flight at once
+	MaxConcurrentShards int
+	// MaxSampleRange of the raw samples selected by one query, in shards of at least one step. Every raw
+	// sample counts towards the Prometheus query.max-samples limit.
+	MaxSampleRange time.Duration
+}
+
+// DefaultQueryLimits stay under the Prometheus point limit with some headroom, and select about 500 raw
+// samples per series at a 15s scrape interval
+var DefaultQueryLimits = QueryLimits{MaxPoints: 10000, MaxConcurrentShards: 4, MaxSampleRange: 2 * time.Hour}
+
+// rangeQuery holds the outcome of one named range query
+type rangeQuery struct {
+	query  string
+	result model.Value
+	// err is set when the query failed over the whole range
+	err error
+	// missing lists the shards that failed while others succeeded
+	missing []prometheusAPI.Range
+}
+
+// runRangeQueries runs the named queries concurrently over the same range and waits for all of them.
+// The raw samples of the named selectors in samples are fetched over the range as well, starting
+// prom.CounterLookback earlier. Ranges over limits.MaxPoints are queried in shards whose matrices are merged,
+// raw samples in shards of at most limits.MaxSampleRange.
+func runRangeQueries(ctx context.Context, api prometheusAPI.API, queryRange prometheusAPI.Range, queries, samples map[string]string, limits QueryLimits) map[string]*rangeQuery {
+	shards := splitRange(queryRange, limits.MaxPoints)
+	if len(shards) > 1 {
+		slog.Info("Splitting range queries into shards", "shards", len(shards), "max_points", limits.MaxPoints)
+	}
+	sampleShards := shards
+	if len(samples) > 0 {
+		sampleShards = splitRange(queryRange, samplePoints(queryRange.Step, limits))
+	}
+
+	results := make(map[string]*rangeQuery, len(queries)+len(samples))
+	var wg sync.WaitGroup
+	run := func(name, query string, shards []prometheusAPI.Range, fetch shardFetch) {
+		rq := &rangeQuery{query: query}
+		results[name] = rq
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rq.result, rq.missing, rq.err = queryShards(ctx, rq.query, shards, limits.MaxConcurrentShards, fetch)
+		}()
+	}
+	for name, query := range queries {
+		run(name, query, shards, func(ctx context.Context, shard prometheusAPI.Range) (model.Value, error) {
+			return prom.QueryRange(ctx, api, query, shard)
+		})
+	}
+	for name, selector := range samples {
+		run(name, selector, sampleShards, func(ctx context.Context, shard prometheusAPI.Range) (model.Value, error) {
+			// The shard covers the steps ending from shard.Start to shard.End
+			lookback := shard.End.Sub(shard.Start) + shard.Step + prom.CounterLookback
+			return prom.QueryInstant(ctx, api, fmt.Sprintf("%s[%s]", selector, model.Duration(lookback)), shard.End)
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// samplePoints returns the most steps of a raw samples shard: under both limits, at least one
+func samplePoints(step time.Duration, limits QueryLimits) int {
+	points := limits.MaxPoints
+	if limits.MaxSampleRange > 0 && step > 0 {
+		if byRange := int(limits.MaxSampleRange / step); points <= 0 || byRange < points {
+			points = max(byRange, 1)
+		}
+	}
+	return points
+}
+
+// shardFetch queries one shard of a range
+type shardFetch func(ctx context.Context, shard prometheusAPI.Range) (model.Value, error)
+
+// splitRange splits a range into consecutive step-aligned ranges of at most maxPoints samples each
+func splitRange(r prometheusAPI.Range, maxPoints int) []prometheusAPI.Range {
+	if maxPoints <= 0 || r.Step <= 0 {
+		return []prometheusAPI.Range{r}
+	}
+	span := time.Duration(maxPoints-1) * r.Step
+	var shards []prometheusAPI.Range
+	for start := r.Start; !start.After(r.End); start = start.Add(span + r.Step) {
+		end := start.Add(span)
+		if end.After(r.End) {
+			end = r.End
+		}
+		shards = append(shards, prometheusAPI.Range{Start: start, End: end, Step: r.Step})
+	}
+	return shards
+}
+
+// queryShards fetches a query over every shard with at most concurrency in flight and merges the matrices.
+// It fails only when every shard fails, otherwise the failed shards are returned as missing.
+func queryShards(ctx context.Context, query string, shards []prometheusAPI.Range, concurrency int, fetch shardFetch) (model.Value, []prometheusAPI.Range, error) {
+	if len(shards) == 1 {
+		result, err := fetch(ctx, shards[0])
+		return result, nil, err
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]model.Value, len(shards))
+	errs := make([]error, len(shards))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = fetch(ctx, shard)
+		}()
+	}
+	wg.Wait()
+
+	var parts []model.Matrix
+	var missing []prometheusAPI.Range
+	var firstErr error
+	for i, shard := range shards {
+		if errs[i] == nil {
+			if matrix, ok := results[i].(model.Matrix); ok {
+				parts = append(parts, matrix)
+				continue
+			}
+			errs[i] = fmt.Errorf("expected matrix for query '%s', got %T", query, results[i])
+		}
+		slog.Warn("Range query shard failed", "query", query, "start", shard.Start.Format(time.RFC3339), "end", shard.End.Format(time.RFC3339), "error", errs[i])
+		missing = append(missing, shard)
+		if firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+	if len(parts) == 0 {
+		return nil, nil, fmt.Errorf("all %d shards failed: %w", len(shards), firstErr)
+	}
+	return mergeMatrices(parts), missing, nil
+}
+
+// mergeMatrices joins the series of consecutive matrices, parts must be in time order.
+// Raw samples of overlapping shards are repeated, the counter parser skips them.
+func mergeMatrices(parts []model.Matrix) model.Matrix {
+	merged := model.Matrix{}
+	byFingerprint := make(map[model.Fingerprint]*model.SampleStream)
+	for _, part := range parts {
+		for _, stream := range part {
+			fp := stream.Metric.Fingerprint()
+			if existing, ok := byFingerprint[fp]; ok {
+				existing.Values = append(existing.Values, stream.Values...)
+				continue
+			}
+			copied := &model.SampleStream{Metric: stream.Metric, Values: append([]model.SamplePair(nil), stream.Values...)}
+			byFingerprint[fp] = copied
+			merged = append(merged, copied)
+		}
+	}
+	return merged
+}
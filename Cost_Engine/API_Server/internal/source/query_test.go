@@ -0,0 +1,96 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	prometheusAPI "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func TestSplitRange(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 1, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		r         prometheusAPI.Range
+		maxPoints int
+		wantCount int
+	}{
+		{"under limit", prometheusAPI.Range{Start: start, End: start.Add(99 * time.Minute), Step: time.Minute}, 100, 1},
+		{"exact multiple", prometheusAPI.Range{Start: start, End: start.Add(199 * time.Minute), Step: time.Minute}, 100, 2},
+		{"remainder", prometheusAPI.Range{Start: start, End: start.Add(30 * 24 * time.Hour), Step: time.Minute}, 10000, 5},
+		{"no limit", prometheusAPI.Range{Start: start, End: start.Add(30 * 24 * time.Hour), Step: time.Minute}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shards := splitRange(tt.r, tt.maxPoints)
+			if len(shards) != tt.wantCount {
+				t.Fatalf("got %d shards, want %d", len(shards), tt.wantCount)
+			}
+			if !shards[0].Start.Equal(tt.r.Start) || !shards[len(shards)-1].End.Equal(tt.r.End) {
+				t.Errorf("shards cover %s - %s, want %s - %s", shards[0].Start, shards[len(shards)-1].End, tt.r.Start, tt.r.End)
+			}
+			points := 0
+			for i, s := range shards {
+				n := int(s.End.Sub(s.Start)/s.Step) + 1
+				points += n
+				if tt.maxPoints > 0 && n > tt.maxPoints {
+					t.Errorf("shard %d has %d points, over %d", i, n, tt.maxPoints)
+				}
+				if s.Start.Sub(tt.r.Start)%tt.r.Step != 0 {
+					t.Errorf("shard %d starts at %s, not aligned to the step", i, s.Start)
+				}
+				if i > 0 && !s.Start.Equal(shards[i-1].End.Add(s.Step)) {
+					t.Errorf("shard %d starts at %s, want one step after %s", i, s.Start, shards[i-1].End)
+				}
+			}
+			if want := int(tt.r.End.Sub(tt.r.Start)/tt.r.Step) + 1; points != want {
+				t.Errorf("shards hold %d points, want %d", points, want)
+			}
+		})
+	}
+}
+
+func TestMergeMatrices(t *testing.T) {
+	a := model.Metric{"pod": "a"}
+	b := model.Metric{"pod": "b"}
+	parts := []model.Matrix{
+		{{Metric: a, Values: []model.SamplePair{{Timestamp: 60000, Value: 1}}}},
+		{
+			{Metric: b, Values: []model.SamplePair{{Timestamp: 120000, Value: 5}}},
+			{Metric: a, Values: []model.SamplePair{{Timestamp: 120000, Value: 2}}},
+		},
+	}
+
+	merged := mergeMatrices(parts)
+	if len(merged) != 2 {
+		t.Fatalf("got %d series, want 2", len(merged))
+	}
+	if got := merged[0].Values; merged[0].Metric.Equal(a) && (len(got) != 2 || got[0].Value != 1 || got[1].Value != 2) {
+		t.Errorf("pod a values = %v, want [1 2] in time order", got)
+	}
+	if len(parts[0][0].Values) != 1 {
+		t.Errorf("merging modified its input")
+	}
+}
+
+func TestSamplePoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   time.Duration
+		limits QueryLimits
+		want   int
+	}{
+		{name: "sample range limit", step: time.Minute, limits: DefaultQueryLimits, want: 120},
+		{name: "points limit", step: time.Second, limits: DefaultQueryLimits, want: 7200},
+		{name: "at least one step", step: 6 * time.Hour, limits: DefaultQueryLimits, want: 1},
+		{name: "no sample range limit", step: time.Minute, limits: QueryLimits{MaxPoints: 100}, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := samplePoints(tt.step, tt.limits); got != tt.want {
+				t.Errorf("samplePoints(%s) = %d, want %d", tt.step, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// internal/source/replay.go
+
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Names of the recorded series, the results of the Prometheus source queries of the same name
+const (
+	ReplayCPU                  = "cpu"
+	ReplayRAM                  = "ram"
+	ReplayCPURequest           = "cpuRequest"
+	ReplayRAMRequest           = "ramRequest"
+	ReplayPodInfo              = "podInfo"
+	ReplayNodeLabels           = "nodeLabels"
+	ReplayNodeCPU              = "nodeCPU"
+	ReplayNodeMemory           = "nodeMemory"
+	ReplayNamespaceLabels      = "namespaceLabels"
+	ReplayNamespaceAnnotations = "namespaceAnnotations"
+	// replayContainerSuffix marks the per-container results of cpu, ram, cpuRequest and ramRequest
+	replayContainerSuffix = "Container"
+)
+
+// Replay serves series recorded from Prometheus instead of querying it, e.g. to calculate the costs of an
+// incident from captured data. Each series is a file of the replay directory named after it (see the Replay*
+// names): <name>.json holds a query response or its result (matrix or vector), <name>.prom or <name>.txt holds
+// samples in the Prometheus text format with timestamps, cAdvisor series carrying the labels of schema.
+// Samples are averaged over each requested step, so a recording replays exactly at its own step and
+// approximately at coarser ones. Only rate accounting is replayed.
+type Replay struct {
+	series map[string]model.Matrix
+	schema prom.LabelSchema
+}
+
+// NewReplay loads every recorded series of a directory
+func NewReplay(dir string, schema prom.LabelSchema) (*Replay, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading replay directory %s: %w", dir, err)
+	}
+	r := &Replay{series: make(map[string]model.Matrix), schema: schema}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".json" && ext != ".prom" && ext != ".txt") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading replay file %s: %w", path, err)
+		}
+		var matrix model.Matrix
+		if ext == ".json" {
+			matrix, err = parseRecordedJSON(data)
+		} else {
+			matrix, err = parseTextFormat(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing replay file %s: %w", path, err)
+		}
+		r.series[strings.TrimSuffix(entry.Name(), ext)] = matrix
+	}
+	if len(r.series) == 0 {
+		return nil, fmt.Errorf("no recorded series in replay directory %s", dir)
+	}
+	slog.Info("Replay series loaded", "dir", dir, "series", len(r.series))
+	return r, nil
+}
+
+// Usage replays the cpu and ram series (cpuContainer and ramContainer by container)
+func (r *Replay) Usage(_ context.Context, q Query) (Series, Series, error) {
+	if q.Accounting == types.UsageAccountingExact {
+		return Series{}, Series{}, fmt.Errorf("replay serves rate accounting only")
+	}
+	cpu, err := r.resampled(containerName(ReplayCPU, q), q)
+	if err != nil {
+		return Series{}, Series{}, err
+	}
+	ram, err := r.resampled(containerName(ReplayRAM, q), q)
+	if err != nil {
+		return Series{}, Series{}, err
+	}
+	return Series{Steps: r.schema.ParseCPUUsageSteps(cpu, q.Step, q.ByContainer)}, Series{Steps: r.schema.ParseRAMUsageSteps(ram, q.Step, q.ByContainer)}, nil
+}
+
+// Requests replays the cpuRequest and ramRequest series (cpuRequestContainer and ramRequestContainer by container)
+func (r *Replay) Requests(_ context.Context, q Query) (Series, Series, error) {
+	cpu, err := r.resampled(containerName(ReplayCPURequest, q), q)
+	if err != nil {
+		return Series{}, Series{}, err
+	}
+	ram, err := r.resampled(containerName(ReplayRAMRequest, q), q)
+	if err != nil {
+		return Series{}, Series{}, err
+	}
+	parse := prom.ParseRequestSteps
+	if q.ByContainer {
+		parse = prom.ParseRequestContainerSteps
+	}
+	return Series{Steps: parse(cpu, q.Step)}, Series{Steps: parse(ram, q.Step)}, nil
+}
+
+// Nodes replays the podInfo, nodeLabels, nodeCPU and nodeMemory series
+func (r *Replay) Nodes(_ context.Context, q Query) (NodeInfo, error) {
+	info := NodeInfo{PodNodes: map[string]map[model.Time]string{}, Labels: map[string]map[string]string{}}
+	var errs []error
+	if podInfo, err := r.resampled(ReplayPodInfo, q); err != nil {
+		errs = append(errs, err)
+	} else {
+		info.PodNodes = prom.ParsePodNodes(podInfo)
+	}
+	if nodeLabels, err := r.resampled(ReplayNodeLabels, q); err != nil {
+		errs = append(errs, err)
+	} else {
+		info.Labels = prom.ParseNodeLabels(nodeLabels)
+	}
+	nodeCPU, cpuErr := r.resampled(ReplayNodeCPU, q)
+	nodeMemory, memoryErr := r.resampled(ReplayNodeMemory, q)
+	if err := errors.Join(cpuErr, memoryErr); err != nil {
+		errs = append(errs, err)
+	} else {
+		info.CPUCores = prom.ParseNodeSeries(nodeCPU)
+		info.RAMBytes = prom.ParseNodeSeries(nodeMemory)
+	}
+	return info, errors.Join(errs...)
+}
+
+// NamespaceMetadata replays the namespaceLabels and/or namespaceAnnotations series, each namespace taking
+// the labels of its series with the latest sample at or before at
+func (r *Replay) NamespaceMetadata(_ context.Context, at time.Time, labels, annotations bool) (map[string]types.NamespaceMetadata, error) {
+	namespaceMeta := make(map[string]types.NamespaceMetadata)
+	var errs []error
+	if labels {
+		if matrix, ok := r.series[ReplayNamespaceLabels]; !ok {
+			errs = append(errs, fmt.Errorf("series %s is not recorded", ReplayNamespaceLabels))
+		} else {
+			for namespace, labels := range prom.ParseNamespaceMetadata(vectorAt(matrix, at), "label_") {
+				meta := namespaceMeta[namespace]
+				meta.Labels = labels
+				namespaceMeta[namespace] = meta
+			}
+		}
+	}
+	if annotations {
+		if matrix, ok := r.series[ReplayNamespaceAnnotations]; !ok {
+			errs = append(errs, fmt.Errorf("series %s is not recorded", ReplayNamespaceAnnotations))
+		} else {
+			for namespace, annotations := range prom.ParseNamespaceMetadata(vectorAt(matrix, at), "annotation_") {
+				meta := namespaceMeta[namespace]
+				meta.Annotations = annotations
+				namespaceMeta[namespace] = meta
+			}
+		}
+	}
+	return namespaceMeta, errors.Join(errs...)
+}
+
+// containerName returns the name of the per-container recording of a series when the query is by container
+func containerName(name string, q Query) string {
+	if q.ByContainer {
+		return name + replayContainerSuffix
+	}
+	return name
+}
+
+// resampled returns a recorded series averaged over every step of the query, steps without samples are left out
+func (r *Replay) resampled(name string, q Query) (model.Matrix, error) {
+	matrix, ok := r.series[name]
+	if !ok {
+		return nil, fmt.Errorf("series %s is not recorded", name)
+	}
+	start, end := model.TimeFromUnixNano(q.Start.UnixNano()), model.TimeFromUnixNano(q.End.UnixNano())
+	stepMillis := int64(q.Step / time.Millisecond)
+	if stepMillis <= 0 {
+		return nil, fmt.Errorf("invalid step %s", q.Step)
+	}
+
+	resampled := make(model.Matrix, 0, len(matrix))
+	for _, stream := range matrix {
+		sums := make(map[model.Time]float64)
+		counts := make(map[model.Time]int)
+		for _, pair := range stream.Values {
+			if pair.Timestamp <= start || pair.Timestamp > end {
+				continue
+			}
+			// Steps are left-open like range selectors: a sample on a step end belongs to that step
+			offset := int64(pair.Timestamp - start)
+			ts := start + model.Time((offset+stepMillis-1)/stepMillis*stepMillis)
+			sums[ts] += float64(pair.Value)
+			counts[ts]++
+		}
+		if len(sums) == 0 {
+			continue
+		}
+		values := make([]model.SamplePair, 0, len(sums))
+		for ts, sum := range sums {
+			values = append(values, model.SamplePair{Timestamp: ts, Value: model.SampleValue(sum / float64(counts[ts]))})
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i].Timestamp < values[j].Timestamp })
+		resampled = append(resampled, &model.SampleStream{Metric: stream.Metric, Values: values})
+	}
+	return resampled, nil
+}
+
+// vectorAt returns the latest sample at or before at of every recorded stream that has one
+func vectorAt(matrix model.Matrix, at time.Time) model.Vector {
+	ts := model.TimeFromUnixNano(at.UnixNano())
+	var vector model.Vector
+	for _, stream := range matrix {
+		var latest *model.SamplePair
+		for i, pair := range stream.Values {
+			if pair.Timestamp <= ts && (latest == nil || pair.Timestamp > latest.Timestamp) {
+				latest = &stream.Values[i]
+			}
+		}
+		if latest != nil {
+			vector = append(vector, &model.Sample{Metric: stream.Metric, Value: latest.Value, Timestamp: latest.Timestamp})
+		}
+	}
+	// Namespaces relabelled over time keep the labels of their latest series
+	sort.SliceStable(vector, func(i, j int) bool { return vector[i].Timestamp < vector[j].Timestamp })
+	return vector
+}
+
+// parseRecordedJSON reads a Prometheus query response (/api/v1/query_range or /api/v1/query) or its bare result
+func parseRecordedJSON(data []byte) (model.Matrix, error) {
+	var response struct {
+		Data struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	trimmed := bytes.TrimSpace(data)
+	resultType, result := "matrix", json.RawMessage(trimmed)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		if err := json.Unmarshal(trimmed, &response); err != nil {
+			return nil, err
+		}
+		resultType, result = response.Data.ResultType, response.Data.Result
+	}
+
+	switch resultType {
+	case "matrix":
+		var matrix model.Matrix
+		if err := json.Unmarshal(result, &matrix); err != nil {
+			return nil, err
+		}
+		return matrix, nil
+	case "vector":
+		var vector model.Vector
+		if err := json.Unmarshal(result, &vector); err != nil {
+			return nil, err
+		}
+		matrix := make(model.Matrix, 0, len(vector))
+		for _, sample := range vector {
+			matrix = append(matrix, &model.SampleStream{Metric: sample.Metric, Values: []model.SamplePair{{Timestamp: sample.Timestamp, Value: sample.Value}}})
+		}
+		return matrix, nil
+	}
+	return nil, fmt.Errorf("unsupported result type '%s' (matrix, vector)", resultType)
+}
+
+// parseTextFormat reads samples in the Prometheus text format, every sample must carry a timestamp in
+// milliseconds. The metric name is kept as __name__.
+func parseTextFormat(data []byte) (model.Matrix, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	streams := make(map[model.Fingerprint]*model.SampleStream)
+	var matrix model.Matrix
+	for name, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.TimestampMs == nil {
+				return nil, fmt.Errorf("sample of %s without a timestamp", name)
+			}
+		}
+		samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{}, family)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			fp := sample.Metric.Fingerprint()
+			stream, ok := streams[fp]
+			if !ok {
+				stream = &model.SampleStream{Metric: sample.Metric}
+				streams[fp] = stream
+				matrix = append(matrix, stream)
+			}
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: sample.Timestamp, Value: sample.Value})
+		}
+	}
+	sort.Sort(matrix)
+	for _, stream := range matrix {
+		sort.Slice(stream.Values, func(i, j int) bool { return stream.Values[i].Timestamp < stream.Values[j].Timestamp })
+	}
+	return matrix, nil
+}
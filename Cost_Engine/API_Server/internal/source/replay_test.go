@@ -0,0 +1,78 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestParseTextFormat(t *testing.T) {
+	data := []byte(`# TYPE kube_node_labels gauge
+kube_node_labels{node="node-b",} 1 1735689660000
+kube_node_labels{node="node-a",label_team="a \"b\", c"} 1 1735689720500
+kube_node_labels{node="node-a",label_team="a \"b\", c"} 1 1735689660000
+`)
+	matrix, err := parseTextFormat(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matrix) != 2 {
+		t.Fatalf("got %d series, want 2", len(matrix))
+	}
+	var a *model.SampleStream
+	for _, stream := range matrix {
+		if stream.Metric["node"] == "node-a" {
+			a = stream
+		}
+	}
+	if a == nil {
+		t.Fatalf("series = %v, want one of node-a", matrix)
+	}
+	if got, want := a.Metric["label_team"], model.LabelValue(`a "b", c`); got != want {
+		t.Errorf("label_team = %q, want %q", got, want)
+	}
+	if a.Metric[model.MetricNameLabel] != "kube_node_labels" {
+		t.Errorf("metric name = %q, want kube_node_labels", a.Metric[model.MetricNameLabel])
+	}
+	if len(a.Values) != 2 || a.Values[0].Timestamp != model.Time(1735689660000) || a.Values[1].Timestamp != model.Time(1735689720500) {
+		t.Errorf("node-a samples = %v, want 2 in order with the second at 1735689720.5", a.Values)
+	}
+
+	for _, bad := range []string{`m{a="b"} 1`, `m{a="b} 1 2`, `m 1 x`, `m 1 1735689660.5`} {
+		if _, err := parseTextFormat([]byte(bad + "\n")); err == nil {
+			t.Errorf("parseTextFormat(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestReplayResample(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) model.Time { return model.TimeFromUnixNano(start.Add(d).UnixNano()) }
+	r := &Replay{series: map[string]model.Matrix{
+		ReplayCPU: {{Metric: model.Metric{"pod": "a"}, Values: []model.SamplePair{
+			{Timestamp: at(0), Value: 100}, // before the window
+			{Timestamp: at(time.Minute), Value: 1},
+			{Timestamp: at(2 * time.Minute), Value: 3},
+			{Timestamp: at(4 * time.Minute), Value: 5},
+		}}},
+	}}
+
+	matrix, err := r.resampled(ReplayCPU, Query{Start: start, End: start.Add(4 * time.Minute), Step: 2 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.SamplePair{{Timestamp: at(2 * time.Minute), Value: 2}, {Timestamp: at(4 * time.Minute), Value: 5}}
+	if len(matrix) != 1 || len(matrix[0].Values) != len(want) {
+		t.Fatalf("resampled = %v, want %v", matrix, want)
+	}
+	for i, pair := range matrix[0].Values {
+		if !pair.Equal(&want[i]) {
+			t.Errorf("sample %d = %v, want %v", i, pair, want[i])
+		}
+	}
+
+	if _, err := r.resampled(ReplayRAM, Query{Start: start, End: start.Add(time.Hour), Step: time.Minute}); err == nil {
+		t.Error("resampling an unrecorded series succeeded, want an error")
+	}
+}
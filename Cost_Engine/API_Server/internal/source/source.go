@@ -0,0 +1,60 @@
+// internal/source/source.go
+
+package source
+
+import (
+	"context"
+	"time"
+
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/types"
+
+	prometheusAPI "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// MetricsSource provides the usage, requests and node information the calculator prices
+type MetricsSource interface {
+	// Usage returns the CPU core-seconds and RAM byte-seconds used per pod (or container) and step
+	Usage(ctx context.Context, q Query) (cpu, ram Series, err error)
+	// Requests returns the CPU core-seconds and RAM byte-seconds requested per running pod (or container) and step
+	Requests(ctx context.Context, q Query) (cpu, ram Series, err error)
+	// Nodes returns where pods ran and what the nodes offered. Parts that could not be read are left
+	// empty and reported in the error, the others are still returned.
+	Nodes(ctx context.Context, q Query) (NodeInfo, error)
+	// NamespaceMetadata returns the labels and/or annotations of every namespace at a time. Parts that
+	// could not be read are left empty and reported in the error.
+	NamespaceMetadata(ctx context.Context, at time.Time, labels, annotations bool) (map[string]types.NamespaceMetadata, error)
+}
+
+// Query selects the steps of a window: each sample covers the step ending at its timestamp,
+// from Start+Step to End
+type Query struct {
+	Start, End time.Time
+	Step       time.Duration
+	// ByContainer keys usage and requests by namespace/pod/container instead of namespace/pod
+	ByContainer bool
+	// Accounting selects how usage is derived from the cAdvisor samples
+	Accounting types.UsageAccounting
+}
+
+// Range returns the range the samples of the query are evaluated over
+func (q Query) Range() prometheusAPI.Range {
+	return prometheusAPI.Range{Start: q.Start.Add(q.Step), End: q.End, Step: q.Step}
+}
+
+// Series holds an amount per pod (or container) and step, complete except over the Missing windows
+type Series struct {
+	Steps   prom.PodStepSeries
+	Missing []types.Window
+}
+
+// NodeInfo holds the nodes pods ran on and the labels and capacity of the nodes
+type NodeInfo struct {
+	// PodNodes maps namespace/pod -> timestamp -> node
+	PodNodes map[string]map[model.Time]string
+	// Labels maps node -> kube_node_labels labels
+	Labels map[string]map[string]string
+	// CPUCores and RAMBytes map node -> timestamp -> capacity, both are empty unless both could be read
+	CPUCores, RAMBytes map[string]map[model.Time]float64
+}
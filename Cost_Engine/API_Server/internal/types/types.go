@@ -1,21 +1,230 @@
 // types/types.go
 package types
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// ClusterConfig define the clusters whose costs are calculated, each with its own Prometheus
+type ClusterConfig struct {
+	Clusters []ClusterSource `yaml:"clusters"`
+}
+
+// ClusterSource define a cluster and where its metrics are queried
+type ClusterSource struct {
+	Name              string `yaml:"name"`
+	PrometheusAddress string `yaml:"prometheusAddress"`
+	// PricingFile prices the nodes of this cluster, the global pricing file applies if empty.
+	// Its tieredPricing, idleCostPolicy, billingMode and currency are ignored, the global ones apply to every cluster.
+	PricingFile string `yaml:"pricingFile,omitempty"`
+	// LabelSchema of the cAdvisor series of this cluster, the global one applies if unset
+	LabelSchema *LabelSchemaConfig `yaml:"labelSchema,omitempty"`
+}
+
+// LabelSchemaConfig define which labels of the cAdvisor series carry the Kubernetes namespace, pod and container
+type LabelSchemaConfig struct {
+	// Profile is standalone (cAdvisor DaemonSet, default), kubelet (the kubelet /metrics/cadvisor) or custom
+	Profile LabelSchemaProfile `yaml:"profile"`
+	// Labels of a custom profile, the container label is only needed for per-container costs
+	NamespaceLabel string `yaml:"namespaceLabel"`
+	PodLabel       string `yaml:"podLabel"`
+	ContainerLabel string `yaml:"containerLabel"`
+	// ContainerMatchers are PromQL label matchers keeping only container series (no pod cgroup or sandbox)
+	// in RAM queries, e.g. container!="",container!="POD"
+	ContainerMatchers string `yaml:"containerMatchers"`
+}
+
+// LabelSchemaProfile define a known layout of cAdvisor labels
+type LabelSchemaProfile string
+
+const (
+	// LabelSchemaStandalone is the cAdvisor DaemonSet of Metric_Collector: container_label_io_kubernetes_* labels
+	LabelSchemaStandalone LabelSchemaProfile = "standalone"
+	// LabelSchemaKubelet is the cAdvisor embedded in the kubelet: namespace, pod and container labels
+	LabelSchemaKubelet LabelSchemaProfile = "kubelet"
+	// LabelSchemaCustom reads the labels from the configuration
+	LabelSchemaCustom LabelSchemaProfile = "custom"
+)
 
 // PricingConfig define pricing configuration for CPU and RAM
 type PricingConfig struct {
+	// Prices in effect whenever no Schedule entry applies
+	Prices `yaml:",inline"`
+	// Schedule lists prices in effect over given periods, e.g. one entry per month when prices change
+	Schedule []PriceEntry `yaml:"schedule"`
+	// CapacityTypeLabels are the node labels read (in order) for the capacity type, built-in list if empty
+	CapacityTypeLabels []string `yaml:"capacityTypeLabels"`
+	// TieredPricing replaces the list cost of a resource with volume tiers for the matching tenant groups
+	TieredPricing []TierPolicy `yaml:"tieredPricing"`
+	// How the cost of unused node capacity is reported (none, separate, distribute)
+	IdleCostPolicy IdleCostPolicy `yaml:"idleCostPolicy"`
+	// What pods are charged on (usage, request, max), can be overridden per request
+	BillingMode BillingMode `yaml:"billingMode"`
+	// Currency of all prices, reported in v2 responses (default USD)
+	Currency string `yaml:"currency"`
+	// Version is the hash of the parsed settings assigned when the file is loaded, reported with every cost
+	Version string `yaml:"-"`
+	// Add GPU and other resources if needed
+}
+
+// Prices define the CPU and RAM rates
+type Prices struct {
 	DefaultCPUPricePerHour   float64            `yaml:"defaultCPUPricePerHour"`
 	CPUPriceByInstanceType   map[string]float64 `yaml:"cpuPriceByInstanceType"`
 	DefaultRAMPricePerGBHour float64            `yaml:"defaultRAMPricePerGBHour"`
 	RAMPriceByInstanceType   map[string]float64 `yaml:"ramPriceByInstanceType"`
-	// Add GPU and other resources if needed
+	// Whole-node hourly price, split into per-core and per-GiB rates from node capacity
+	NodePriceByInstanceType map[string]float64 `yaml:"nodePriceByInstanceType"`
+	// Cost of one CPU core relative to one GiB of RAM when splitting a node price
+	CPUToRAMCostRatio float64 `yaml:"cpuToRAMCostRatio"`
+	// Pricing of nodes by capacity type (e.g. spot), nodes of other or unknown types pay the prices above
+	CapacityTypes map[string]CapacityTypePricing `yaml:"capacityTypes"`
+}
+
+// CapacityTypePricing define how nodes of a capacity type are priced relative to on-demand nodes
+type CapacityTypePricing struct {
+	// Whole-node hourly price per instance type for this capacity type, split like NodePriceByInstanceType
+	NodePriceByInstanceType map[string]float64 `yaml:"nodePriceByInstanceType"`
+	// PriceMultiplier scales the on-demand rates when no node price is set for the instance type (default 1)
+	PriceMultiplier float64 `yaml:"priceMultiplier"`
+}
+
+// PriceEntry define prices in effect from EffectiveFrom (inclusive) to EffectiveTo (exclusive, open-ended if unset).
+// Prices left unset in the entry fall back to the top-level ones.
+type PriceEntry struct {
+	EffectiveFrom time.Time `yaml:"effectiveFrom"`
+	EffectiveTo   time.Time `yaml:"effectiveTo"`
+	Prices        `yaml:",inline"`
+}
+
+// Resolve returns the entry prices with unset fields taken from base
+func (e PriceEntry) Resolve(base Prices) Prices {
+	resolved := e.Prices
+	if resolved.DefaultCPUPricePerHour == 0 {
+		resolved.DefaultCPUPricePerHour = base.DefaultCPUPricePerHour
+	}
+	if resolved.DefaultRAMPricePerGBHour == 0 {
+		resolved.DefaultRAMPricePerGBHour = base.DefaultRAMPricePerGBHour
+	}
+	if resolved.CPUPriceByInstanceType == nil {
+		resolved.CPUPriceByInstanceType = base.CPUPriceByInstanceType
+	}
+	if resolved.RAMPriceByInstanceType == nil {
+		resolved.RAMPriceByInstanceType = base.RAMPriceByInstanceType
+	}
+	if resolved.NodePriceByInstanceType == nil {
+		resolved.NodePriceByInstanceType = base.NodePriceByInstanceType
+	}
+	if resolved.CPUToRAMCostRatio == 0 {
+		resolved.CPUToRAMCostRatio = base.CPUToRAMCostRatio
+	}
+	if resolved.CapacityTypes == nil {
+		resolved.CapacityTypes = base.CapacityTypes
+	}
+	return resolved
+}
+
+// Contains reports whether t falls within the entry period
+func (e PriceEntry) Contains(t time.Time) bool {
+	return !t.Before(e.EffectiveFrom) && (e.EffectiveTo.IsZero() || t.Before(e.EffectiveTo))
+}
+
+// TierPolicy define volume tiers for one resource, the first policy matching a tenant and resource applies
+type TierPolicy struct {
+	// Tenants the policy applies to, all tenant groups if empty
+	Tenants []string `yaml:"tenants"`
+	// Resource is cpu (core-hours) or ram (GiB-hours)
+	Resource string `yaml:"resource"`
+	// Period over which quantities accumulate towards the thresholds (window, day, month)
+	Period TierPeriod `yaml:"period"`
+	// Tiers in increasing order, the last one may leave UpTo unset to cover everything above
+	Tiers []PriceTier `yaml:"tiers"`
+}
+
+// PriceTier define the price per unit of the quantity up to UpTo (cumulative over the period)
+type PriceTier struct {
+	UpTo  float64 `yaml:"upTo"`
+	Price float64 `yaml:"price"`
+}
+
+// TierPeriod define over which period tier thresholds are counted
+type TierPeriod string
+
+const (
+	// TierPeriodWindow counts thresholds over each requested window on its own
+	TierPeriodWindow TierPeriod = "window"
+	// TierPeriodDay counts thresholds from the start of the UTC day
+	TierPeriodDay TierPeriod = "day"
+	// TierPeriodMonth counts thresholds from the start of the UTC month
+	TierPeriodMonth TierPeriod = "month"
+)
+
+// Start returns the start of the period containing t, or t itself for TierPeriodWindow
+func (p TierPeriod) Start(t time.Time) time.Time {
+	t = t.UTC()
+	switch p {
+	case TierPeriodDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case TierPeriodMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return t
+	}
+}
+
+// Next returns the start of the period after the one containing t, or t itself for TierPeriodWindow
+func (p TierPeriod) Next(t time.Time) time.Time {
+	switch p {
+	case TierPeriodDay:
+		return p.Start(t).AddDate(0, 0, 1)
+	case TierPeriodMonth:
+		return p.Start(t).AddDate(0, 1, 0)
+	default:
+		return t
+	}
+}
+
+// GroupingConfig define ordered rules mapping namespaces to tenant groups
+type GroupingConfig struct {
+	// Version identifies the rule set, reported with every cost response
+	Version string `yaml:"version"`
+	// DefaultTenant receives namespaces no rule matches
+	DefaultTenant string         `yaml:"defaultTenant"`
+	Rules         []GroupingRule `yaml:"rules"`
+}
+
+// GroupingRule define one grouping rule, exactly one matcher must be set
+type GroupingRule struct {
+	// NamespaceRegex matches the whole namespace name, Tenant is expanded with its capture groups ($1, ${name})
+	NamespaceRegex string `yaml:"namespaceRegex,omitempty"`
+	Tenant         string `yaml:"tenant,omitempty"`
+	// Namespaces maps exact namespace names to tenants
+	Namespaces map[string]string `yaml:"namespaces,omitempty"`
+	// NamespaceLabel uses the value of this namespace label as tenant (kube_namespace_labels)
+	NamespaceLabel string `yaml:"namespaceLabel,omitempty"`
+	// NamespaceAnnotation uses the value of this namespace annotation as tenant (kube_namespace_annotations)
+	NamespaceAnnotation string `yaml:"namespaceAnnotation,omitempty"`
+}
+
+// NamespaceMetadata holds the labels and annotations of a namespace, keyed by their kube-state-metrics
+// names (label_billing_tenant, annotation_billing_example_com_tenant)
+type NamespaceMetadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
 }
 
 // PodCPUCost define cost for a pod
 type PodCost struct {
-	Namespace    string  `json:"namespace"`
-	Pod          string  `json:"pod"`
+	// Cluster the pod ran in, empty with a single unnamed Prometheus
+	Cluster   string   `json:"cluster,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Namespace string   `json:"namespace"`
+	Pod       string   `json:"pod"`
+	Container string   `json:"container,omitempty"`
+	Nodes     []string `json:"nodes,omitempty"`
+	// CapacityType of the nodes the pod ran on (e.g. spot, on-demand), comma-separated if it ran on several
+	CapacityType string  `json:"capacityType,omitempty"`
 	Window       Window  `json:"window"`
 	CPUCost      float64 `json:"cpuCost"`
 	CPUCoreHours float64 `json:"cpuCoreHours"`
@@ -23,10 +232,358 @@ type PodCost struct {
 	RAMCost     float64 `json:"ramCost"`
 	RAMGiBHours float64 `json:"ramGiBHours"`
 
+	// Requested resources (kube_pod_container_resource_requests) and the amounts charged under BillingMode
+	BillingMode         BillingMode `json:"billingMode,omitempty"`
+	CPURequestCoreHours float64     `json:"cpuRequestCoreHours"`
+	CPUBilledCoreHours  float64     `json:"cpuBilledCoreHours"`
+	RAMRequestGiBHours  float64     `json:"ramRequestGiBHours"`
+	RAMBilledGiBHours   float64     `json:"ramBilledGiBHours"`
+
 	TotalCost float64 `json:"totalCost"`
+
+	// Idle marks a row holding the unused capacity of a node (Pod is the node name)
+	Idle bool `json:"idle,omitempty"`
+	// PricingVersion of the pricing config that produced the row, comma-separated when a reload happened within the window
+	PricingVersion string `json:"pricingVersion,omitempty"`
 	// Errors    []string `json:"errors,omitempty"`
 }
 
+// NodeCapacity define allocatable resources of a node as reported by node-exporter
+type NodeCapacity struct {
+	CPUCores float64 `json:"cpuCores"`
+	RAMBytes float64 `json:"ramBytes"`
+}
+
+// IdleCostPolicy define how idle node capacity cost is allocated
+type IdleCostPolicy string
+
+const (
+	// IdleCostNone ignores idle capacity (default)
+	IdleCostNone IdleCostPolicy = "none"
+	// IdleCostSeparate reports idle capacity as its own IdleGroupKey group
+	IdleCostSeparate IdleCostPolicy = "separate"
+	// IdleCostDistribute spreads idle capacity across groups proportionally to their usage cost
+	IdleCostDistribute IdleCostPolicy = "distribute"
+)
+
+// BillingMode define which amount of a resource pods are charged on
+type BillingMode string
+
+const (
+	// BillingModeUsage charges measured usage (default)
+	BillingModeUsage BillingMode = "usage"
+	// BillingModeRequest charges resource requests
+	BillingModeRequest BillingMode = "request"
+	// BillingModeMax charges max(request, usage) per step
+	BillingModeMax BillingMode = "max"
+)
+
+// ParseBillingMode validates a billing mode name
+func ParseBillingMode(s string) (BillingMode, error) {
+	switch mode := BillingMode(s); mode {
+	case BillingModeUsage, BillingModeRequest, BillingModeMax:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid billing mode '%s' (usage, request, max)", s)
+}
+
+// UsageAccounting define how CPU and RAM usage are derived from the cAdvisor samples
+type UsageAccounting string
+
+const (
+	// UsageAccountingRate multiplies rate() and avg_over_time() over each step by the step length (default)
+	UsageAccountingRate UsageAccounting = "rate"
+	// UsageAccountingExact integrates the samples: CPU counter increases between scrapes and RAM samples
+	// weighted by the scrape interval, so totals do not depend on the step
+	UsageAccountingExact UsageAccounting = "exact"
+)
+
+// ParseUsageAccounting validates a usage accounting mode name
+func ParseUsageAccounting(s string) (UsageAccounting, error) {
+	switch mode := UsageAccounting(s); mode {
+	case UsageAccountingRate, UsageAccountingExact:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid usage accounting '%s' (rate, exact)", s)
+}
+
+// IdleGroupKey is the group (and namespace) under which idle node capacity is reported
+const IdleGroupKey = "__idle__"
+
+// CostPoint define the cost accumulated during the step ending at Timestamp
+type CostPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	CPUCost   float64   `json:"cpuCost"`
+	RAMCost   float64   `json:"ramCost"`
+	TotalCost float64   `json:"totalCost"`
+}
+
+// CostSeries define the per-step cost of a tenant, or of a namespace when Namespace is set
+type CostSeries struct {
+	Tenant    string      `json:"tenant"`
+	Namespace string      `json:"namespace,omitempty"`
+	Points    []CostPoint `json:"points"`
+}
+
+// CostTimeSeries define per-step costs over a window, every series has one point per step
+type CostTimeSeries struct {
+	Window         Window       `json:"window"`
+	Step           string       `json:"step"`
+	PricingVersion string       `json:"pricingVersion"`
+	Tenants        []CostSeries `json:"tenants"`
+	Namespaces     []CostSeries `json:"namespaces"`
+}
+
+// PodCostReport define pod (or container) level cost rows over a window
+type PodCostReport struct {
+	Window Window    `json:"window"`
+	Level  string    `json:"level"`
+	Items  []PodCost `json:"items"`
+}
+
+// CostReportV2 define the typed /v2/costs response
+type CostReportV2 struct {
+	APIVersion      string                 `json:"apiVersion"`
+	Window          Window                 `json:"window"`
+	Step            string                 `json:"step"`
+	Currency        string                 `json:"currency"`
+	BillingMode     BillingMode            `json:"billingMode"`
+	IdleCostPolicy  IdleCostPolicy         `json:"idleCostPolicy"`
+	GroupingVersion string                 `json:"groupingVersion"`
+	PricingVersion  string                 `json:"pricingVersion"`
+	Tenants         map[string]*TenantCost `json:"tenants"`
+	// Missing lists the windows Prometheus failed to answer for, left out of the costs
+	Missing []Window `json:"missing,omitempty"`
+}
+
+// TenantCost define the cost of a tenant group over a window
+type TenantCost struct {
+	Window Window `json:"window"`
+	// Total is the amount charged: the list cost of the namespaces minus Discount
+	Total      float64         `json:"total"`
+	Currency   string          `json:"currency"`
+	Namespaces []NamespaceCost `json:"namespaces"`
+	Resources  ResourceCosts   `json:"resources"`
+	// Discount is the sum of Discounts, negative when tier prices exceed list prices
+	Discount  float64        `json:"discount"`
+	Discounts []DiscountLine `json:"discounts,omitempty"`
+	// Clusters splits the list cost of the tenant per cluster, when clusters are named
+	Clusters []ClusterCost `json:"clusters,omitempty"`
+}
+
+// ClusterCost define the list cost of a tenant group in one cluster
+type ClusterCost struct {
+	Name      string        `json:"name"`
+	Total     float64       `json:"total"`
+	Resources ResourceCosts `json:"resources"`
+}
+
+// DiscountLine define the difference between the list cost of a resource and its tiered charge
+type DiscountLine struct {
+	Resource   string     `json:"resource"`
+	Period     TierPeriod `json:"period"`
+	Quantity   float64    `json:"quantity"`
+	Unit       string     `json:"unit"`
+	ListCost   float64    `json:"listCost"`
+	TieredCost float64    `json:"tieredCost"`
+	Discount   float64    `json:"discount"`
+}
+
+// NamespaceCost define the cost of a namespace within a tenant group
+type NamespaceCost struct {
+	Name      string        `json:"name"`
+	Total     float64       `json:"total"`
+	Resources ResourceCosts `json:"resources"`
+}
+
+// ResourceCosts define the cost split per resource
+type ResourceCosts struct {
+	CPU ResourceCost `json:"cpu"`
+	RAM ResourceCost `json:"ram"`
+}
+
+// ResourceCost define the billed quantity of a resource and its cost
+type ResourceCost struct {
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Cost     float64 `json:"cost"`
+}
+
+// Units of ResourceCost quantities
+const (
+	UnitCoreHours = "core-hours"
+	UnitGiBHours  = "GiB-hours"
+)
+
+// CostForecast define the projected spend of every tenant at the end of the billing period
+type CostForecast struct {
+	// Period is the billing period (current UTC month) being forecast
+	Period      Window    `json:"period"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Model       string    `json:"model"`
+	Lookback    string    `json:"lookback"`
+	Step        string    `json:"step"`
+	// Confidence of the Lower/Upper bounds
+	Confidence float64                    `json:"confidence"`
+	Currency   string                     `json:"currency"`
+	Tenants    map[string]*TenantForecast `json:"tenants"`
+}
+
+// TenantForecast define the spend of a tenant so far and projected to the end of the period, at list prices
+type TenantForecast struct {
+	// Model actually used, auto resolves to linear or dayofweek
+	Model       string  `json:"model"`
+	SpentToDate float64 `json:"spentToDate"`
+	Remaining   float64 `json:"remaining"`
+	Expected    float64 `json:"expected"`
+	Lower       float64 `json:"lower"`
+	Upper       float64 `json:"upper"`
+}
+
+// AnomalyMethod define how a step cost is compared with its rolling baseline
+type AnomalyMethod string
+
+const (
+	// AnomalyZScore scores a step by standard deviations above the baseline mean
+	AnomalyZScore AnomalyMethod = "zscore"
+	// AnomalyMAD scores a step by scaled median absolute deviations above the baseline median,
+	// so past spikes in the baseline do not hide new ones
+	AnomalyMAD AnomalyMethod = "mad"
+)
+
+// CostAnomaly define a step whose cost spiked above the rolling baseline of a tenant or namespace
+type CostAnomaly struct {
+	Tenant string `json:"tenant"`
+	// Namespace is empty for the tenant total
+	Namespace string `json:"namespace,omitempty"`
+	// Timestamp is the end of the step
+	Timestamp time.Time `json:"timestamp"`
+	Cost      float64   `json:"cost"`
+	// Baseline is the mean (zscore) or median (mad) step cost, Deviation its standard or scaled absolute deviation
+	Baseline  float64       `json:"baseline"`
+	Deviation float64       `json:"deviation"`
+	Score     float64       `json:"score"`
+	Method    AnomalyMethod `json:"method"`
+	Currency  string        `json:"currency"`
+}
+
+// AnomalyReport define the anomalies detected, oldest first
+type AnomalyReport struct {
+	Method    AnomalyMethod `json:"method"`
+	Threshold float64       `json:"threshold"`
+	Baseline  string        `json:"baseline"`
+	Step      string        `json:"step"`
+	Anomalies []CostAnomaly `json:"anomalies"`
+}
+
+// BudgetConfig define tenant budgets and where threshold alerts are sent
+type BudgetConfig struct {
+	// DefaultThresholds in percent of the budget amount, for budgets without their own (default 50, 80, 100)
+	DefaultThresholds []float64       `yaml:"defaultThresholds" json:"defaultThresholds"`
+	Webhooks          []WebhookConfig `yaml:"webhooks" json:"webhooks"`
+	Budgets           []Budget        `yaml:"budgets" json:"budgets"`
+}
+
+// WebhookConfig define a receiver of budget alerts
+type WebhookConfig struct {
+	URL string `yaml:"url" json:"url"`
+	// Format is alertmanager (POST /api/v2/alerts payload) or generic (one JSON event per threshold crossing)
+	Format WebhookFormat `yaml:"format" json:"format"`
+}
+
+// WebhookFormat define the payload sent to a webhook
+type WebhookFormat string
+
+const (
+	WebhookAlertmanager WebhookFormat = "alertmanager"
+	WebhookGeneric      WebhookFormat = "generic"
+)
+
+// Budget define a spending limit of a tenant group over a calendar month or a rolling window
+type Budget struct {
+	Name   string       `yaml:"name" json:"name"`
+	Tenant string       `yaml:"tenant" json:"tenant"`
+	Amount float64      `yaml:"amount" json:"amount"`
+	Period BudgetPeriod `yaml:"period" json:"period"`
+	// Window of a rolling budget as a Go duration (e.g. 168h)
+	Window string `yaml:"window,omitempty" json:"window,omitempty"`
+	// Thresholds in percent of Amount, the config defaults if empty
+	Thresholds []float64 `yaml:"thresholds,omitempty" json:"thresholds,omitempty"`
+	// ForecastAlert alerts when the month-end forecast exceeds Amount, monthly budgets only
+	ForecastAlert bool `yaml:"forecastAlert,omitempty" json:"forecastAlert,omitempty"`
+}
+
+// BudgetPeriod define over which period a budget is spent
+type BudgetPeriod string
+
+const (
+	// BudgetMonthly covers the current UTC calendar month
+	BudgetMonthly BudgetPeriod = "monthly"
+	// BudgetRolling covers the trailing Window
+	BudgetRolling BudgetPeriod = "rolling"
+)
+
+// BudgetStatus define the result of the last evaluation of a budget
+type BudgetStatus struct {
+	Budget
+	Spent    float64 `json:"spent"`
+	Currency string  `json:"currency"`
+	Percent  float64 `json:"percent"`
+	// EvaluatedWindow is the window the spend was calculated over, the budget's own window is a duration
+	EvaluatedWindow Window `json:"evaluatedWindow"`
+	// Crossed lists the thresholds currently exceeded
+	Crossed []float64 `json:"crossed"`
+	// Forecast is the projected spend at the end of the month, monthly budgets only
+	Forecast        *float64  `json:"forecast,omitempty"`
+	ForecastPercent *float64  `json:"forecastPercent,omitempty"`
+	EvaluatedAt     time.Time `json:"evaluatedAt"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// AuthConfig define the credentials accepted by the API server and the tenants each may read
+type AuthConfig struct {
+	// APIKeys are static tokens sent as "Authorization: Bearer <key>" or in the X-API-Key header
+	APIKeys []APIKey `yaml:"apiKeys"`
+	// JWT verifies bearer JWTs against a local JWKS file, disabled if nil
+	JWT *JWTConfig `yaml:"jwt"`
+}
+
+// APIKey define a static token and what it may read
+type APIKey struct {
+	Name string `yaml:"name"`
+	// Key is the token, or KeyFile the path of a file holding it (e.g. a mounted secret)
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"keyFile"`
+	// Role is tenant by default, Tenant is the group a tenant key reads
+	Role   AuthRole `yaml:"role"`
+	Tenant string   `yaml:"tenant"`
+}
+
+// JWTConfig define how bearer JWTs are verified and mapped to a role and tenant
+type JWTConfig struct {
+	// JWKSFile holds the public keys tokens are signed with, re-read when a token names an unknown key
+	JWKSFile string `yaml:"jwksFile"`
+	// Issuer and Audience are checked against the iss and aud claims if set
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// TenantClaim names the claim holding the tenant group, "tenant" by default
+	TenantClaim string `yaml:"tenantClaim"`
+	// RoleClaim names the claim holding the role, "role" by default, tenant if the claim is absent
+	RoleClaim string `yaml:"roleClaim"`
+}
+
+// AuthRole define what a credential may read and change
+type AuthRole string
+
+const (
+	// AuthRoleAdmin reads every tenant and manages budgets
+	AuthRoleAdmin AuthRole = "admin"
+	// AuthRoleService reads every tenant, e.g. the Payment Engine
+	AuthRoleService AuthRole = "service"
+	// AuthRoleTenant reads its own tenant only
+	AuthRoleTenant AuthRole = "tenant"
+)
+
 type GroupedCostSummary map[string]interface{}
 
 // Window time window for cost calculation
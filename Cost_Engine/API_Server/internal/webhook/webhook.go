@@ -0,0 +1,52 @@
+// internal/webhook/webhook.go
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// AlertmanagerAlert is one element of the Alertmanager POST /api/v2/alerts payload
+type AlertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Client posts JSON payloads to webhook receivers
+type Client struct {
+	client *http.Client
+}
+
+// NewClient creates a webhook client with a request timeout
+func NewClient() *Client {
+	return &Client{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Post sends payload as JSON to url, failing on transport errors and non-2xx responses
+func (c *Client) Post(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook rejected payload: %s", resp.Status)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -11,25 +12,62 @@ import (
 	"os"
 	"time"
 
+	"simple-cost-calculator/internal/anomaly"
+	"simple-cost-calculator/internal/auth"
+	"simple-cost-calculator/internal/budget"
 	"simple-cost-calculator/internal/calculator"
 	"simple-cost-calculator/internal/config"
+	"simple-cost-calculator/internal/exporter"
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/history"
+	"simple-cost-calculator/internal/metrics"
 	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
 	"simple-cost-calculator/internal/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	calc        *calculator.CostCalculator
 	logger      *slog.Logger
 	defaultStep time.Duration
+	recorder    *history.Recorder // nil when the history store is disabled
 )
 
 func main() {
 	// --- Flags ---
 	promAddr := flag.String("prometheus.address", "http://localhost:9090", "Address of Prometheus server")
+	promMaxPoints := flag.Int("prometheus.max-points", source.DefaultQueryLimits.MaxPoints, "Most points per series in one range query, longer ranges are split into shards")
+	promMaxShards := flag.Int("prometheus.max-concurrent-shards", source.DefaultQueryLimits.MaxConcurrentShards, "Most shards of a range query sent to Prometheus at once")
+	promMaxSampleRange := flag.Duration("prometheus.max-sample-range", source.DefaultQueryLimits.MaxSampleRange, "Longest range of raw samples selected by one query (exact accounting), longer ranges are split into shards")
+	replayDir := flag.String("replay.dir", "", "Directory of series recorded from Prometheus to calculate costs from instead of querying it, replaces -prometheus.address and -clusters.file if set")
+	clustersFile := flag.String("clusters.file", "", "Path to the named clusters file (YAML), each with its own Prometheus, replaces -prometheus.address if set")
 	pricingFile := flag.String("pricing.file", "configs/pricing.yaml", "Path to pricing configuration file (YAML)")
+	pricingWatch := flag.Bool("pricing.watch", true, "Reload the pricing file when it changes (SIGHUP always reloads it)")
+	groupingFile := flag.String("grouping.file", "", "Path to tenant grouping rules file (YAML), built-in ns*-user<N> rule if empty")
+	labelSchemaStr := flag.String("label-schema", string(types.LabelSchemaStandalone), "Labels of the scraped cAdvisor series: standalone (cAdvisor DaemonSet) or kubelet (kubelet /metrics/cadvisor)")
+	labelSchemaFile := flag.String("label-schema.file", "", "Path to a label schema file (YAML), e.g. for custom labels, replaces -label-schema if set")
 	stepStr := flag.String("step", "1m", "Calculation step duration (e.g., 1m, 5m, 15m)")
+	accountingStr := flag.String("accounting", string(types.UsageAccountingRate), "How usage is derived from cAdvisor samples: rate (rate/avg_over_time times the step) or exact (integrated counters and samples)")
+	scrapeInterval := flag.Duration("accounting.scrape-interval", 15*time.Second, "cAdvisor scrape interval, each RAM sample stands for one interval with -accounting=exact")
 	debug := flag.Bool("debug", false, "Enable debug logging")
 	webListenAddr := flag.String("web.listen-address", ":9991", "Address for the web server to listen on")
+	historyDB := flag.String("history.db", "", "Path to the cost history database, history is disabled if empty")
+	historyInterval := flag.Duration("history.interval", time.Hour, "Length of the intervals recorded in the history store, a multiple of -step")
+	historyBackfill := flag.Duration("history.backfill", 24*time.Hour, "How far back to record intervals when the history store is empty")
+	budgetsFile := flag.String("budgets.file", "", "Path to the tenant budgets file (YAML), also written by the budgets API, budgets are disabled if empty")
+	budgetsInterval := flag.Duration("budgets.interval", 5*time.Minute, "How often budgets are evaluated")
+	anomalyInterval := flag.Duration("anomaly.interval", 5*time.Minute, "How often step costs are checked for anomalies, anomaly detection is disabled if 0")
+	anomalyMethod := flag.String("anomaly.method", string(types.AnomalyMAD), "How step costs are compared with their baseline: mad (median absolute deviation) or zscore")
+	anomalyThreshold := flag.Float64("anomaly.threshold", 0, "Score above which a step cost is anomalous, 3.5 for mad and 3 for zscore if 0")
+	anomalyBaseline := flag.Duration("anomaly.baseline", 24*time.Hour, "Rolling window of step costs each step is compared with")
+	anomalyWebhook := flag.String("anomaly.webhook", "", "URL notified of each anomaly detected, none if empty")
+	anomalyWebhookFormat := flag.String("anomaly.webhook-format", string(types.WebhookGeneric), "Payload sent to -anomaly.webhook: generic or alertmanager")
+	authFile := flag.String("auth.file", "", "Path to the API keys and JWT verification file (YAML), every API request is unauthenticated if empty")
+	metricsInterval := flag.Duration("metrics.interval", 5*time.Minute, "How often cost metrics on /metrics are refreshed, cost metrics are disabled if 0")
 	flag.Parse()
 
 	// --- Setup Logger ---
@@ -46,6 +84,25 @@ func main() {
 		os.Exit(1)
 	}
 	defaultStep = stepDuration
+	accounting, err := types.ParseUsageAccounting(*accountingStr)
+	if err != nil {
+		logger.Error("Invalid usage accounting", "error", err)
+		os.Exit(1)
+	}
+	labelSchemaConf := &types.LabelSchemaConfig{Profile: types.LabelSchemaProfile(*labelSchemaStr)}
+	if *labelSchemaFile != "" {
+		logger.Info("Loading label schema config", "path", *labelSchemaFile)
+		labelSchemaConf, err = config.LoadLabelSchemaConfig(*labelSchemaFile)
+		if err != nil {
+			logger.Error("Error loading label schema config", "error", err)
+			os.Exit(1)
+		}
+	}
+	labelSchema, err := prom.NewLabelSchema(*labelSchemaConf)
+	if err != nil {
+		logger.Error("Invalid label schema", "error", err)
+		os.Exit(1)
+	}
 	// --- Load Pricing Config ---
 	logger.Info("Loading pricing config", "path", *pricingFile)
 	pricingConf, err := config.LoadPricingConfig(*pricingFile)
@@ -53,26 +110,195 @@ func main() {
 		logger.Error("Error loading pricing config", "error", err)
 		os.Exit(1)
 	}
-	logger.Info("Pricing config loaded successfully.")
-
-	// --- Initit Prometheus API Client ---
-	logger.Info("Connecting to Prometheus", "address", *promAddr)
-	promAPI, err := prom.NewPrometheusAPI(*promAddr)
+	logger.Info("Pricing config loaded successfully.", "version", pricingConf.Version)
 
+	// --- Load Grouping Config ---
+	groupingConf := &grouping.BuiltinConfig
+	if *groupingFile != "" {
+		logger.Info("Loading grouping config", "path", *groupingFile)
+		groupingConf, err = config.LoadGroupingConfig(*groupingFile)
+		if err != nil {
+			logger.Error("Error loading grouping config", "error", err)
+			os.Exit(1)
+		}
+	}
+	grouper, err := grouping.NewGrouper(groupingConf)
 	if err != nil {
-		logger.Error("Error creating Prometheus client", "error", err)
+		logger.Error("Invalid grouping config", "error", err)
 		os.Exit(1)
 	}
-	logger.Info("Prometheus client created.")
+	logger.Info("Grouping rules loaded successfully.", "version", grouper.Version(), "rules", len(groupingConf.Rules))
+
+	// --- Initit Metrics Sources (Prometheus API Clients or recorded series) ---
+	clusterConf := &types.ClusterConfig{Clusters: []types.ClusterSource{{PrometheusAddress: *promAddr}}}
+	var clusterSchemas map[string]prom.LabelSchema // Clusters without their own schema use labelSchema
+	if *clustersFile != "" {
+		logger.Info("Loading cluster config", "path", *clustersFile)
+		clusterConf, clusterSchemas, err = config.LoadClusterConfig(*clustersFile)
+		if err != nil {
+			logger.Error("Error loading cluster config", "error", err)
+			os.Exit(1)
+		}
+	}
+	var replay *source.Replay
+	if *replayDir != "" {
+		logger.Info("Loading recorded series, Prometheus is not queried", "dir", *replayDir)
+		replay, err = source.NewReplay(*replayDir, labelSchema)
+		if err != nil {
+			logger.Error("Error loading recorded series", "error", err)
+			os.Exit(1)
+		}
+		clusterConf = &types.ClusterConfig{Clusters: []types.ClusterSource{{}}}
+	}
+	limits := source.QueryLimits{MaxPoints: *promMaxPoints, MaxConcurrentShards: *promMaxShards, MaxSampleRange: *promMaxSampleRange}
+	var clusters []calculator.Cluster
+	for _, clusterSource := range clusterConf.Clusters {
+		cluster := calculator.Cluster{Name: clusterSource.Name}
+		if replay != nil {
+			cluster.Source = replay
+		} else {
+			logger.Info("Connecting to Prometheus", "cluster", clusterSource.Name, "address", clusterSource.PrometheusAddress)
+			promAPI, err := prom.NewPrometheusAPI(clusterSource.PrometheusAddress)
+			if err != nil {
+				logger.Error("Error creating Prometheus client", "cluster", clusterSource.Name, "error", err)
+				os.Exit(1)
+			}
+			schema, ok := clusterSchemas[clusterSource.Name]
+			if !ok {
+				schema = labelSchema
+			}
+			cluster.Source = source.NewPrometheus(promAPI, limits, schema, *scrapeInterval)
+		}
+		if clusterSource.PricingFile != "" {
+			cluster.Pricing, err = config.LoadPricingConfig(clusterSource.PricingFile)
+			if err != nil {
+				logger.Error("Error loading cluster pricing config", "cluster", clusterSource.Name, "error", err)
+				os.Exit(1)
+			}
+			logger.Info("Cluster pricing config loaded successfully.", "cluster", clusterSource.Name, "version", cluster.Pricing.Version)
+		}
+		clusters = append(clusters, cluster)
+	}
+	logger.Info("Metrics sources created.", "clusters", len(clusters))
 
 	// --- Cost Calculator ---
-	calc = calculator.NewCostCalculator(promAPI, pricingConf /*, logger*/)
+	calc = calculator.NewClusterCalculator(clusters, pricingConf, grouper /*, logger*/)
+	calc.SetUsageAccounting(accounting)
 	logger.Info("Cost calculator initialized.")
 
+	// --- Pricing Reload ---
+	err = config.WatchPricingConfig(context.Background(), *pricingFile, *pricingWatch, pricingConf.Version, calc.SetPricing)
+	if err != nil {
+		logger.Error("Error watching pricing config", "path", *pricingFile, "error", err)
+		os.Exit(1)
+	}
+	for i, clusterSource := range clusterConf.Clusters {
+		if clusters[i].Pricing == nil {
+			continue
+		}
+		name, path := clusterSource.Name, clusterSource.PricingFile
+		err = config.WatchPricingConfig(context.Background(), path, *pricingWatch, clusters[i].Pricing.Version, func(p *types.PricingConfig) { calc.SetClusterPricing(name, p) })
+		if err != nil {
+			logger.Error("Error watching cluster pricing config", "cluster", name, "path", path, "error", err)
+			os.Exit(1)
+		}
+	}
+	logger.Info("Pricing config reloads on SIGHUP.", "path", *pricingFile, "watch_file", *pricingWatch)
+
+	// --- History Store ---
+	if *historyDB != "" {
+		store, err := history.OpenStore(*historyDB, *historyInterval, defaultStep)
+		if err != nil {
+			logger.Error("Error opening history store", "error", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		recorder, err = history.NewRecorder(store, calc, *historyInterval, defaultStep, *historyBackfill)
+		if err != nil {
+			logger.Error("Invalid history config", "error", err)
+			os.Exit(1)
+		}
+		go recorder.Run(context.Background())
+		logger.Info("History recorder started.", "path", *historyDB, "interval", *historyInterval, "backfill", *historyBackfill)
+	}
+
+	// --- Cost Metrics Exporter ---
+	if *metricsInterval > 0 {
+		costExporter := exporter.NewExporter(calc, *metricsInterval, defaultStep, prometheus.DefaultRegisterer)
+		go costExporter.Run(context.Background())
+		logger.Info("Cost metrics exporter started.", "interval", *metricsInterval)
+	}
+
+	// --- Anomaly Detection ---
+	if *anomalyInterval > 0 {
+		var webhooks []types.WebhookConfig
+		if *anomalyWebhook != "" {
+			webhooks = append(webhooks, types.WebhookConfig{URL: *anomalyWebhook, Format: types.WebhookFormat(*anomalyWebhookFormat)})
+		}
+		opts := anomaly.Options{
+			Method:    types.AnomalyMethod(*anomalyMethod),
+			Threshold: *anomalyThreshold,
+			Baseline:  *anomalyBaseline,
+			// Score a series once it has a quarter of its baseline
+			MinPoints: int(*anomalyBaseline / defaultStep / 4),
+		}
+		detector, err = anomaly.NewDetector(calc, *anomalyInterval, defaultStep, opts, webhooks, prometheus.DefaultRegisterer)
+		if err != nil {
+			logger.Error("Invalid anomaly detection config", "error", err)
+			os.Exit(1)
+		}
+		go detector.Run(context.Background())
+		logger.Info("Anomaly detector started.", "method", *anomalyMethod, "baseline", *anomalyBaseline, "interval", *anomalyInterval)
+	}
+
+	// --- Budgets ---
+	if *budgetsFile != "" {
+		budgetConf, err := config.LoadBudgetConfig(*budgetsFile)
+		if err != nil {
+			logger.Error("Error loading budget config", "error", err)
+			os.Exit(1)
+		}
+		budgets = budget.NewManager(*budgetsFile, budgetConf, calculateTenantCosts, forecastTenants, defaultStep, *budgetsInterval, prometheus.DefaultRegisterer)
+		go budgets.Run(context.Background())
+		logger.Info("Budget evaluator started.", "path", *budgetsFile, "budgets", len(budgetConf.Budgets), "webhooks", len(budgetConf.Webhooks), "interval", *budgetsInterval)
+	}
+
+	// --- Authentication ---
+	if *authFile != "" {
+		authConf, err := config.LoadAuthConfig(*authFile)
+		if err != nil {
+			logger.Error("Error loading auth config", "error", err)
+			os.Exit(1)
+		}
+		authenticator, err = auth.NewAuthenticator(authConf)
+		if err != nil {
+			logger.Error("Invalid auth config", "error", err)
+			os.Exit(1)
+		}
+		logger.Info("API authentication enabled.", "path", *authFile, "api_keys", len(authConf.APIKeys), "jwt", authConf.JWT != nil)
+	} else {
+		logger.Warn("API authentication disabled, every caller reads every tenant's costs (set --auth.file)")
+	}
+
 	// --- Web Server ---
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/getcost", handleGetCost)
+	// v1 compatibility shim, new clients should use /v2/costs
+	handle(mux, "/getcost", handleGetCost)
+	handle(mux, "/v2/costs", handleCostsV2)
+	handle(mux, "/v2/costs/schema", handleCostsV2Schema)
+	handle(mux, "/costs/timeseries", handleCostTimeSeries)
+	handle(mux, "/costs/pods", handlePodCosts)
+	handle(mux, "/forecast", handleForecast)
+	if detector != nil {
+		handle(mux, "/v2/anomalies", handleAnomalies)
+	}
+	if budgets != nil {
+		handle(mux, "/v2/budgets", handleBudgets)
+		handle(mux, "/v2/budgets/{name}", handleBudget)
+	}
+	// Cost metrics carry every tenant's costs, scrapers need a service or admin credential
+	handle(mux, "/metrics", allTenantsOnly(promhttp.Handler()))
 
 	slog.Info("Starting API server with ", "address", *webListenAddr)
 
@@ -87,42 +313,38 @@ func handleGetCost(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
 
-	windowQuery := r.URL.Query().Get("window")
-	if windowQuery == "" {
-		slog.Warn("API request missing 'window' parameter")
-		http.Error(w, "Missing 'window' query parameter (e.g., ?window=5m)", http.StatusBadRequest)
+	req, err := parseCostRequest(r, time.Now())
+	if err != nil {
+		slog.Warn("API request invalid parameters", "query", r.URL.RawQuery, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	start, end, step := req.Start, req.End, req.Step
+	windowDuration := end.Sub(start)
 
-	windowDuration, err := time.ParseDuration(windowQuery)
-	if err != nil {
-		slog.Warn("API request invalid 'window' format", "input", windowQuery, "error", err) // Dùng slog.Warn
-		http.Error(w, fmt.Sprintf("Invalid 'window' duration format: %v. Use format like '5m', '1h'.", err), http.StatusBadRequest)
+	slog.Info("API request received", "window", windowDuration, "step", step, "billing_mode", req.Opts.BillingMode, "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339))
+
+	writeCostHeaders(w, req)
+
+	podCosts, pricing, err := calculatePodCosts(ctx, req)
+	if _, err = acceptPartial(w, req, err); err != nil {
+		slog.Error("Error calculating pod costs via API", "window", windowDuration, "step", step, "error", err)
+		writeCalcError(w, err)
 		return
 	}
+	w.Header().Set("X-Pricing-Version", pricingVersion(podCosts, pricing))
 
-	stepQuery := r.URL.Query().Get("step")
-	step := defaultStep
-	if stepQuery != "" {
-		stepDurationQuery, err := time.ParseDuration(stepQuery)
-		if err == nil && stepDurationQuery > 0 {
-			step = stepDurationQuery
-		} else {
-			slog.Warn("API request invalid 'step' format, using default", "input", stepQuery, "default", defaultStep, "error", err)
-			http.Error(w, fmt.Sprintf("Invalid 'step' duration format: %v", err), http.StatusBadRequest)
-			return
-		}
+	prior, err := tierUsage(ctx, req, pricing)
+	if _, err = acceptPartial(w, req, err); err != nil {
+		slog.Error("Error calculating prior tier usage via API", "error", err)
+		writeCalcError(w, err)
+		return
 	}
 
-	end := time.Now()
-	start := end.Add(-windowDuration)
-
-	slog.Info("API request received", "window", windowDuration, "step", step, "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339))
-
-	podCosts, err := calc.CalculatePodCosts(ctx, start, end, step)
-	if err != nil {
-		slog.Error("Error calculating pod costs via API", "window", windowDuration, "step", step, "error", err)
-		http.Error(w, "Internal Server Error: Failed to calculate costs.", http.StatusInternalServerError)
+	if req.Format != formatJSON {
+		tenants := calculator.TenantCosts(podCosts, pricing, prior)
+		visibleTenants(r, tenants)
+		writeCostRows(w, req.Format, namespaceCostRows(tenants))
 		return
 	}
 
@@ -136,12 +358,14 @@ func handleGetCost(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("Pod costs calculated successfully via API", "pod_count", len(podCosts))
 
-	rearrangedCosts, err := calculator.RearrangeCosts(podCosts)
+	rearrangedCosts, err := calculator.RearrangeCosts(podCosts, pricing, prior)
 	if err != nil {
 		slog.Error("Error rearranging costs via API", "error", err)
 		http.Error(w, "Internal Server Error: Failed to process results.", http.StatusInternalServerError)
 		return
 	}
+	// Tenant tokens only see their own group
+	visibleTenants(r, rearrangedCosts)
 
 	slog.Info("Costs rearranged successfully via API", "user_groups", len(rearrangedCosts))
 
@@ -152,3 +376,93 @@ func handleGetCost(w http.ResponseWriter, r *http.Request) {
 		slog.Error("Error encoding JSON response", "error", errEncode)
 	}
 }
+
+// handle registers an API handler, authenticated when enabled and instrumented with request latency metrics
+func handle(mux *http.ServeMux, path string, handler http.HandlerFunc) {
+	duration := metrics.HTTPRequestDuration.MustCurryWith(prometheus.Labels{"handler": path})
+	var h http.Handler = handler
+	if authenticator != nil {
+		h = authenticator.Middleware(h)
+	}
+	mux.Handle(path, promhttp.InstrumentHandlerDuration(duration, h))
+}
+
+// calculatePodCosts answers from the history store when enabled, otherwise straight from Prometheus, with the
+// pricing snapshot the pods were priced with
+func calculatePodCosts(ctx context.Context, req costRequest) ([]types.PodCost, *types.PricingConfig, error) {
+	if recorder != nil {
+		return recorder.PodCosts(ctx, req.Start, req.End, req.Step, req.Opts)
+	}
+	return calc.CalculatePodCosts(ctx, req.Start, req.End, req.Step, req.Opts)
+}
+
+// calculateTenantCosts returns the charged cost of every tenant over a window at the default step
+func calculateTenantCosts(ctx context.Context, start, end time.Time) (map[string]*types.TenantCost, error) {
+	req := costRequest{Start: start, End: end, Step: defaultStep}
+	podCosts, pricing, err := calculatePodCosts(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	prior, err := tierUsage(ctx, req, pricing)
+	if err != nil {
+		return nil, err
+	}
+	return calculator.TenantCosts(podCosts, pricing, prior), nil
+}
+
+// tierUsage calculates what each tenant consumed from the start of every tier period up to the request
+// start, so volume thresholds span the contiguous windows the Payment Engine bills, and in every later period
+// the request reaches into. With the history store enabled this mostly reads stored intervals. Partial results
+// are used, with a *PartialResultError.
+func tierUsage(ctx context.Context, req costRequest, pricing *types.PricingConfig) (calculator.TierUsage, error) {
+	usage := calculator.TierUsage{}
+	var missing []types.Window
+	// Tier usage is priced with the snapshot of the request
+	opts := req.Opts
+	opts.Pricing = pricing
+	tenantQuantities := func(period types.TierPeriod, start, end time.Time) (map[string]types.ResourceCosts, error) {
+		podCosts, _, err := calculatePodCosts(ctx, costRequest{Start: start, End: end, Step: req.Step, Opts: opts})
+		var partial *calculator.PartialResultError
+		if errors.As(err, &partial) {
+			missing = append(missing, partial.Missing...)
+		} else if err != nil {
+			return nil, fmt.Errorf("error calculating %s usage from %s: %w", period, start.Format(time.RFC3339), err)
+		}
+		quantities := make(map[string]types.ResourceCosts)
+		for tenant, tc := range calculator.AggregateTenantCosts(podCosts, pricing.IdleCostPolicy, pricing.Currency) {
+			quantities[tenant] = tc.Resources
+		}
+		return quantities, nil
+	}
+
+	for _, period := range calculator.TierPeriods(pricing.TieredPricing) {
+		var periodUsage calculator.PeriodUsage
+		if periodStart := period.Start(req.Start).Truncate(req.Step); periodStart.Before(req.Start) {
+			prior, err := tenantQuantities(period, periodStart, req.Start)
+			if err != nil {
+				return nil, err
+			}
+			periodUsage.Prior = prior
+		}
+		// Thresholds start over at every period boundary within the request
+		for boundary := period.Next(req.Start); boundary.Before(req.End); boundary = period.Next(boundary) {
+			from, to := boundary.Truncate(req.Step), period.Next(boundary).Truncate(req.Step)
+			if to.After(req.End) {
+				to = req.End
+			}
+			if !to.After(from) {
+				continue
+			}
+			later, err := tenantQuantities(period, from, to)
+			if err != nil {
+				return nil, err
+			}
+			periodUsage.Later = append(periodUsage.Later, later)
+		}
+		usage[period] = periodUsage
+	}
+	if len(missing) > 0 {
+		return usage, &calculator.PartialResultError{Missing: missing}
+	}
+	return usage, nil
+}
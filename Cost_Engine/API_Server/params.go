@@ -0,0 +1,199 @@
+// /params.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/types"
+)
+
+// costRequest holds the parsed, step-aligned parameters of a cost query
+type costRequest struct {
+	Start  time.Time
+	End    time.Time
+	Step   time.Duration
+	Opts   calculator.CalcOptions
+	Format exportFormat
+	// AllowPartial answers with the costs of the windows Prometheus returned when others failed (?partial=allow)
+	AllowPartial bool
+}
+
+// parseCostRequest reads window/start/end/step/billingMode/accounting/partial/format from the query string.
+// The range is given by start and end, or by window ending at end (default now) or starting at start,
+// and both bounds are aligned down to a multiple of step so contiguous requests never overlap.
+func parseCostRequest(r *http.Request, now time.Time) (costRequest, error) {
+	query := r.URL.Query()
+	req := costRequest{Step: defaultStep}
+
+	if stepQuery := query.Get("step"); stepQuery != "" {
+		step, err := time.ParseDuration(stepQuery)
+		if err != nil || step <= 0 {
+			return req, fmt.Errorf("Invalid 'step' duration format: %v", err)
+		}
+		req.Step = step
+	}
+
+	var window time.Duration
+	if windowQuery := query.Get("window"); windowQuery != "" {
+		d, err := time.ParseDuration(windowQuery)
+		if err != nil || d <= 0 {
+			return req, fmt.Errorf("Invalid 'window' duration format: %v. Use format like '5m', '1h'.", err)
+		}
+		window = d
+	}
+
+	var start, end time.Time
+	if startQuery := query.Get("start"); startQuery != "" {
+		t, err := parseTimeParam(startQuery)
+		if err != nil {
+			return req, fmt.Errorf("Invalid 'start' time: %v", err)
+		}
+		start = t
+	}
+	if endQuery := query.Get("end"); endQuery != "" {
+		t, err := parseTimeParam(endQuery)
+		if err != nil {
+			return req, fmt.Errorf("Invalid 'end' time: %v", err)
+		}
+		end = t
+	}
+
+	switch {
+	case !start.IsZero() && !end.IsZero():
+		if window > 0 {
+			return req, fmt.Errorf("'window' cannot be combined with both 'start' and 'end'")
+		}
+	case !start.IsZero() && window > 0:
+		end = start.Add(window)
+	case !start.IsZero():
+		end = now
+	case window > 0:
+		if end.IsZero() {
+			end = now
+		}
+		start = end.Add(-window)
+	default:
+		return req, fmt.Errorf("Missing 'window' or 'start' query parameter (e.g., ?window=5m or ?start=2025-01-01T00:00:00Z&end=2025-01-02T00:00:00Z)")
+	}
+
+	// Costs cannot be computed for steps that have not finished yet
+	if end.After(now) {
+		end = now
+	}
+	req.Start = start.Truncate(req.Step)
+	req.End = end.Truncate(req.Step)
+	if !req.End.After(req.Start) {
+		return req, fmt.Errorf("Empty time range after aligning to step %s: start=%s end=%s", req.Step, req.Start.Format(time.RFC3339), req.End.Format(time.RFC3339))
+	}
+
+	if modeQuery := query.Get("billingMode"); modeQuery != "" {
+		mode, err := types.ParseBillingMode(modeQuery)
+		if err != nil {
+			return req, err
+		}
+		req.Opts.BillingMode = mode
+	}
+	if accountingQuery := query.Get("accounting"); accountingQuery != "" {
+		accounting, err := types.ParseUsageAccounting(accountingQuery)
+		if err != nil {
+			return req, err
+		}
+		req.Opts.Accounting = accounting
+	}
+
+	switch partial := query.Get("partial"); partial {
+	case "", "deny":
+	case "allow":
+		req.AllowPartial = true
+	default:
+		return req, fmt.Errorf("Invalid 'partial' (allow, deny)")
+	}
+
+	format, err := parseExportFormat(r)
+	if err != nil {
+		return req, err
+	}
+	req.Format = format
+
+	return req, nil
+}
+
+// parseTimeParam parses an RFC3339 timestamp or Unix seconds (with optional fraction)
+func parseTimeParam(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if !strings.ContainsAny(s, "-:T") {
+		if secs, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Unix(0, int64(secs*float64(time.Second))), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is neither RFC3339 nor Unix seconds", s)
+}
+
+// writeCostHeaders echoes the exact aligned window, so callers can request the next contiguous period,
+// and the grouping rule set version, since the Payment Engine keys off group names
+func writeCostHeaders(w http.ResponseWriter, req costRequest) {
+	w.Header().Set("X-Cost-Window-Start", req.Start.Format(time.RFC3339))
+	w.Header().Set("X-Cost-Window-End", req.End.Format(time.RFC3339))
+	w.Header().Set("X-Grouping-Version", calc.Grouper().Version())
+}
+
+// acceptPartial lists the windows missing from a partial result in the X-Cost-Missing header (start/end
+// pairs) and, if the request allows partial results, lets it go on with the costs of the other windows.
+// Partial results are errors otherwise, so callers billing the costs never undercount silently.
+// Other errors are returned as is.
+func acceptPartial(w http.ResponseWriter, req costRequest, err error) ([]types.Window, error) {
+	var partial *calculator.PartialResultError
+	if !errors.As(err, &partial) {
+		return nil, err
+	}
+	ranges := make([]string, 0, len(partial.Missing))
+	for _, m := range partial.Missing {
+		ranges = append(ranges, m.Start.Format(time.RFC3339)+"/"+m.End.Format(time.RFC3339))
+	}
+	w.Header().Add("X-Cost-Missing", strings.Join(ranges, ","))
+	if !req.AllowPartial {
+		return nil, fmt.Errorf("partial result, retry or pass partial=allow: %w", err)
+	}
+	slog.Warn("Answering with partial costs", "missing", ranges)
+	return partial.Missing, nil
+}
+
+// writeCalcError answers a failed cost calculation, 503 for partial results the request did not allow
+func writeCalcError(w http.ResponseWriter, err error) {
+	var partial *calculator.PartialResultError
+	if errors.As(err, &partial) {
+		http.Error(w, "Service Unavailable: Prometheus did not answer for every window (see X-Cost-Missing), retry or pass partial=allow.", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Internal Server Error: Failed to calculate costs.", http.StatusInternalServerError)
+}
+
+// pricingVersion lists the pricing config versions that produced the rows, normally one,
+// several when clusters are priced separately, the version of the snapshot when there are no rows
+func pricingVersion(podCosts []types.PodCost, pricing *types.PricingConfig) string {
+	seen := make(map[string]bool)
+	var versions []string
+	for _, pc := range podCosts {
+		for _, version := range strings.Split(pc.PricingVersion, ",") {
+			if version != "" && !seen[version] {
+				seen[version] = true
+				versions = append(versions, version)
+			}
+		}
+	}
+	if len(versions) == 0 {
+		return pricing.Version
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
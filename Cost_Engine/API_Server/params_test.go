@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCostRequest(t *testing.T) {
+	defer func(step time.Duration) { defaultStep = step }(defaultStep)
+	defaultStep = time.Minute
+
+	now := time.Date(2025, 1, 2, 12, 34, 56, 0, time.UTC)
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		query     string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantStep  time.Duration
+		wantErr   bool
+	}{
+		{name: "RFC3339 range", query: "start=2025-01-01T00:00:00Z&end=2025-01-01T01:00:00Z", wantStart: day, wantEnd: day.Add(time.Hour), wantStep: time.Minute},
+		{name: "RFC3339 with offset", query: "start=2025-01-01T01:00:00%2B01:00&end=2025-01-01T02:00:00%2B01:00", wantStart: day, wantEnd: day.Add(time.Hour), wantStep: time.Minute},
+		{name: "Unix seconds", query: "start=1735689600&end=1735693200.5", wantStart: day, wantEnd: day.Add(time.Hour), wantStep: time.Minute},
+		{name: "bounds aligned to step", query: "start=2025-01-01T00:07:30Z&end=2025-01-01T01:14:59Z&step=5m", wantStart: day.Add(5 * time.Minute), wantEnd: day.Add(70 * time.Minute), wantStep: 5 * time.Minute},
+		{name: "window ending now", query: "window=1h", wantStart: now.Add(-time.Hour).Truncate(time.Minute), wantEnd: now.Truncate(time.Minute), wantStep: time.Minute},
+		{name: "window ending at end", query: "window=1h&end=2025-01-01T02:00:00Z", wantStart: day.Add(time.Hour), wantEnd: day.Add(2 * time.Hour), wantStep: time.Minute},
+		{name: "start with window", query: "start=2025-01-01T00:00:00Z&window=2h", wantStart: day, wantEnd: day.Add(2 * time.Hour), wantStep: time.Minute},
+		{name: "start alone ends now", query: "start=2025-01-02T12:00:00Z", wantStart: now.Truncate(time.Hour), wantEnd: now.Truncate(time.Minute), wantStep: time.Minute},
+		{name: "end clamped to now", query: "start=2025-01-02T12:00:00Z&end=2025-01-03T00:00:00Z", wantStart: now.Truncate(time.Hour), wantEnd: now.Truncate(time.Minute), wantStep: time.Minute},
+		{name: "end before start", query: "start=2025-01-01T01:00:00Z&end=2025-01-01T00:00:00Z", wantErr: true},
+		{name: "empty range after alignment", query: "start=2025-01-01T00:00:10Z&end=2025-01-01T00:00:50Z", wantErr: true},
+		{name: "window with start and end", query: "start=2025-01-01T00:00:00Z&end=2025-01-01T01:00:00Z&window=1h", wantErr: true},
+		{name: "no range", wantErr: true},
+		{name: "invalid start", query: "start=yesterday&window=1h", wantErr: true},
+		{name: "invalid step", query: "window=1h&step=-1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/getcost?"+tt.query, nil)
+			req, err := parseCostRequest(r, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCostRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !req.Start.Equal(tt.wantStart) || !req.End.Equal(tt.wantEnd) || req.Step != tt.wantStep {
+				t.Errorf("parseCostRequest() = %s - %s step %s, want %s - %s step %s", req.Start, req.End, req.Step, tt.wantStart, tt.wantEnd, tt.wantStep)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// /pods.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// podCostFilter holds exact-match filters on pod cost rows, empty fields match everything
+type podCostFilter struct {
+	Tenant, Namespace, Pod, Container string
+}
+
+func (f podCostFilter) match(pc types.PodCost) bool {
+	return (f.Tenant == "" || pc.Tenant == f.Tenant) &&
+		(f.Namespace == "" || pc.Namespace == f.Namespace) &&
+		(f.Pod == "" || pc.Pod == f.Pod) &&
+		(f.Container == "" || pc.Container == f.Container)
+}
+
+// handlePodCosts returns pod (or, with ?level=container, container) level cost rows,
+// filterable with ?tenant=, ?namespace=, ?pod= and ?container=, most expensive first.
+// Idle rows are only present with the "separate" idle policy and are never distributed here.
+func handlePodCosts(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	req, err := parseCostRequest(r, time.Now())
+	if err != nil {
+		slog.Warn("API request invalid parameters", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	level := query.Get("level")
+	switch level {
+	case "", "pod":
+		level = "pod"
+	case "container":
+		req.Opts.ByContainer = true
+	default:
+		http.Error(w, "Invalid 'level' (pod, container)", http.StatusBadRequest)
+		return
+	}
+	tenant, ok := tenantScope(w, r, query.Get("tenant"))
+	if !ok {
+		return
+	}
+	filter := podCostFilter{
+		Tenant:    tenant,
+		Namespace: query.Get("namespace"),
+		Pod:       query.Get("pod"),
+		Container: query.Get("container"),
+	}
+
+	slog.Info("API pod cost request received", "level", level, "filter", filter, "step", req.Step, "start", req.Start.Format(time.RFC3339), "end", req.End.Format(time.RFC3339))
+	writeCostHeaders(w, req)
+
+	podCosts, pricing, err := calculatePodCosts(ctx, req)
+	if _, err = acceptPartial(w, req, err); err != nil {
+		slog.Error("Error calculating pod costs via API", "level", level, "error", err)
+		writeCalcError(w, err)
+		return
+	}
+	w.Header().Set("X-Pricing-Version", pricingVersion(podCosts, pricing))
+
+	report := types.PodCostReport{
+		Window: types.Window{Start: req.Start, End: req.End},
+		Level:  level,
+		Items:  []types.PodCost{},
+	}
+	for _, pc := range podCosts {
+		if pc.Idle && pricing.IdleCostPolicy != types.IdleCostSeparate {
+			continue
+		}
+		if filter.match(pc) {
+			report.Items = append(report.Items, pc)
+		}
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		return report.Items[i].TotalCost > report.Items[j].TotalCost
+	})
+
+	slog.Info("Pod costs filtered successfully via API", "rows", len(report.Items), "total_rows", len(podCosts))
+
+	if req.Format != formatJSON {
+		writeCostRows(w, req.Format, podCostRows(report.Items))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if errEncode := json.NewEncoder(w).Encode(report); errEncode != nil {
+		slog.Error("Error encoding JSON response", "error", errEncode)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/auth"
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/grouping"
+	"simple-cost-calculator/internal/prom"
+	"simple-cost-calculator/internal/source"
+	"simple-cost-calculator/internal/types"
+)
+
+// useReplayCalculator serves the API from the replay of the calculator tests until the test ends: web of
+// user1 costs 1 and batch of user2 0.75, the idle capacity of node-a 6.25, at a 1m step
+func useReplayCalculator(t *testing.T, idlePolicy types.IdleCostPolicy) {
+	t.Helper()
+	replay, err := source.NewReplay("internal/calculator/testdata/replay", prom.StandaloneCAdvisor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouper, err := grouping.NewGrouper(&grouping.BuiltinConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pricing := &types.PricingConfig{
+		Prices:         types.Prices{DefaultCPUPricePerHour: 6, DefaultRAMPricePerGBHour: 3},
+		IdleCostPolicy: idlePolicy,
+		BillingMode:    types.BillingModeUsage,
+	}
+	calc = calculator.NewCostCalculator(replay, pricing, grouper)
+	step := defaultStep
+	defaultStep = time.Minute
+	t.Cleanup(func() { calc, defaultStep = nil, step })
+}
+
+func TestHandlePodCosts(t *testing.T) {
+	useReplayCalculator(t, types.IdleCostDistribute)
+	tenantKey := auth.Principal{Name: "user1-key", Role: types.AuthRoleTenant, Tenant: "user1"}
+	window := "start=2025-01-01T00:00:00Z&end=2025-01-01T00:10:00Z"
+
+	tests := []struct {
+		name       string
+		principal  *auth.Principal
+		query      string
+		wantPods   []string
+		wantStatus int
+	}{
+		// Idle rows are left out with the distribute policy
+		{name: "every pod most expensive first", wantPods: []string{"web", "batch"}},
+		{name: "namespace filter", query: "&namespace=ns1-user2", wantPods: []string{"batch"}},
+		{name: "tenant filter", query: "&tenant=user1", wantPods: []string{"web"}},
+		{name: "filters combined", query: "&tenant=user1&namespace=ns1-user2", wantPods: []string{}},
+		{name: "tenant key scoped to its tenant", principal: &tenantKey, wantPods: []string{"web"}},
+		{name: "tenant key filtering its namespaces", principal: &tenantKey, query: "&namespace=ns1-user2", wantPods: []string{}},
+		{name: "tenant key asking for another tenant", principal: &tenantKey, query: "&tenant=user2", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.principal != nil {
+				authenticator = &auth.Authenticator{}
+				defer func() { authenticator = nil }()
+			}
+			r := httptest.NewRequest("GET", "/costs/pods?"+window+tt.query, nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.NewContext(r.Context(), *tt.principal))
+			}
+			w := httptest.NewRecorder()
+			handlePodCosts(w, r)
+
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if w.Code != wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, wantStatus, w.Body)
+			}
+			if tt.wantStatus != 0 {
+				return
+			}
+			var report types.PodCostReport
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			pods := []string{}
+			for _, pc := range report.Items {
+				pods = append(pods, pc.Pod)
+			}
+			if !slices.Equal(pods, tt.wantPods) {
+				t.Errorf("pods = %v, want %v", pods, tt.wantPods)
+			}
+		})
+	}
+}
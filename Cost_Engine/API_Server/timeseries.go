@@ -0,0 +1,55 @@
+// /timeseries.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"simple-cost-calculator/internal/types"
+)
+
+// handleCostTimeSeries returns per-tenant and per-namespace cost for each step of the window
+func handleCostTimeSeries(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	req, err := parseCostRequest(r, time.Now())
+	if err != nil {
+		slog.Warn("API request invalid parameters", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Flat exports are row per pod or namespace, use /costs/pods or /v2/costs for spreadsheets
+	if req.Format != formatJSON {
+		http.Error(w, "Only JSON is available for time series", http.StatusNotAcceptable)
+		return
+	}
+
+	slog.Info("API time series request received", "step", req.Step, "billing_mode", req.Opts.BillingMode, "start", req.Start.Format(time.RFC3339), "end", req.End.Format(time.RFC3339))
+	writeCostHeaders(w, req)
+
+	series, err := calc.CalculateCostTimeSeries(ctx, req.Start, req.End, req.Step, req.Opts)
+	if _, err = acceptPartial(w, req, err); err != nil {
+		slog.Error("Error calculating cost time series via API", "step", req.Step, "error", err)
+		writeCalcError(w, err)
+		return
+	}
+	w.Header().Set("X-Pricing-Version", series.PricingVersion)
+	if p := principal(r); !p.AllTenants() {
+		unreadable := func(cs types.CostSeries) bool { return !p.CanRead(cs.Tenant) }
+		series.Tenants = slices.DeleteFunc(series.Tenants, unreadable)
+		series.Namespaces = slices.DeleteFunc(series.Namespaces, unreadable)
+	}
+
+	slog.Info("Cost time series calculated successfully via API", "tenants", len(series.Tenants), "namespaces", len(series.Namespaces))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if errEncode := json.NewEncoder(w).Encode(series); errEncode != nil {
+		slog.Error("Error encoding JSON response", "error", errEncode)
+	}
+}
@@ -0,0 +1,99 @@
+// /v2.go
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/types"
+)
+
+// APIVersionV2 is reported in every v2 response
+const APIVersionV2 = "v2"
+
+//go:embed schema/costs-v2.schema.json
+var costsV2Schema []byte
+
+// handleCostsV2 returns the typed cost report: tenant -> {window, total, currency, namespaces, resources}
+func handleCostsV2(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	req, err := parseCostRequest(r, time.Now())
+	if err != nil {
+		slog.Warn("API request invalid parameters", "path", r.URL.Path, "query", r.URL.RawQuery, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slog.Info("API v2 cost request received", "step", req.Step, "billing_mode", req.Opts.BillingMode, "start", req.Start.Format(time.RFC3339), "end", req.End.Format(time.RFC3339))
+	writeCostHeaders(w, req)
+
+	podCosts, pricing, err := calculatePodCosts(ctx, req)
+	missing, err := acceptPartial(w, req, err)
+	if err != nil {
+		slog.Error("Error calculating pod costs via API", "step", req.Step, "error", err)
+		writeCalcError(w, err)
+		return
+	}
+	w.Header().Set("X-Pricing-Version", pricingVersion(podCosts, pricing))
+
+	prior, err := tierUsage(ctx, req, pricing)
+	priorMissing, err := acceptPartial(w, req, err)
+	if err != nil {
+		slog.Error("Error calculating prior tier usage via API", "error", err)
+		writeCalcError(w, err)
+		return
+	}
+
+	report := buildCostReportV2(req, pricing, podCosts, prior, append(missing, priorMissing...))
+	visibleTenants(r, report.Tenants)
+	slog.Info("Costs rearranged successfully via API", "api_version", APIVersionV2, "user_groups", len(report.Tenants))
+
+	if req.Format != formatJSON {
+		writeCostRows(w, req.Format, namespaceCostRows(report.Tenants))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if errEncode := json.NewEncoder(w).Encode(report); errEncode != nil {
+		slog.Error("Error encoding JSON response", "error", errEncode)
+	}
+}
+
+// buildCostReportV2 aggregates pod costs into the v2 schema with the pricing snapshot they were priced with
+// and the windows missing from them
+func buildCostReportV2(req costRequest, pricing *types.PricingConfig, podCosts []types.PodCost, prior calculator.TierUsage, missing []types.Window) types.CostReportV2 {
+	billingMode := pricing.BillingMode
+	if req.Opts.BillingMode != "" {
+		billingMode = req.Opts.BillingMode
+	}
+
+	return types.CostReportV2{
+		APIVersion:      APIVersionV2,
+		Window:          types.Window{Start: req.Start, End: req.End},
+		Step:            req.Step.String(),
+		Currency:        pricing.Currency,
+		BillingMode:     billingMode,
+		IdleCostPolicy:  pricing.IdleCostPolicy,
+		GroupingVersion: calc.Grouper().Version(),
+		PricingVersion:  pricingVersion(podCosts, pricing),
+		Tenants:         calculator.TenantCosts(podCosts, pricing, prior),
+		Missing:         missing,
+	}
+}
+
+// handleCostsV2Schema serves the JSON Schema of the v2 cost report
+func handleCostsV2Schema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(costsV2Schema); err != nil {
+		slog.Error("Error writing schema response", "error", err)
+	}
+}
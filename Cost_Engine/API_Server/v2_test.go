@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"simple-cost-calculator/internal/calculator"
+	"simple-cost-calculator/internal/types"
+)
+
+func TestBuildCostReportV2(t *testing.T) {
+	useReplayCalculator(t, types.IdleCostNone)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := costRequest{Start: start, End: start.Add(time.Hour), Step: time.Minute, Opts: calculator.CalcOptions{BillingMode: types.BillingModeMax}}
+	pricing := &types.PricingConfig{Currency: "EUR", BillingMode: types.BillingModeUsage, IdleCostPolicy: types.IdleCostNone, Version: "v1"}
+	podCosts := []types.PodCost{
+		{Tenant: "user1", Namespace: "ns1-user1", Pod: "web", CPUBilledCoreHours: 0.5, RAMBilledGiBHours: 2, CPUCost: 3, RAMCost: 1, TotalCost: 4, PricingVersion: "v1"},
+		{Tenant: "user1", Namespace: "ns2-user1", Pod: "api", CPUBilledCoreHours: 0.25, RAMBilledGiBHours: 1, CPUCost: 1.5, RAMCost: 0.5, TotalCost: 2, PricingVersion: "v1"},
+	}
+	gap := types.Window{Start: start.Add(10 * time.Minute), End: start.Add(20 * time.Minute)}
+
+	tests := []struct {
+		name        string
+		missing     []types.Window
+		wantMissing bool
+	}{
+		{name: "complete", wantMissing: false},
+		{name: "partial", missing: []types.Window{gap}, wantMissing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := buildCostReportV2(req, pricing, podCosts, nil, tt.missing)
+			body, err := json.Marshal(report)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var shape map[string]json.RawMessage
+			if err := json.Unmarshal(body, &shape); err != nil {
+				t.Fatal(err)
+			}
+			wantKeys := []string{"apiVersion", "billingMode", "currency", "groupingVersion", "idleCostPolicy", "pricingVersion", "step", "tenants", "window"}
+			if tt.wantMissing {
+				wantKeys = append(wantKeys, "missing")
+			}
+			slices.Sort(wantKeys)
+			if keys := slices.Sorted(maps.Keys(shape)); !slices.Equal(keys, wantKeys) {
+				t.Fatalf("report keys = %v, want %v", keys, wantKeys)
+			}
+
+			// The billing mode of the request wins over the pricing file
+			if report.APIVersion != APIVersionV2 || report.Step != "1m0s" || report.Currency != "EUR" || report.BillingMode != types.BillingModeMax || report.PricingVersion != "v1" {
+				t.Errorf("report = %+v", report)
+			}
+			if !slices.Equal(report.Missing, tt.missing) {
+				t.Errorf("missing = %v, want %v", report.Missing, tt.missing)
+			}
+
+			tenant := report.Tenants["user1"]
+			if len(report.Tenants) != 1 || tenant == nil {
+				t.Fatalf("tenants = %v, want user1", report.Tenants)
+			}
+			want := types.ResourceCosts{
+				CPU: types.ResourceCost{Quantity: 0.75, Unit: types.UnitCoreHours, Cost: 4.5},
+				RAM: types.ResourceCost{Quantity: 3, Unit: types.UnitGiBHours, Cost: 1.5},
+			}
+			if tenant.Resources != want || tenant.Total != 6 || tenant.Currency != "EUR" {
+				t.Errorf("user1 = %+v, want total 6 EUR split %+v", tenant, want)
+			}
+			if len(tenant.Namespaces) != 2 {
+				t.Fatalf("user1 namespaces = %+v, want ns1-user1 and ns2-user1", tenant.Namespaces)
+			}
+			for _, ns := range tenant.Namespaces {
+				if ns.Resources.CPU.Unit != types.UnitCoreHours || ns.Resources.RAM.Unit != types.UnitGiBHours || ns.Resources.CPU.Cost+ns.Resources.RAM.Cost != ns.Total {
+					t.Errorf("namespace %s = %+v, want its total split into core-hours and GiB-hours", ns.Name, ns)
+				}
+			}
+		})
+	}
+}
@@ -32,8 +32,10 @@ func init() {
 func main() {
 	// --- Define Flags ---
 	apiUrl := flag.String("api-url", "http://localhost:9991", "Base URL of the cost API server")
+	apiVersion := flag.String("api-version", "v2", "Cost API version: v2 (/v2/costs, typed) or v1 (/getcost, legacy)")
 	apiWindow := flag.String("api-window", "15m", "Window parameter for the cost API (e.g., 5m, 15m, 1h)")
 	apiStep := flag.String("api-step", "1m", "Step parameter for the cost API (e.g., 1m, 5m)")
+	apiTokenFile := flag.String("api-token-file", "", "File holding the service token of the cost API (COST_API_TOKEN env var if empty, none if both are empty)")
 
 	grpcAddress := flag.String("grpc-address", "localhost:9090", "gRPC endpoint of the streampayd node (host:port)")
 
@@ -52,6 +54,8 @@ func main() {
 	gasFeeAmount := flag.Int64("gas-fee-amount", 10, "Amount for gas fee")
 	gasFeeDenom := flag.String("gas-fee-denom", "stake", "Denomination for gas fee (use stake unit if empty)")
 
+	contiguous := flag.Bool("contiguous", true, "Bill each cycle from the end of the previously billed window instead of the trailing -api-window")
+	ledgerFile := flag.String("ledger-file", "ledger.json", "File the end of each user's billed window is saved to with -contiguous, so billing resumes there after a restart (kept in memory only if empty)")
 	interval := flag.Duration("interval", 15*time.Minute, "Frequency to run the payment cycle (e.g., 5m, 15m, 1h)")
 
 	flag.Parse()
@@ -77,12 +81,26 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error: Flag -api-url is not a valid URL: %v", err)
 	}
+	if *apiVersion != "v1" && *apiVersion != "v2" {
+		log.Fatal("Error: Flag -api-version must be v1 or v2.")
+	}
 	if *apiWindow == "" {
 		log.Fatal("Error: Flag -api-window is required.")
 	}
 	if *apiStep == "" {
 		log.Fatal("Error: Flag -api-step is required.")
 	}
+	apiToken := os.Getenv("COST_API_TOKEN")
+	if *apiTokenFile != "" {
+		token, err := os.ReadFile(*apiTokenFile)
+		if err != nil {
+			log.Fatalf("Error reading -api-token-file %s: %v", *apiTokenFile, err)
+		}
+		apiToken = strings.TrimSpace(string(token))
+		if apiToken == "" {
+			log.Fatalf("Error: -api-token-file %s is empty.", *apiTokenFile)
+		}
+	}
 
 	if *grpcAddress == "" {
 		log.Fatal("Error: Flag -grpc-address is required.")
@@ -119,9 +137,11 @@ func main() {
 
 	// --- Create Config ---
 	cfg := config.Config{
-		ApiUrl:    *apiUrl,
-		ApiWindow: *apiWindow,
-		ApiStep:   *apiStep,
+		ApiUrl:     *apiUrl,
+		ApiVersion: *apiVersion,
+		ApiToken:   apiToken,
+		ApiWindow:  *apiWindow,
+		ApiStep:    *apiStep,
 
 		GrpcAddress: *grpcAddress,
 
@@ -142,6 +162,8 @@ func main() {
 	// --- Print loaded configuration ---
 	log.Println("--- Payment Engine Configuration (gRPC Mode) ---")
 	log.Printf(" API URL: %s", cfg.ApiUrl)
+	log.Printf(" API Version: %s", cfg.ApiVersion)
+	log.Printf(" API Token: %t", cfg.ApiToken != "") // Never log the token itself
 	log.Printf(" API Window: %s", cfg.ApiWindow)
 	log.Printf(" API Step: %s", cfg.ApiStep)
 
@@ -157,13 +179,32 @@ func main() {
 	log.Printf(" Gas Fee: %d %s", cfg.GasFeeAmount, cfg.GasFeeDenom)
 	log.Printf(" Dry Run Mode: %t", cfg.DryRun)
 	log.Printf(" Interval: %s", interval.String()) // Log interval
+	log.Printf(" Contiguous Billing: %t", *contiguous)
+	log.Printf(" Ledger File: %s", *ledgerFile)
 	log.Println("----------------------------")
 
 	// --- Setup Signal Handling for Graceful Shutdown ---
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
+	// How far each user is billed, the next cycle starts there when billing contiguously
+	var ledger *processor.Ledger
+	if *contiguous {
+		if *ledgerFile == "" {
+			log.Println("WARNING: -ledger-file is empty, the first cycle after a restart bills the trailing -api-window again.")
+			ledger = processor.NewLedger()
+		} else {
+			ledger, err = processor.LoadLedger(*ledgerFile)
+			if err != nil {
+				log.Fatalf("Error loading the ledger: %v", err)
+			}
+			if !ledger.End.IsZero() {
+				log.Printf("Resuming billing from %s (%d users to retry)", ledger.End.Format(time.RFC3339), len(ledger.Retry))
+			}
+		}
+	}
+
 	log.Println("Running initial payment cycle...")
-	runCycle(cfg) // Gọi hàm riêng để dễ đọc
+	runCycle(cfg, ledger) // Gọi hàm riêng để dễ đọc
 
 	// --- Start Periodic Execution ---
 	log.Printf("Starting periodic payment cycle every %s", interval.String())
@@ -174,7 +215,7 @@ func main() {
 		select {
 		case <-ticker.C: // Waits for next tick
 			log.Printf("Ticker triggered. Running payment cycle at %s...", time.Now().Format(time.RFC3339))
-			runCycle(cfg)
+			runCycle(cfg, ledger)
 		case <-ctx.Done(): // Receives stop signal (Ctrl+C)
 			log.Println("Received shutdown signal. Stopping payment engine...")
 			// Can perform final cleanup here if needed
@@ -183,10 +224,12 @@ func main() {
 	}
 }
 
-// runCycle wraps the call to processor.RunPaymentCycle and handles logging/errors
-func runCycle(cfg config.Config) {
+// runCycle wraps the call to processor.RunPaymentCycle and handles logging/errors.
+// The ledger (nil without contiguous billing) only moves past what was billed: users whose payment
+// failed are retried over their unbilled window in later cycles.
+func runCycle(cfg config.Config, ledger *processor.Ledger) {
 	log.Println("----- Starting new payment cycle -----")
-	err := processor.RunPaymentCycle(cfg) // This function needs to return an error for main to handle if desired
+	err := processor.RunPaymentCycle(cfg, ledger)
 	if err != nil {
 		// In periodic mode, do not exit(1) immediately when an error occurs
 		// Just log the error and continue with the next cycle (unless the error is too serious)
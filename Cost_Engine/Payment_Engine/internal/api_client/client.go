@@ -15,13 +15,30 @@ import (
 
 const defaultTimeout = 30 * time.Second // Timeout for API request
 
+// API paths per API version
+var apiPaths = map[string]string{
+	"v1": "/getcost",
+	"v2": "/v2/costs",
+}
+
 // FetchCostData calls the cost API and parses the response.
-// Returns a map with the key being the user ID (or "system") and the value being UserData.
-func FetchCostData(apiUrl, window, step string) (map[string]model.UserData, error) {
+// When since is set, costs are requested from since up to until (now if zero) instead of the trailing window,
+// so consecutive cycles bill contiguous, non-overlapping periods.
+// The API server reads every tenant's costs only for a service (or admin) token, sent when apiToken is set.
+// Returns a map with the key being the user ID (or "system") and the value being UserData,
+// and the exact window the API computed (zero if the API did not report it).
+func FetchCostData(apiUrl, apiVersion, apiToken, window, step string, since, until time.Time) (map[string]model.UserData, model.Window, error) {
+	var billedWindow model.Window
+
+	apiPath, ok := apiPaths[apiVersion]
+	if !ok {
+		return nil, billedWindow, fmt.Errorf("unsupported API version '%s'", apiVersion)
+	}
+
 	// 1. Construct the URL with query parameters
-	fullUrl, err := buildUrl(apiUrl, window, step)
+	fullUrl, err := buildUrl(apiUrl, apiPath, window, step, since, until)
 	if err != nil {
-		return nil, fmt.Errorf("error building API URL: %w", err)
+		return nil, billedWindow, fmt.Errorf("error building API URL: %w", err)
 	}
 	log.Printf("Fetching cost data from: %s", fullUrl)
 
@@ -29,41 +46,76 @@ func FetchCostData(apiUrl, window, step string) (map[string]model.UserData, erro
 	client := &http.Client{Timeout: defaultTimeout}
 	req, err := http.NewRequest("GET", fullUrl, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating API request: %w", err)
+		return nil, billedWindow, fmt.Errorf("error creating API request: %w", err)
+	}
+	if apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+apiToken)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		// Handle network errors (timeout, connection refused, etc.)
-		return nil, fmt.Errorf("error executing API request to %s: %w", fullUrl, err)
+		return nil, billedWindow, fmt.Errorf("error executing API request to %s: %w", fullUrl, err)
 	}
 	defer resp.Body.Close()
 
 	// 3. Check HTTP status code
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, billedWindow, fmt.Errorf("API rejected the service token with status code %d, check -api-token-file", resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
 		// Log the response body for debugging
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
 		log.Printf("API returned non-OK status: %d. Response body: %s", resp.StatusCode, bodyString)
-		return nil, fmt.Errorf("API request failed with status code %d", resp.StatusCode)
+		return nil, billedWindow, fmt.Errorf("API request failed with status code %d", resp.StatusCode)
+	}
+
+	// Billing a partial result would undercount the missing windows and move past them for good
+	if missing := resp.Header.Get("X-Cost-Missing"); missing != "" {
+		return nil, billedWindow, fmt.Errorf("API returned partial costs, missing windows %s", missing)
+	}
+
+	// The aligned window is echoed in headers, also for empty responses
+	windowStart, errS := time.Parse(time.RFC3339, resp.Header.Get("X-Cost-Window-Start"))
+	windowEnd, errE := time.Parse(time.RFC3339, resp.Header.Get("X-Cost-Window-End"))
+	if errS == nil && errE == nil {
+		billedWindow = model.Window{Start: windowStart, End: windowEnd}
 	}
 
 	// 4. Read and Unmarshal the response body
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading API response body: %w", err)
+		return nil, billedWindow, fmt.Errorf("error reading API response body: %w", err)
 	}
 
 	if len(bodyBytes) == 0 {
 		log.Println("API returned an empty response body.")
-		return make(map[string]model.UserData), nil // Return empty map, not an error
+		return make(map[string]model.UserData), billedWindow, nil // Return empty map, not an error
+	}
+
+	if apiVersion == "v2" {
+		var report model.CostReportV2
+		if err := json.Unmarshal(bodyBytes, &report); err != nil {
+			log.Printf("Raw JSON response: %s", string(bodyBytes)) // Log raw response on error
+			return nil, billedWindow, fmt.Errorf("error parsing JSON response from API: %w", err)
+		}
+		if len(report.Missing) > 0 {
+			return nil, billedWindow, fmt.Errorf("API returned partial costs, %d missing windows", len(report.Missing))
+		}
+		log.Printf("Cost report: currency %s, grouping version %s", report.Currency, report.GroupingVersion)
+		parsedData := make(map[string]model.UserData, len(report.Tenants))
+		for key, tenant := range report.Tenants {
+			parsedData[key] = tenant.UserData()
+		}
+		return parsedData, billedWindow, nil
 	}
 
 	var rawData model.CostData // Reuse the CostData type (map[string]interface{})
 	if err := json.Unmarshal(bodyBytes, &rawData); err != nil {
 		// Provide context for JSON errors
 		log.Printf("Raw JSON response: %s", string(bodyBytes)) // Log raw response on error
-		return nil, fmt.Errorf("error parsing JSON response from API: %w", err)
+		return nil, billedWindow, fmt.Errorf("error parsing JSON response from API: %w", err)
 	}
 
 	// 5. Parse raw data into UserData map (using the existing logic from model)
@@ -73,34 +125,41 @@ func FetchCostData(apiUrl, window, step string) (map[string]model.UserData, erro
 		if ok {
 			parsedData[key] = userData
 		} else {
-			// Log a warning if a specific user's data couldn't be parsed
-			log.Printf("Warning: Could not parse valid data for key '%s' from API response\n", key)
+			// Dropping the user would skip its costs for good once the window is billed
+			return nil, billedWindow, fmt.Errorf("could not parse valid data for key '%s' from API response", key)
 		}
 	}
 
-	return parsedData, nil
+	return parsedData, billedWindow, nil
 }
 
 // buildUrl constructs the full URL with query parameters safely.
-func buildUrl(baseUrl, window, step string) (string, error) {
+func buildUrl(baseUrl, apiPath, window, step string, since, until time.Time) (string, error) {
 	u, err := url.Parse(baseUrl)
 	if err != nil {
 		return "", err
 	}
 
-	// Ensure the path is correct (e.g., "/getcost")
+	// Ensure the path is correct (e.g., "/v2/costs")
 	// This assumes the baseUrl might just be "http://localhost:9991"
 	// Adjust if baseUrl already contains the path
 	if u.Path == "" || u.Path == "/" { // Add path if missing or root
-		u.Path = "/getcost" // Or make this configurable if needed
-	} else if !strings.HasSuffix(u.Path, "/getcost") {
-		// Append if the path exists but doesn't end with /getcost
+		u.Path = apiPath
+	} else if !strings.HasSuffix(u.Path, apiPath) {
+		// Append if the path exists but doesn't end with the API path
 		// Or handle this case based on expected input for baseUrl
-		u.Path = strings.TrimSuffix(u.Path, "/") + "/getcost"
+		u.Path = strings.TrimSuffix(u.Path, "/") + apiPath
 	}
 
 	q := u.Query()
-	q.Set("window", window)
+	if since.IsZero() {
+		q.Set("window", window)
+	} else {
+		q.Set("start", since.Format(time.RFC3339))
+		if !until.IsZero() {
+			q.Set("end", until.Format(time.RFC3339))
+		}
+	}
 	q.Set("step", step)
 	u.RawQuery = q.Encode()
 
@@ -2,9 +2,11 @@ package config
 
 // Config contains all configuration parameters for Payment Engine
 type Config struct {
-	ApiUrl    string // URL of the API endpoint
-	ApiWindow string
-	ApiStep   string
+	ApiUrl     string // URL of the API endpoint
+	ApiVersion string // "v2" (/v2/costs) or "v1" (/getcost)
+	ApiToken   string // Service token sent as a bearer token, none if empty
+	ApiWindow  string
+	ApiStep    string
 
 	//grpc config
 	GrpcAddress string
@@ -12,7 +12,10 @@ type Window struct {
 
 // UserData contains cost information for a user or system
 type UserData struct {
-	TotalCost      float64
+	TotalCost float64
+	// Discount is the tier discount already taken off TotalCost, the namespace costs are list prices.
+	// Only /v2 reports it.
+	Discount       float64
 	Window         Window
 	NamespaceCosts map[string]float64
 }
@@ -20,6 +23,46 @@ type UserData struct {
 // CostData represents the entire contents of the JSON file read in
 type CostData map[string]interface{}
 
+// CostReportV2 is the typed /v2/costs response of the cost API
+type CostReportV2 struct {
+	APIVersion      string                `json:"apiVersion"`
+	Window          Window                `json:"window"`
+	Currency        string                `json:"currency"`
+	GroupingVersion string                `json:"groupingVersion"`
+	Tenants         map[string]TenantCost `json:"tenants"`
+	// Missing lists the windows the API could not compute, the report undercounts them
+	Missing []Window `json:"missing"`
+}
+
+// TenantCost is the cost of one tenant group in a v2 report
+type TenantCost struct {
+	Window     Window          `json:"window"`
+	Total      float64         `json:"total"`
+	Discount   float64         `json:"discount"`
+	Currency   string          `json:"currency"`
+	Namespaces []NamespaceCost `json:"namespaces"`
+}
+
+// NamespaceCost is the cost of one namespace of a tenant in a v2 report
+type NamespaceCost struct {
+	Name  string  `json:"name"`
+	Total float64 `json:"total"`
+}
+
+// UserData converts a v2 tenant cost into UserData
+func (t TenantCost) UserData() UserData {
+	user := UserData{
+		TotalCost:      t.Total,
+		Discount:       t.Discount,
+		Window:         t.Window,
+		NamespaceCosts: make(map[string]float64, len(t.Namespaces)),
+	}
+	for _, ns := range t.Namespaces {
+		user.NamespaceCosts[ns.Name] = ns.Total
+	}
+	return user
+}
+
 // ParseUserData processes interface{} data into a specific UserData
 // Returns UserData and a boolean indicating whether the parse was successful
 func ParseUserData(data interface{}) (UserData, bool) {
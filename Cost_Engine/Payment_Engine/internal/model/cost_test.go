@@ -82,6 +82,24 @@ func TestParseUserData(t *testing.T) {
 			checkWindow:  true,
 			checkNsCosts: true,
 		},
+		{
+			name: "Namespace named discount",
+			input: map[string]interface{}{
+				"app-prod":  2.0,
+				"discount":  0.5,
+				"totalCost": 1.5,
+				"window": map[string]interface{}{
+					"start": validStartRFC3339Str,
+					"end":   validEndRFC3339Str,
+				},
+			},
+			wantUser: UserData{
+				TotalCost:      1.5,
+				NamespaceCosts: map[string]float64{"app-prod": 2.0, "discount": 0.5},
+			},
+			wantOk:       true,
+			checkNsCosts: true,
+		},
 		{
 			name: "Valid data without namespace costs",
 			input: map[string]interface{}{
@@ -245,6 +263,9 @@ func TestParseUserData(t *testing.T) {
 				if gotUser.TotalCost != tc.wantUser.TotalCost {
 					t.Errorf("ParseUserData() got TotalCost = %v, want %v", gotUser.TotalCost, tc.wantUser.TotalCost)
 				}
+				if gotUser.Discount != tc.wantUser.Discount {
+					t.Errorf("ParseUserData() got Discount = %v, want %v", gotUser.Discount, tc.wantUser.Discount)
+				}
 
 				// 3. Kiểm tra Window (nếu cần)
 				if tc.checkWindow {
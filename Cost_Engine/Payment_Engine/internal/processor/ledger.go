@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"payment-engine/internal/model"
+)
+
+// Ledger tracks how far each user has been billed when billing contiguous windows, so a window is never
+// skipped for a user whose payment failed nor charged twice to the others.
+type Ledger struct {
+	// End of the last billed window, every user is billed up to it except the ones in Retry
+	End time.Time `json:"end"`
+	// Retry maps the users whose payment failed to the end of their last billed window
+	Retry map[string]time.Time `json:"retry"`
+
+	// path of the file the ledger is saved to, kept in memory only if empty
+	path string
+}
+
+// NewLedger creates an empty ledger kept in memory, the first cycle bills the trailing API window
+func NewLedger() *Ledger {
+	return &Ledger{Retry: make(map[string]time.Time)}
+}
+
+// LoadLedger reads the ledger saved at path, so billing resumes where it stopped before a restart.
+// A missing file gives an empty ledger, saved there from then on.
+func LoadLedger(path string) (*Ledger, error) {
+	ledger := NewLedger()
+	ledger.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading ledger file '%s': %w", path, err)
+	}
+	if err := json.Unmarshal(data, ledger); err != nil {
+		return nil, fmt.Errorf("error parsing ledger file '%s': %w", path, err)
+	}
+	if ledger.Retry == nil {
+		ledger.Retry = make(map[string]time.Time)
+	}
+	return ledger, nil
+}
+
+// Save writes the ledger to its file, replacing the previous one atomically so a crash never leaves it
+// half written. Ledgers kept in memory are not saved.
+func (l *Ledger) Save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error saving ledger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving ledger: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving ledger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving ledger: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("error saving ledger: %w", err)
+	}
+	return nil
+}
+
+// advance moves the ledger to the end of a billed window, the failed users retrying from its start
+// (or from where they already were)
+func (l *Ledger) advance(window model.Window, failed []string) {
+	for _, userID := range failed {
+		if _, ok := l.Retry[userID]; !ok {
+			l.Retry[userID] = window.Start
+		}
+	}
+	l.End = window.End
+}
+
+// retries groups the users to retry by the start of their unbilled window, oldest first
+func (l *Ledger) retries() ([]time.Time, map[time.Time][]string) {
+	groups := make(map[time.Time][]string)
+	for userID, start := range l.Retry {
+		groups[start] = append(groups[start], userID)
+	}
+	starts := make([]time.Time, 0, len(groups))
+	for start, users := range groups {
+		sort.Strings(users)
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	return starts, groups
+}
+
+// settle marks a user as billed up to End
+func (l *Ledger) settle(userID string) {
+	delete(l.Retry, userID)
+}
@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"payment-engine/internal/model"
+)
+
+func TestLedger(t *testing.T) {
+	t0 := time.Date(2025, 4, 10, 0, 0, 0, 0, time.UTC)
+	window := func(from, to int) model.Window {
+		return model.Window{Start: t0.Add(time.Duration(from) * 15 * time.Minute), End: t0.Add(time.Duration(to) * 15 * time.Minute)}
+	}
+	ledger := NewLedger()
+
+	// user2 fails in the first window and again in the second, user3 in the second only
+	ledger.advance(window(0, 1), []string{"user2"})
+	ledger.advance(window(1, 2), []string{"user2", "user3"})
+	if want := window(1, 2).End; !ledger.End.Equal(want) {
+		t.Errorf("End = %s, want %s", ledger.End, want)
+	}
+	starts, groups := ledger.retries()
+	wantStarts := []time.Time{window(0, 1).Start, window(1, 2).Start}
+	wantGroups := map[time.Time][]string{wantStarts[0]: {"user2"}, wantStarts[1]: {"user3"}}
+	if !reflect.DeepEqual(starts, wantStarts) || !reflect.DeepEqual(groups, wantGroups) {
+		t.Errorf("retries() = %v %v, want %v %v", starts, groups, wantStarts, wantGroups)
+	}
+
+	ledger.settle("user2")
+	if _, ok := ledger.Retry["user2"]; ok || len(ledger.Retry) != 1 {
+		t.Errorf("Retry = %v after settling user2, want only user3", ledger.Retry)
+	}
+}
+
+func TestLedgerSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.json")
+	ledger, err := LoadLedger(path)
+	if err != nil || !ledger.End.IsZero() || len(ledger.Retry) != 0 {
+		t.Fatalf("LoadLedger() of a missing file = %+v, %v, want an empty ledger", ledger, err)
+	}
+
+	t0 := time.Date(2025, 4, 10, 0, 0, 0, 0, time.UTC)
+	ledger.advance(model.Window{Start: t0, End: t0.Add(15 * time.Minute)}, []string{"user2"})
+	if err := ledger.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart resumes from the saved window
+	restarted, err := LoadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restarted.End.Equal(ledger.End) || !reflect.DeepEqual(restarted.Retry, ledger.Retry) {
+		t.Errorf("restarted ledger = %+v, want %+v", restarted, ledger)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadLedger(path); err == nil {
+		t.Errorf("LoadLedger() of a corrupt file succeeded, want an error")
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"payment-engine/internal/api_client"
 	"payment-engine/internal/config"
+	"payment-engine/internal/model"
 	"payment-engine/internal/streampay"
 )
 
@@ -49,13 +50,42 @@ func setupCodec(registry types.InterfaceRegistry) codec.ProtoCodecMarshaler {
 	return codec.NewProtoCodec(registry)
 }
 
+// payOutcome is the result of billing one user
+type payOutcome int
+
+const (
+	paySent payOutcome = iota
+	// paySkipped amounts under the minimum are not charged
+	paySkipped
+	payKeyError
+	paySendError
+)
+
+// cycleSummary counts the outcomes of a payment cycle
+type cycleSummary struct {
+	success, skipped, keyErrors, sendErrors int
+	// failed lists the users whose payment failed, to be retried
+	failed []string
+}
+
 // RunPaymentCycle performs a complete payment processing cycle using gRPC for bank transfers.
-func RunPaymentCycle(cfg config.Config) error {
+// With a ledger (contiguous billing), the cycle bills from the end of the previously billed window, retries
+// the users whose earlier payments failed over their unbilled window and records what was billed. Without
+// one, the trailing API window is billed.
+func RunPaymentCycle(cfg config.Config, ledger *Ledger) error {
 	log.Printf("===== Start payment cycle at %s =====", time.Now().Format(time.RFC3339))
 	if cfg.DryRun {
 		log.Println("[DRY RUN MODE ENABLED] Will not execute gRPC calls.")
 	}
 
+	// --- Get Provider Address ---
+	providerSdkAddr, err := sdk.AccAddressFromBech32(cfg.ProviderAddress)
+	if err != nil {
+		log.Printf("[FATAL ERROR] Invalid provider address '%s': %v", cfg.ProviderAddress, err)
+		return fmt.Errorf("invalid provider address: %w", err)
+	}
+	log.Printf("Provider address: %s", providerSdkAddr.String())
+
 	// --- Initialize gRPC Client and Codec ---
 	registry := setupInterfaceRegistry()
 	cdc := setupCodec(registry)
@@ -68,177 +98,261 @@ func RunPaymentCycle(cfg config.Config) error {
 	defer grpcClient.Close()
 
 	// 1. Fetch cost data from API
-	log.Printf("Fetching cost data from API: %s (Window: %s, Step: %s)", cfg.ApiUrl, cfg.ApiWindow, cfg.ApiStep)
-	costData, err := api_client.FetchCostData(cfg.ApiUrl, cfg.ApiWindow, cfg.ApiStep)
+	var since time.Time
+	if ledger != nil {
+		since = ledger.End
+	}
+	if since.IsZero() {
+		log.Printf("Fetching cost data from API: %s (Window: %s, Step: %s)", cfg.ApiUrl, cfg.ApiWindow, cfg.ApiStep)
+	} else {
+		log.Printf("Fetching cost data from API: %s (Since: %s, Step: %s)", cfg.ApiUrl, since.Format(time.RFC3339), cfg.ApiStep)
+	}
+	costData, billedWindow, err := api_client.FetchCostData(cfg.ApiUrl, cfg.ApiVersion, cfg.ApiToken, cfg.ApiWindow, cfg.ApiStep, since, time.Time{})
 	if err != nil {
+		// Nothing was billed, the next cycle fetches the same window again
 		log.Printf("[FATAL ERROR] Failed to fetch or parse cost data from API: %v", err)
 		log.Printf("===== End of cycle (API error) at %s =====", time.Now().Format(time.RFC3339))
 		return fmt.Errorf("error fetching cost data from API: %w", err)
 	}
-	if len(costData) == 0 {
-		log.Println("API returned no user cost data. End of cycle.")
-		log.Printf("===== End of cycle (no data) at %s =====", time.Now().Format(time.RFC3339))
-		return nil
-	}
+	log.Printf("Billing window: %s - %s", billedWindow.Start.Format(time.RFC3339), billedWindow.End.Format(time.RFC3339))
 	log.Printf("Successfully fetched and parsed %d items from API.", len(costData))
 
-	// 2. Loop through each user and process
-	successCount := 0
-	skippedCount := 0
-	keyErrorCount := 0
-	sendErrorCount := 0
-
-	// --- Get Provider Address ---
-	providerSdkAddr, err := sdk.AccAddressFromBech32(cfg.ProviderAddress)
-	if err != nil {
-		log.Printf("[FATAL ERROR] Invalid provider address '%s': %v", cfg.ProviderAddress, err)
-		return fmt.Errorf("invalid provider address: %w", err)
+	// 2. Bill each user, the ones retrying are billed over their own window below
+	var retrying map[string]time.Time
+	if ledger != nil {
+		retrying = ledger.Retry
 	}
-	log.Printf("Provider address: %s", providerSdkAddr.String())
-
-	for userID, userData := range costData {
-		if strings.ToLower(userID) == "system" {
-			continue
+	summary := payUsers(cfg, grpcClient, providerSdkAddr, costData, func(userID string) bool {
+		_, ok := retrying[userID]
+		return !ok
+	})
+	fetchErrors, ledgerErrors := 0, 0
+	if ledger != nil {
+		if billedWindow.End.IsZero() {
+			log.Println("[ERROR] API did not report the billed window, cannot bill contiguously.")
+			fetchErrors++
+		} else {
+			// Users failing in this window are retried from the next cycle on
+			starts, groups := ledger.retries()
+			ledger.advance(billedWindow, summary.failed)
+			// Saved before retrying, so a restart never bills the window again
+			if err := ledger.Save(); err != nil {
+				log.Printf("[ERROR] Failed to save the ledger: %v", err)
+				ledgerErrors++
+			}
+			fetchErrors += retryUsers(cfg, grpcClient, providerSdkAddr, ledger, starts, groups, &summary)
+			if err := ledger.Save(); err != nil {
+				log.Printf("[ERROR] Failed to save the ledger: %v", err)
+				ledgerErrors++
+			}
 		}
+	}
+
+	// 3. Logging the cycle summary
+	log.Printf("===== Payment cycle ended at %s =====", time.Now().Format(time.RFC3339))
+	log.Printf("Summary: Success: %d, Skipped (min amount): %d, Key Error: %d, Send Error: %d",
+		summary.success, summary.skipped, summary.keyErrors, summary.sendErrors)
+	if ledger != nil && len(ledger.Retry) > 0 {
+		log.Printf("Users to retry next cycle: %d", len(ledger.Retry))
+	}
 
-		log.Printf("--- Processing User: %s ---", userID)
-		log.Printf(" Original Cost: %.6f", userData.TotalCost)
+	// Returns an error if any key, send, retry fetch or ledger errors occurred
+	if summary.keyErrors > 0 || summary.sendErrors > 0 || fetchErrors > 0 || ledgerErrors > 0 {
+		return fmt.Errorf("there were %d key errors, %d send errors, %d fetch errors and %d ledger errors in cycle", summary.keyErrors, summary.sendErrors, fetchErrors, ledgerErrors)
+	}
 
-		// Get private key from MNEMONIC
-		var senderPrivKey cryptotypes.PrivKey
-		mnemonicFilename := fmt.Sprintf("%s_MNEMONIC.txt", userID)
-		mnemonicPath := filepath.Join(cfg.KeyDirectory, mnemonicFilename)
+	return nil
+}
 
-		// Get content of mnemonic file
-		log.Printf(" Reading mnemonic file: %s", mnemonicPath)
-		mnemonicBytes, err := os.ReadFile(mnemonicPath)
+// retryUsers bills the users whose earlier payments failed (grouped by the end of their last billed window)
+// up to the ledger end, settling the ones paid. Returns the number of failed fetches.
+func retryUsers(cfg config.Config, grpcClient *streampay.GrpcClient, providerSdkAddr sdk.AccAddress, ledger *Ledger, starts []time.Time, groups map[time.Time][]string, summary *cycleSummary) int {
+	fetchErrors := 0
+	for _, start := range starts {
+		users := groups[start]
+		log.Printf("Retrying %d users from %s to %s", len(users), start.Format(time.RFC3339), ledger.End.Format(time.RFC3339))
+		costData, window, err := api_client.FetchCostData(cfg.ApiUrl, cfg.ApiVersion, cfg.ApiToken, cfg.ApiWindow, cfg.ApiStep, start, ledger.End)
 		if err != nil {
-			if os.IsNotExist(err) {
-				log.Printf(" [ERROR] Mnemonic file not found for User %s: %s", userID, mnemonicPath)
-			} else {
-				log.Printf(" [ERROR] Failed to read mnemonic file for User %s (%s): %v", userID, mnemonicPath, err)
-			}
-			keyErrorCount++
-			log.Println("---------------------------------")
+			log.Printf("[ERROR] Failed to fetch cost data to retry: %v", err)
+			fetchErrors++
 			continue
 		}
-
-		// Get mnemonic string from bytes
-		mnemonic := strings.TrimSpace(string(mnemonicBytes))
-		if mnemonic == "" {
-			log.Printf(" [ERROR] Mnemonic file is empty for User %s: %s", userID, mnemonicPath)
-			keyErrorCount++
-			log.Println("---------------------------------")
+		if !window.Start.Equal(start) || !window.End.Equal(ledger.End) {
+			log.Printf("[ERROR] API answered for %s - %s instead of the window to retry", window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+			fetchErrors++
 			continue
 		}
+		inGroup := make(map[string]bool, len(users))
+		for _, userID := range users {
+			inGroup[userID] = true
+		}
+		retried := payUsers(cfg, grpcClient, providerSdkAddr, costData, func(userID string) bool { return inGroup[userID] })
+		failed := make(map[string]bool, len(retried.failed))
+		for _, userID := range retried.failed {
+			failed[userID] = true
+		}
+		// Users without costs in the window owe nothing
+		for _, userID := range users {
+			if !failed[userID] {
+				ledger.settle(userID)
+			}
+		}
+		summary.success += retried.success
+		summary.skipped += retried.skipped
+		summary.keyErrors += retried.keyErrors
+		summary.sendErrors += retried.sendErrors
+	}
+	return fetchErrors
+}
 
-		// 4. Derive Private Key từ Mnemonic (Đã fix cho Cosmos SDK v0.47.9)
-		log.Printf(" Deriving private key from mnemonic for User %s...", userID)
-
-		fullPath := sdk.GetConfig().GetFullBIP44Path()
-
-		derivedRawPrivKey, err := hd.Secp256k1.Derive()(mnemonic, "", fullPath)
-		if err != nil {
-			log.Printf(" [ERROR] Failed to derive raw key for User %s using path %s: %v", userID, fullPath, err)
-			keyErrorCount++
-			log.Println("---------------------------------")
+// payUsers bills every user of the cost data that bill selects
+func payUsers(cfg config.Config, grpcClient *streampay.GrpcClient, providerSdkAddr sdk.AccAddress, costData map[string]model.UserData, bill func(userID string) bool) cycleSummary {
+	var summary cycleSummary
+	for userID, userData := range costData {
+		// "system" and the idle capacity group ("__idle__") are not billed to any user
+		if strings.ToLower(userID) == "system" || userID == "__idle__" || !bill(userID) {
 			continue
 		}
+		switch payUser(cfg, grpcClient, providerSdkAddr, userID, userData) {
+		case paySent:
+			summary.success++
+		case paySkipped:
+			summary.skipped++
+		case payKeyError:
+			summary.keyErrors++
+			summary.failed = append(summary.failed, userID)
+		case paySendError:
+			summary.sendErrors++
+			summary.failed = append(summary.failed, userID)
+		}
+	}
+	return summary
+}
 
-		senderPrivKey = hd.Secp256k1.Generate()(derivedRawPrivKey)
+// payUser sends the payment of one user for its cost over the billed window
+func payUser(cfg config.Config, grpcClient *streampay.GrpcClient, providerSdkAddr sdk.AccAddress, userID string, userData model.UserData) payOutcome {
+	log.Printf("--- Processing User: %s ---", userID)
+	log.Printf(" Original Cost: %.6f", userData.TotalCost)
 
-		senderAddr := sdk.AccAddress(senderPrivKey.PubKey().Address())
-		log.Printf(" Derived key for User %s. Address: %s", userID, senderAddr.String())
+	// Get private key from MNEMONIC
+	var senderPrivKey cryptotypes.PrivKey
+	mnemonicFilename := fmt.Sprintf("%s_MNEMONIC.txt", userID)
+	mnemonicPath := filepath.Join(cfg.KeyDirectory, mnemonicFilename)
 
-		// Calculate transfer amount
-		amountStakeFloat := userData.TotalCost * cfg.CostToStakeRate
-		amountStakeInt := int64(math.Ceil(amountStakeFloat))
+	// Get content of mnemonic file
+	log.Printf(" Reading mnemonic file: %s", mnemonicPath)
+	mnemonicBytes, err := os.ReadFile(mnemonicPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf(" [ERROR] Mnemonic file not found for User %s: %s", userID, mnemonicPath)
+		} else {
+			log.Printf(" [ERROR] Failed to read mnemonic file for User %s (%s): %v", userID, mnemonicPath, err)
+		}
+		log.Println("---------------------------------")
+		return payKeyError
+	}
 
-		log.Printf(" Conversion rate: %.2f", cfg.CostToStakeRate)
-		log.Printf(" Transfer amount (before min check): %d %s", amountStakeInt, cfg.StakeUnit)
+	// Get mnemonic string from bytes
+	mnemonic := strings.TrimSpace(string(mnemonicBytes))
+	if mnemonic == "" {
+		log.Printf(" [ERROR] Mnemonic file is empty for User %s: %s", userID, mnemonicPath)
+		log.Println("---------------------------------")
+		return payKeyError
+	}
 
-		if amountStakeInt < cfg.MinStakeAmount {
-			log.Printf(" Transfer amount %d is less than minimum %d. Skip.", amountStakeInt, cfg.MinStakeAmount)
-			skippedCount++
-			log.Println("---------------------------------")
-			continue
-		}
+	// 4. Derive Private Key từ Mnemonic (Đã fix cho Cosmos SDK v0.47.9)
+	log.Printf(" Deriving private key from mnemonic for User %s...", userID)
 
-		// Create sdk.Coin for transfer amount
-		amountCoin := sdk.NewCoin(cfg.StakeUnit, sdk.NewInt(amountStakeInt))
-		log.Printf(" Transfer amount to send: %s", amountCoin.String())
+	fullPath := sdk.GetConfig().GetFullBIP44Path()
 
-		// Calculate payment fee (chỉ để log, không dùng trong tx)
-		feeStakeFloat := float64(amountStakeFloat) * feePercentage
-		feeStakeInt := int64(math.Ceil(feeStakeFloat))
-		if feeStakeInt < minFeeAmount {
-			feeStakeInt = minFeeAmount
-		}
-		_ = sdk.NewCoin(cfg.StakeUnit, sdk.NewInt(feeStakeInt)) // Tính nhưng không gán vào đâu cả
-		// log.Printf("  (Informational) Calculated Payment Fee (1%% of %d, min %d): %s", amountStakeInt, minFeeAmount, paymentFeeCoin.String()) // Log nếu muốn
-
-		// Create sdk.Coin for gas fee
-		gasFeeCoin := sdk.NewCoin(cfg.GasFeeDenom, sdk.NewInt(cfg.GasFeeAmount))
-		log.Printf("  Gas Fee: %s", gasFeeCoin.String())
-		log.Printf("  Gas Limit: %d", cfg.GasLimit)
-
-		// Prepare parameters for gRPC bank transfer call
-		sendParams := streampay.SendTxParams{
-			SenderPrivateKey: senderPrivKey,
-			RecipientAddress: providerSdkAddr.String(),
-			Amount:           amountCoin,
-			GasLimit:         cfg.GasLimit,
-			GasFee:           gasFeeCoin,
-			Memo:             fmt.Sprintf("Payment for user %s", userID),
-		}
+	derivedRawPrivKey, err := hd.Secp256k1.Derive()(mnemonic, "", fullPath)
+	if err != nil {
+		log.Printf(" [ERROR] Failed to derive raw key for User %s using path %s: %v", userID, fullPath, err)
+		log.Println("---------------------------------")
+		return payKeyError
+	}
 
-		// Send payment via gRPC (bank transfer)
-		log.Printf(" Prepare to send %s to %s via gRPC (Bank Transfer - Tx Fee: %s, Gas: %d)",
-			amountCoin.String(), sendParams.RecipientAddress, gasFeeCoin.String(), cfg.GasLimit)
+	senderPrivKey = hd.Secp256k1.Generate()(derivedRawPrivKey)
 
-		// --- Thực hiện gửi nếu không phải DryRun ---
-		var txResponse *sdk.TxResponse
-		var sendErr error
+	senderAddr := sdk.AccAddress(senderPrivKey.PubKey().Address())
+	log.Printf(" Derived key for User %s. Address: %s", userID, senderAddr.String())
 
-		if cfg.DryRun {
-			log.Println(" [DRY RUN] Skipping gRPC BroadcastTx call.")
-			txResponse = &sdk.TxResponse{
-				TxHash: fmt.Sprintf("dry-run-tx-hash-for-%s", userID),
-				Code:   0,
-			}
-			sendErr = nil
-		} else {
-			txResponse, sendErr = grpcClient.SendBankTransferViaGrpc(sendParams)
-		}
-		// -----------------------------------------
+	// Calculate transfer amount
+	amountStakeFloat := userData.TotalCost * cfg.CostToStakeRate
+	amountStakeInt := int64(math.Ceil(amountStakeFloat))
 
-		if sendErr != nil {
-			log.Printf(" [ERROR] Failed to send payment to User %s via gRPC: %v", userID, sendErr)
-			if txResponse != nil {
-				log.Printf("   TxResponse Code: %d, RawLog: %s", txResponse.Code, txResponse.RawLog)
-			}
-			sendErrorCount++
-		} else if txResponse != nil {
-			log.Printf(" [SUCCESS] Successfully sent payment for User %s! TxHash: %s", userID, txResponse.TxHash)
-			successCount++
-		} else if !cfg.DryRun {
-			log.Printf(" [ERROR] SendBankTransferViaGrpc returned nil response and nil error for User %s.", userID)
-			sendErrorCount++
-		}
-		// --------------------
+	log.Printf(" Conversion rate: %.2f", cfg.CostToStakeRate)
+	log.Printf(" Transfer amount (before min check): %d %s", amountStakeInt, cfg.StakeUnit)
 
+	if amountStakeInt < cfg.MinStakeAmount {
+		log.Printf(" Transfer amount %d is less than minimum %d. Skip.", amountStakeInt, cfg.MinStakeAmount)
 		log.Println("---------------------------------")
+		return paySkipped
 	}
 
-	// 3. Logging the cycle summary
-	log.Printf("===== Payment cycle ended at %s =====", time.Now().Format(time.RFC3339))
-	log.Printf("Summary: Success: %d, Skipped (min amount): %d, Key Error: %d, Send Error: %d",
-		successCount, skippedCount, keyErrorCount, sendErrorCount)
+	// Create sdk.Coin for transfer amount
+	amountCoin := sdk.NewCoin(cfg.StakeUnit, sdk.NewInt(amountStakeInt))
+	log.Printf(" Transfer amount to send: %s", amountCoin.String())
 
-	// Returns an error if any key or send errors occurred
-	if keyErrorCount > 0 || sendErrorCount > 0 {
-		return fmt.Errorf("there were %d key errors and %d send errors in cycle", keyErrorCount, sendErrorCount)
+	// Calculate payment fee (chỉ để log, không dùng trong tx)
+	feeStakeFloat := float64(amountStakeFloat) * feePercentage
+	feeStakeInt := int64(math.Ceil(feeStakeFloat))
+	if feeStakeInt < minFeeAmount {
+		feeStakeInt = minFeeAmount
+	}
+	_ = sdk.NewCoin(cfg.StakeUnit, sdk.NewInt(feeStakeInt)) // Tính nhưng không gán vào đâu cả
+	// log.Printf("  (Informational) Calculated Payment Fee (1%% of %d, min %d): %s", amountStakeInt, minFeeAmount, paymentFeeCoin.String()) // Log nếu muốn
+
+	// Create sdk.Coin for gas fee
+	gasFeeCoin := sdk.NewCoin(cfg.GasFeeDenom, sdk.NewInt(cfg.GasFeeAmount))
+	log.Printf("  Gas Fee: %s", gasFeeCoin.String())
+	log.Printf("  Gas Limit: %d", cfg.GasLimit)
+
+	// Prepare parameters for gRPC bank transfer call
+	sendParams := streampay.SendTxParams{
+		SenderPrivateKey: senderPrivKey,
+		RecipientAddress: providerSdkAddr.String(),
+		Amount:           amountCoin,
+		GasLimit:         cfg.GasLimit,
+		GasFee:           gasFeeCoin,
+		Memo:             fmt.Sprintf("Payment for user %s", userID),
 	}
 
-	return nil
+	// Send payment via gRPC (bank transfer)
+	log.Printf(" Prepare to send %s to %s via gRPC (Bank Transfer - Tx Fee: %s, Gas: %d)",
+		amountCoin.String(), sendParams.RecipientAddress, gasFeeCoin.String(), cfg.GasLimit)
+
+	// --- Thực hiện gửi nếu không phải DryRun ---
+	var txResponse *sdk.TxResponse
+	var sendErr error
+
+	if cfg.DryRun {
+		log.Println(" [DRY RUN] Skipping gRPC BroadcastTx call.")
+		txResponse = &sdk.TxResponse{
+			TxHash: fmt.Sprintf("dry-run-tx-hash-for-%s", userID),
+			Code:   0,
+		}
+		sendErr = nil
+	} else {
+		txResponse, sendErr = grpcClient.SendBankTransferViaGrpc(sendParams)
+	}
+	// -----------------------------------------
+
+	outcome := paySent
+	if sendErr != nil {
+		log.Printf(" [ERROR] Failed to send payment to User %s via gRPC: %v", userID, sendErr)
+		if txResponse != nil {
+			log.Printf("   TxResponse Code: %d, RawLog: %s", txResponse.Code, txResponse.RawLog)
+		}
+		outcome = paySendError
+	} else if txResponse != nil {
+		log.Printf(" [SUCCESS] Successfully sent payment for User %s! TxHash: %s", userID, txResponse.TxHash)
+	} else {
+		log.Printf(" [ERROR] SendBankTransferViaGrpc returned nil response and nil error for User %s.", userID)
+		outcome = paySendError
+	}
+	// --------------------
+
+	log.Println("---------------------------------")
+	return outcome
 }
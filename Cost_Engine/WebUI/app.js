@@ -7,8 +7,12 @@ document.addEventListener('DOMContentLoaded', () => {
     const windowSelect = document.getElementById('window-select');
     const refreshButton = document.getElementById('refresh-button');
 
+    const forecastPeriodEl = document.getElementById('forecast-period');
+    const forecastBody = document.querySelector('#forecast-table tbody');
+
     let currentChart = null; 
     const API_BASE_URL = '/getcost'; 
+    const FORECAST_URL = '/forecast';
 
     function formatDate(dateStr) {
         if (!dateStr) return 'N/A';
@@ -83,7 +87,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 const allNamespaces = new Set();
                 users.forEach(user => {
                     Object.keys(data[user]).forEach(key => {
-                        if (key !== 'totalCost' && key !== 'window') {
+                        if (key !== 'totalCost' && key !== 'window' && key !== 'clusters') {
                             allNamespaces.add(key);
                         }
                     });
@@ -163,7 +167,52 @@ document.addEventListener('DOMContentLoaded', () => {
             });
     }
 
-    refreshButton.addEventListener('click', loadCostData);
+    function loadForecast() {
+        forecastPeriodEl.textContent = 'Loading...';
+        forecastBody.innerHTML = '';
+
+        fetch(FORECAST_URL)
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => {
+                         throw new Error(`HTTP error ${response.status}: ${text || response.statusText}`);
+                    });
+                }
+                return response.json();
+            })
+            .then(data => {
+                forecastPeriodEl.textContent = `${formatDate(data.period?.start)} - ${formatDate(data.period?.end)}`;
+
+                const tenants = Object.keys(data.tenants || {}).sort();
+                if (tenants.length === 0) {
+                    const row = forecastBody.insertRow();
+                    const cell = row.insertCell();
+                    cell.colSpan = 6;
+                    cell.textContent = 'No cost history available yet.';
+                    return;
+                }
+
+                tenants.forEach(tenant => {
+                    const f = data.tenants[tenant];
+                    const row = forecastBody.insertRow();
+                    row.insertCell().textContent = tenant;
+                    [f.spentToDate, f.expected, f.lower, f.upper].forEach(value => {
+                        row.insertCell().textContent = `${value.toFixed(2)} ${data.currency || ''}`;
+                    });
+                    row.insertCell().textContent = f.model;
+                });
+            })
+            .catch(error => {
+                console.error("Error fetching forecast:", error);
+                forecastPeriodEl.textContent = `Error loading forecast: ${error.message}`;
+            });
+    }
+
+    refreshButton.addEventListener('click', () => {
+        loadCostData();
+        loadForecast();
+    });
 
     loadCostData();
+    loadForecast();
 });
\ No newline at end of file